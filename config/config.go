@@ -1,8 +1,12 @@
 package config
 
 import (
+	// the config file shipped with the code
+	_ "embed"
 	"os"
 
+	"healerdb/mytypes"
+
 	// yaml
 	"gopkg.in/yaml.v2"
 )
@@ -40,10 +44,44 @@ healerdb:
           target_based: false
         - name: "log"
           target_based: true
+          indexes:
+              - collection: "*"
+                keys: ["expires_at"]
+                ttl_seconds: 0
+              - collection: "*"
+                keys: ["timestamp:-1"]
+              - collection: "*"
+                keys: ["database", "collection", "document_id"]
+        - name: "safe-panel"
+          target_based: false
+          indexes:
+              - collection: "users"
+                keys: ["email"]
+                unique: true
+        - name: "schema"
+          target_based: false
+    audit:
+        enabled: false
+        actor: "healerdb"
+        retention_days: 90
+
+The indexes of a database are declared with keys "field", "field:-1", "field:text", "field:hashed" or "field:2dsphere" and the
+options name, unique, sparse, ttl_seconds, partial (a json filter) and collation (locale, strength), collection "*" means every
+target collection of a target based database. They are the only source of the indexes the code relies on: dbquery creates the
+declared indexes of a collection on first use and the indexes command syncs them, so an index missing here gets dropped by
+'healerdb indexes -drop'. When the deployed file can't be read the databases and indexes of the config file shipped with the
+code (config/config.yaml, embedded at build time) are used
 
 Now we should define a Config type based on the above config file
 */
 
+// Database is a database declared in the config file with the indexes of its collections
+type Database struct {
+	Name        string              `yaml:"name"`
+	TargetBased bool                `yaml:"target_based"`
+	Indexes     []mytypes.IndexSpec `yaml:"indexes"`
+}
+
 type Config struct {
 	HealerDB struct {
 		Connstr   string `yaml:"connstr"`
@@ -51,10 +89,13 @@ type Config struct {
 			Username string `yaml:"username"`
 			Password string `yaml:"password"`
 		} `yaml:"conncreds"`
-		Dbs []struct {
-			Name        string `yaml:"name"`
-			TargetBased bool   `yaml:"target_based"`
-		} `yaml:"dbs"`
+		Dbs                   []Database `yaml:"dbs"`
+		AllowNonTransactional bool       `yaml:"allow_non_transactional"`
+		Audit                 struct {
+			Enabled       bool   `yaml:"enabled"`
+			Actor         string `yaml:"actor"`
+			RetentionDays int    `yaml:"retention_days"`
+		} `yaml:"audit"`
 	} `yaml:"healerdb"`
 }
 
@@ -86,6 +127,21 @@ func ReadFileAsYaml(path string, v interface{}) error {
 	return yaml.Unmarshal([]byte(data), v)
 }
 
+// the config file as shipped with the code, the databases and their indexes are part of the schema the code relies on
+//
+//go:embed config.yaml
+var defaultConfig []byte
+
+// Function ReadDefaultConfig to read the config file shipped with the code
+func ReadDefaultConfig() (*Config, error) {
+	config := &Config{}
+	err := yaml.Unmarshal(defaultConfig, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
 // Function to read the config file and return a Config type
 func ReadConfig() (*Config, error) {
 	config := &Config{}
@@ -114,23 +170,36 @@ func GetConnCreds() (string, string, error) {
 	return config.HealerDB.Conncreds.Username, config.HealerDB.Conncreds.Password, nil
 }
 
-// Function GetAllDatabases : to Read all the databases from the config file and return a slice of struct of them
-func GetDatabases() ([]struct {
-	Name        string `yaml:"name"`
-	TargetBased bool   `yaml:"target_based"`
-}, error) {
+// Function GetAllDatabases : to Read all the databases from the config file and return a slice of struct of them, the config file shipped with the code is used when the deployed one can't be read
+func GetDatabases() ([]Database, error) {
 	config, err := ReadConfig()
 	if err != nil {
-		return nil, err
+		config, err = ReadDefaultConfig()
 	}
-	var dbs []struct {
-		Name        string `yaml:"name"`
-		TargetBased bool   `yaml:"target_based"`
+	if err != nil {
+		return nil, err
 	}
+	var dbs []Database
 	dbs = append(dbs, config.HealerDB.Dbs...)
 	return dbs, nil
 }
 
+// Function GetIndexSpecs to read the index declarations of every database from the config file, the database of each index is filled in
+func GetIndexSpecs() ([]mytypes.IndexSpec, error) {
+	dbs, err := GetDatabases()
+	if err != nil {
+		return nil, err
+	}
+	specs := []mytypes.IndexSpec{}
+	for _, db := range dbs {
+		for _, spec := range db.Indexes {
+			spec.Database = db.Name
+			specs = append(specs, spec)
+		}
+	}
+	return specs, nil
+}
+
 // Function GetDbs to read the database names from the config file
 func GetDatabasesNames() ([]string, error) {
 	dbs_names := []string{}
@@ -143,3 +212,28 @@ func GetDatabasesNames() ([]string, error) {
 	}
 	return dbs_names, nil
 }
+
+// Function GetAuditConfig to read the audit log settings from the config file, or the embedded one if it can't be read, returns whether auditing is enabled, the actor and the retention in days
+func GetAuditConfig() (bool, string, int, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		config, err = ReadDefaultConfig()
+	}
+	if err != nil {
+		return false, "", 0, err
+	}
+	audit := config.HealerDB.Audit
+	return audit.Enabled, audit.Actor, audit.RetentionDays, nil
+}
+
+// Function GetAllowNonTransactional to read whether multi-collection writes may run without a transaction on a standalone server, from the config file or the embedded one if it can't be read
+func GetAllowNonTransactional() (bool, error) {
+	config, err := ReadConfig()
+	if err != nil {
+		config, err = ReadDefaultConfig()
+	}
+	if err != nil {
+		return false, err
+	}
+	return config.HealerDB.AllowNonTransactional, nil
+}
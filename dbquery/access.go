@@ -0,0 +1,300 @@
+package dbquery
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Access control           ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// prefix of the api tokens, a token looks like hdb_<token id>_<secret>
+const apiTokenPrefix = "hdb_"
+
+// roles created by EnsureDefaultRoles
+var DefaultRoles = []mytypes.Role{
+	{Name: "admin", Description: "every action on every database", Permissions: []mytypes.Permission{{Action: mytypes.ActionAll, Database: "*"}}},
+	{Name: "reader", Description: "read every database", Permissions: []mytypes.Permission{{Action: mytypes.ActionRead, Database: "*"}}},
+}
+
+// function EnsureAccessIndexes to create the declared indexes of the roles and tokens collections, returns an error
+func EnsureAccessIndexes(client *mongo.Client) error {
+	err := ensureIndexes(client, PanelDatabase, PanelRoles)
+	if err != nil {
+		return fmt.Errorf("[-] Error creating access indexes: %v", err)
+	}
+	err = ensureIndexes(client, PanelDatabase, PanelTokens)
+	if err != nil {
+		return fmt.Errorf("[-] Error creating access indexes: %v", err)
+	}
+
+	return nil
+}
+
+// function EnsureDefaultRoles to create the default roles that don't exist yet, existing roles are left untouched, returns an error
+func EnsureDefaultRoles(client *mongo.Client) error {
+	coll := client.Database(PanelDatabase).Collection(PanelRoles)
+	for _, role := range DefaultRoles {
+		update := bson.M{"$setOnInsert": bson.M{"name": role.Name, "description": role.Description, "permissions": role.Permissions}}
+		result, err := coll.UpdateOne(context.TODO(), bson.M{"name": role.Name}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("[-] Error creating default roles: %v", err)
+		}
+		if result.UpsertedID != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditInsert, PanelDatabase, PanelRoles, nil, nil, bson.M{"_id": result.UpsertedID, "name": role.Name, "description": role.Description, "permissions": role.Permissions}, 1, nil)
+		}
+	}
+
+	return nil
+}
+
+// function SetRole to create or replace (by name) a role, the change is audited under the actor of ctx, returns an error
+func SetRole(ctx context.Context, client *mongo.Client, role mytypes.Role) error {
+	if role.Name == "" {
+		return fmt.Errorf("[-] Error setting role: name is required")
+	}
+	for _, perm := range role.Permissions {
+		if perm.Action == "" || perm.Database == "" {
+			return fmt.Errorf("[-] Error setting role: permissions need an action and a database")
+		}
+	}
+	filter := bson.M{"name": role.Name}
+	set := bson.M{"description": role.Description, "permissions": role.Permissions}
+	before := bson.M{}
+	err := client.Database(PanelDatabase).Collection(PanelRoles).FindOneAndUpdate(ctx, filter, bson.M{"$set": set}, options.FindOneAndUpdate().SetUpsert(true)).Decode(&before)
+	operation := mytypes.AuditUpdate
+	if err == mongo.ErrNoDocuments {
+		// the upsert created the role
+		before, operation, err = nil, mytypes.AuditInsert, nil
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error setting role: %v", err)
+	}
+	after := auditDocument(set)
+	after["name"] = role.Name
+	if before != nil {
+		after["_id"] = before["_id"]
+	}
+	auditOperation(ctx, client, operation, PanelDatabase, PanelRoles, filter, before, after, 1, nil)
+	fmt.Println("[+] Set role successfully")
+
+	return nil
+}
+
+// function GetRoles to get the roles with the given names (all roles if names is nil), returns a slice of roles and an error
+func GetRoles(client *mongo.Client, names []string) ([]mytypes.Role, error) {
+	filter := bson.M{}
+	if names != nil {
+		filter["name"] = bson.M{"$in": names}
+	}
+	cursor, err := client.Database(PanelDatabase).Collection(PanelRoles).Find(context.TODO(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting roles: %v", err)
+	}
+	roles := []mytypes.Role{}
+	err = cursor.All(context.TODO(), &roles)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding roles: %v", err)
+	}
+
+	return roles, nil
+}
+
+// function DeleteRole to delete a role and remove it from every user, both changes are audited under the actor of ctx, returns an error
+func DeleteRole(ctx context.Context, client *mongo.Client, name string) error {
+	filter := bson.M{"name": name}
+	before := bson.M{}
+	err := client.Database(PanelDatabase).Collection(PanelRoles).FindOneAndDelete(ctx, filter).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("[-] Error deleting role: role doesn't exist")
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error deleting role: %v", err)
+	}
+	auditOperation(ctx, client, mytypes.AuditDelete, PanelDatabase, PanelRoles, filter, before, nil, 1, nil)
+	userfilter := bson.M{"roles": name}
+	result, err := client.Database(PanelDatabase).Collection(PanelUsers).UpdateMany(ctx, userfilter, bson.M{"$pull": bson.M{"roles": name}})
+	if err != nil {
+		auditOperation(ctx, client, mytypes.AuditUpdate, PanelDatabase, PanelUsers, userfilter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error removing role from users: %v", err)
+	}
+	auditOperation(ctx, client, mytypes.AuditUpdate, PanelDatabase, PanelUsers, userfilter, nil, nil, result.ModifiedCount, nil)
+	fmt.Println("[+] Deleted role successfully")
+
+	return nil
+}
+
+// function PermissionAllows to check whether a single permission grants the action on the database and target
+func PermissionAllows(perm mytypes.Permission, action string, database string, target string) bool {
+	if perm.Action != mytypes.ActionAll && perm.Action != action {
+		return false
+	}
+	if perm.Database != "*" && perm.Database != database {
+		return false
+	}
+	if len(perm.Targets) > 0 && !myutils.ContainsString(perm.Targets, target) {
+		return false
+	}
+	return true
+}
+
+// function Authorize to check whether the panel user may perform the action (read, write, delete, admin) on the database and target (empty for non-target databases), disabled and unknown users are never authorized, returns the decision and an error
+func Authorize(client *mongo.Client, username string, action string, database string, target string) (bool, error) {
+	user := &mytypes.PanelUser{}
+	err := client.Database(PanelDatabase).Collection(PanelUsers).FindOne(context.TODO(), bson.M{"username": username}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("[-] Error authorizing user: %v", err)
+	}
+	if user.Disabled || len(user.Roles) == 0 {
+		return false, nil
+	}
+
+	roles, err := GetRoles(client, user.Roles)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error authorizing user: %v", err)
+	}
+	for _, role := range roles {
+		for _, perm := range role.Permissions {
+			if PermissionAllows(perm, action, database, target) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// function CreateAPIToken to create an api token for a panel user, a zero ttl means the token doesn't expire, the token is only returned here and only its hash is stored, the creation (without the hash) is audited under the actor of ctx, returns the token and an error
+func CreateAPIToken(ctx context.Context, client *mongo.Client, username string, name string, ttl time.Duration) (string, error) {
+	if _, err := GetUser(client, username); err != nil {
+		return "", fmt.Errorf("[-] Error creating api token: %v", err)
+	}
+	idbytes, err := myutils.RandomBytes(8)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating api token: %v", err)
+	}
+	secretbytes, err := myutils.RandomBytes(32)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating api token: %v", err)
+	}
+	tokenid := hex.EncodeToString(idbytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretbytes)
+
+	now := time.Now().UTC()
+	token := mytypes.APIToken{
+		TokenID:   tokenid,
+		Hash:      myutils.HashString(secret),
+		Username:  username,
+		Name:      name,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		token.ExpiresAt = &expires
+	}
+	result, err := client.Database(PanelDatabase).Collection(PanelTokens).InsertOne(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating api token: %v", err)
+	}
+	after := auditDocument(token)
+	delete(after, "hash")
+	after["_id"] = result.InsertedID
+	auditOperation(ctx, client, mytypes.AuditInsert, PanelDatabase, PanelTokens, nil, nil, after, 1, nil)
+	fmt.Println("[+] Created api token successfully")
+
+	return apiTokenPrefix + tokenid + "_" + secret, nil
+}
+
+// function AuthenticateAPIToken to check an api token, expired, revoked and malformed tokens and tokens of disabled users are rejected, the last-used time is updated on success, returns the token owner (nil if rejected) and an error
+func AuthenticateAPIToken(client *mongo.Client, rawtoken string) (*mytypes.PanelUser, error) {
+	parts := strings.SplitN(strings.TrimPrefix(rawtoken, apiTokenPrefix), "_", 2)
+	if !strings.HasPrefix(rawtoken, apiTokenPrefix) || len(parts) != 2 {
+		return nil, nil
+	}
+	coll := client.Database(PanelDatabase).Collection(PanelTokens)
+	token := &mytypes.APIToken{}
+	err := coll.FindOne(context.TODO(), bson.M{"token_id": parts[0]}).Decode(token)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error authenticating api token: %v", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(myutils.HashString(parts[1]))) != 1 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	if token.Revoked || (token.ExpiresAt != nil && now.After(*token.ExpiresAt)) {
+		return nil, nil
+	}
+
+	user, err := GetUser(client, token.Username)
+	if err != nil || user.Disabled {
+		return nil, nil
+	}
+	_, err = coll.UpdateOne(context.TODO(), bson.M{"_id": token.ID}, bson.M{"$set": bson.M{"last_used_at": now}})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating api token: %v", err)
+	}
+
+	return user, nil
+}
+
+// function AuthorizeAPIToken to authenticate an api token and check whether its owner may perform the action on the database and target, returns the decision and an error
+func AuthorizeAPIToken(client *mongo.Client, rawtoken string, action string, database string, target string) (bool, error) {
+	user, err := AuthenticateAPIToken(client, rawtoken)
+	if err != nil || user == nil {
+		return false, err
+	}
+	return Authorize(client, user.Username, action, database, target)
+}
+
+// function GetAPITokens to get the api tokens of a panel user (without their hashes), returns a slice of tokens and an error
+func GetAPITokens(client *mongo.Client, username string) ([]mytypes.APIToken, error) {
+	opts := options.Find().SetProjection(bson.M{"hash": 0})
+	cursor, err := client.Database(PanelDatabase).Collection(PanelTokens).Find(context.TODO(), bson.M{"username": username}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting api tokens: %v", err)
+	}
+	tokens := []mytypes.APIToken{}
+	err = cursor.All(context.TODO(), &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding api tokens: %v", err)
+	}
+
+	return tokens, nil
+}
+
+// function RevokeAPIToken to revoke the api token with the given token id, the change is audited under the actor of ctx, returns an error
+func RevokeAPIToken(ctx context.Context, client *mongo.Client, tokenid string) error {
+	before, err := updateOneAudited(ctx, client, PanelDatabase, PanelTokens, bson.M{"token_id": tokenid}, bson.M{"revoked": true}, nil)
+	if err != nil {
+		return fmt.Errorf("[-] Error revoking api token: %v", err)
+	}
+	if before == nil {
+		return fmt.Errorf("[-] Error revoking api token: token doesn't exist")
+	}
+	fmt.Println("[+] Revoked api token successfully")
+
+	return nil
+}
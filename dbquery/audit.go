@@ -0,0 +1,272 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Audit log                ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the log database, it is target based so the collection is the target name, records that don't belong to a target go to the global collection
+var (
+	LogDatabase         = "log"
+	GlobalLogCollection = "_global"
+)
+
+// audit settings, when AuditEnabled is set every mutating dbquery operation (insert, update, delete, drop, purge) writes a record under the actor of its context or AuditActor, records expire after AuditRetention (0 keeps them forever), heartbeats, lease renewals and login bookkeeping (last login, token use, rehashes) aren't recorded
+var (
+	AuditEnabled   = false
+	AuditActor     = "healerdb"
+	AuditRetention = 90 * 24 * time.Hour
+)
+
+// key of the actor in a context
+type auditActorKey struct{}
+
+// function WithAuditActor to get a context that records the operations made with it under the given actor instead of AuditActor, a multi-user panel passes one per request
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// function auditActor to get the actor of a context, AuditActor if it has none
+func auditActor(ctx context.Context) string {
+	if ctx != nil {
+		if actor, ok := ctx.Value(auditActorKey{}).(string); ok && actor != "" {
+			return actor
+		}
+	}
+	return AuditActor
+}
+
+// function auditTarget to get the target a collection belongs to, empty if the database isn't target based
+func auditTarget(database string, collection string) string {
+	if collection == "exists" || !myutils.ContainsString(TargetDatabases(), database) {
+		return ""
+	}
+	return collection
+}
+
+// function ensureAuditIndexes to create the declared ttl and query indexes of a log collection once per process
+func ensureAuditIndexes(client *mongo.Client, collection string) error {
+	return ensureIndexes(client, LogDatabase, collection)
+}
+
+// function WriteAuditRecord to write a record into the log database, in the collection of its target or the global one, the actor, timestamp and expiry are filled in when missing, returns an error
+func WriteAuditRecord(client *mongo.Client, record mytypes.AuditRecord) error {
+	if record.Actor == "" {
+		record.Actor = AuditActor
+	}
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+	if record.ExpiresAt == nil && AuditRetention > 0 {
+		expires := record.Timestamp.Add(AuditRetention)
+		record.ExpiresAt = &expires
+	}
+	// records about the log database itself go to the global collection so dropping a target's log doesn't recreate it
+	collection := record.Target
+	if collection == "" || record.Database == LogDatabase {
+		collection = GlobalLogCollection
+	}
+	err := ensureAuditIndexes(client, collection)
+	if err != nil {
+		return fmt.Errorf("[-] Error writing audit record: %v", err)
+	}
+	_, err = client.Database(LogDatabase).Collection(collection).InsertOne(context.TODO(), record)
+	if err != nil {
+		return fmt.Errorf("[-] Error writing audit record: %v", err)
+	}
+
+	return nil
+}
+
+// function documentID to get the _id of a document as a string
+func documentID(document bson.M) string {
+	switch id := document["_id"].(type) {
+	case nil:
+		return ""
+	case primitive.ObjectID:
+		return id.Hex()
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+// function auditDocument to convert a value to the bson.M it is stored as, so it compares field by field with a document read back from the database
+func auditDocument(value interface{}) bson.M {
+	document := bson.M{}
+	raw, err := bson.Marshal(value)
+	if err == nil {
+		bson.Unmarshal(raw, &document)
+	}
+	return document
+}
+
+// function updateOneAudited to apply an update to the document matching filter and audit the fields of set under the actor of ctx, set is also the update when update is nil (pass a separate update to keep a field such as a hash out of the record), returns the fields of set before the update (nil if nothing matched) and an error
+func updateOneAudited(ctx context.Context, client *mongo.Client, database string, collection string, filter bson.M, set bson.M, update interface{}) (bson.M, error) {
+	if update == nil {
+		update = bson.M{"$set": set}
+	}
+	projection := bson.M{}
+	for k := range set {
+		projection[k] = 1
+	}
+	before := bson.M{}
+	opts := options.FindOneAndUpdate().SetProjection(projection)
+	err := client.Database(database).Collection(collection).FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		auditOperation(ctx, client, mytypes.AuditUpdate, database, collection, filter, nil, nil, 0, err)
+		return nil, err
+	}
+	after := auditDocument(set)
+	after["_id"] = before["_id"]
+	auditOperation(ctx, client, mytypes.AuditUpdate, database, collection, filter, before, after, 1, nil)
+
+	return before, nil
+}
+
+// function auditOperation to record a mutating operation under the actor of ctx when auditing is enabled, a failed write of the record is printed but doesn't fail the operation
+func auditOperation(ctx context.Context, client *mongo.Client, operation string, database string, collection string, filter interface{}, before bson.M, after bson.M, count int64, operr error) {
+	if !AuditEnabled {
+		return
+	}
+	record := mytypes.AuditRecord{
+		Operation:  operation,
+		Actor:      auditActor(ctx),
+		Database:   database,
+		Collection: collection,
+		Target:     auditTarget(database, collection),
+		Count:      count,
+	}
+	if filter != nil {
+		raw, err := bson.MarshalExtJSON(filter, false, false)
+		if err == nil {
+			record.Filter = string(raw)
+		}
+	}
+	if before != nil || after != nil {
+		record.DocumentID = documentID(after)
+		if record.DocumentID == "" {
+			record.DocumentID = documentID(before)
+		}
+		record.Changes = DiffDocuments(before, after)
+	}
+	if operr != nil {
+		record.Error = operr.Error()
+	}
+	err := WriteAuditRecord(client, record)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// function DiffDocuments to list the fields that differ between two versions of a document, nested documents are compared field by field, a nil document counts as empty, returns the changes sorted by field
+func DiffDocuments(before bson.M, after bson.M) []mytypes.AuditChange {
+	return diffAt("", before, after)
+}
+
+// function diffAt to compare two documents under the given field prefix
+func diffAt(prefix string, before bson.M, after bson.M) []mytypes.AuditChange {
+	keys := []string{}
+	for k := range before {
+		keys = append(keys, k)
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []mytypes.AuditChange{}
+	for _, k := range keys {
+		field := prefix + k
+		old, inold := before[k]
+		new, innew := after[k]
+		oldm, oldok := toM(old)
+		newm, newok := toM(new)
+		switch {
+		case inold && innew && oldok && newok:
+			changes = append(changes, diffAt(field+".", oldm, newm)...)
+		case inold && innew && reflect.DeepEqual(old, new):
+			// unchanged
+		default:
+			changes = append(changes, mytypes.AuditChange{Field: field, Before: old, After: new})
+		}
+	}
+
+	return changes
+}
+
+// function GetAuditLog to query the audit records of a target (or the global records if the query has no target), newest first, returns a slice of records and an error
+func GetAuditLog(client *mongo.Client, query mytypes.AuditQuery) ([]mytypes.AuditRecord, error) {
+	collection := query.Target
+	if collection == "" {
+		collection = GlobalLogCollection
+	}
+	filter := bson.M{}
+	if query.Actor != "" {
+		filter["actor"] = query.Actor
+	}
+	if query.Operation != "" {
+		filter["operation"] = query.Operation
+	}
+	if query.Database != "" {
+		filter["database"] = query.Database
+	}
+	if query.Collection != "" {
+		filter["collection"] = query.Collection
+	}
+	if query.DocumentID != "" {
+		filter["document_id"] = query.DocumentID
+	}
+	timestamp := bson.M{}
+	if !query.Since.IsZero() {
+		timestamp["$gte"] = query.Since
+	}
+	if !query.Until.IsZero() {
+		timestamp["$lt"] = query.Until
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if query.Limit > 0 {
+		opts.SetLimit(query.Limit)
+	}
+	if query.Skip > 0 {
+		opts.SetSkip(query.Skip)
+	}
+	cursor, err := client.Database(LogDatabase).Collection(collection).Find(context.TODO(), skipMarker(filter), opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting audit log: %v", err)
+	}
+	records := []mytypes.AuditRecord{}
+	err = cursor.All(context.TODO(), &records)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding audit log: %v", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,526 @@
+package dbquery
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Backup                   ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// directory the backups are written to when none is given
+var BackupDirectory = "backups"
+
+// version of the backup archives and the number of documents written per bulk operation on restore
+const (
+	backupVersion     = 1
+	restoreBatchSize  = 500
+	maxExtJSONLineLen = 64 << 20
+)
+
+// function addTarFile to copy a file into the archive under the given name
+func addTarFile(tw *tar.Writer, name string, file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: info.Size(), ModTime: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// function backupCollection to write the documents and the index definitions of a collection into the archive, extjson stores one canonical extended json document per line and bson stores the raw documents back to back (like mongodump), the documents are spooled to a temporary file since tar needs the size up front
+func backupCollection(client *mongo.Client, tw *tar.Writer, format string, database string, collection string) (mytypes.BackupCollection, error) {
+	info := mytypes.BackupCollection{Database: database, Collection: collection}
+	coll := client.Database(database).Collection(collection)
+
+	spool, err := os.CreateTemp("", "healerdb-backup-*")
+	if err != nil {
+		return info, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	cursor, err := coll.Find(context.TODO(), bson.M{})
+	if err != nil {
+		return info, err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		data := []byte(cursor.Current)
+		if format == mytypes.BackupFormatExtJSON {
+			data, err = bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				return info, err
+			}
+			data = append(data, '\n')
+		}
+		_, err = spool.Write(data)
+		if err != nil {
+			return info, err
+		}
+		info.Documents++
+	}
+	if err := cursor.Err(); err != nil {
+		return info, err
+	}
+	ext := ".json"
+	if format == mytypes.BackupFormatBSON {
+		ext = ".bson"
+	}
+	err = addTarFile(tw, database+"/"+collection+ext, spool)
+	if err != nil {
+		return info, err
+	}
+
+	// the specs are kept as bson.D so the key order of compound indexes survives
+	cursor, err = coll.Indexes().List(context.TODO())
+	if err != nil {
+		return info, err
+	}
+	specs := []bson.D{}
+	err = cursor.All(context.TODO(), &specs)
+	if err != nil {
+		return info, err
+	}
+	indexes := bson.A{}
+	for _, spec := range specs {
+		keep := bson.D{}
+		builtin := false
+		for _, e := range spec {
+			switch e.Key {
+			case "v", "ns":
+				// set by the server
+			case "name":
+				builtin = e.Value == "_id_"
+				keep = append(keep, e)
+			default:
+				keep = append(keep, e)
+			}
+		}
+		if !builtin {
+			indexes = append(indexes, keep)
+		}
+	}
+	raw, err := bson.MarshalExtJSON(bson.D{{Key: "indexes", Value: indexes}}, true, false)
+	if err != nil {
+		return info, err
+	}
+	err = tw.WriteHeader(&tar.Header{Name: database + "/" + collection + ".indexes.json", Mode: 0600, Size: int64(len(raw)), ModTime: time.Now()})
+	if err != nil {
+		return info, err
+	}
+	_, err = tw.Write(raw)
+	info.Indexes = len(indexes)
+
+	return info, err
+}
+
+// function Backup to write the given databases into a gzip compressed tar archive in dir (BackupDirectory if empty), the map gives the collections to keep of each database, nil for all of them, format is extjson (default) or bson, returns the path of the archive, its manifest and an error
+func Backup(client *mongo.Client, dir string, format string, databases map[string][]string) (string, *mytypes.BackupManifest, error) {
+	if format == "" {
+		format = mytypes.BackupFormatExtJSON
+	}
+	if format != mytypes.BackupFormatExtJSON && format != mytypes.BackupFormatBSON {
+		return "", nil, fmt.Errorf("[-] Error creating backup: unknown format %q", format)
+	}
+	if dir == "" {
+		dir = BackupDirectory
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", nil, fmt.Errorf("[-] Error creating backup: %v", err)
+	}
+	now := time.Now().UTC()
+	path := filepath.Join(dir, "healerdb-"+now.Format("20060102-150405.000000000")+".tar.gz")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", nil, fmt.Errorf("[-] Error creating backup: %v", err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	names := []string{}
+	for database := range databases {
+		names = append(names, database)
+	}
+	sort.Strings(names)
+	manifest := &mytypes.BackupManifest{Version: backupVersion, Format: format, CreatedAt: now, Collections: []mytypes.BackupCollection{}}
+	for _, database := range names {
+		collections := databases[database]
+		if collections == nil {
+			collections, err = GetCollections(client, database)
+			if err != nil {
+				os.Remove(path)
+				return "", nil, fmt.Errorf("[-] Error creating backup: %v", err)
+			}
+		}
+		sort.Strings(collections)
+		for _, collection := range collections {
+			if strings.HasPrefix(collection, "system.") {
+				continue
+			}
+			info, err := backupCollection(client, tw, format, database, collection)
+			if err != nil {
+				os.Remove(path)
+				return "", nil, fmt.Errorf("[-] Error backing up %s.%s: %v", database, collection, err)
+			}
+			manifest.Collections = append(manifest.Collections, info)
+		}
+	}
+
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0600, Size: int64(len(raw)), ModTime: now})
+	}
+	if err == nil {
+		_, err = tw.Write(raw)
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", nil, fmt.Errorf("[-] Error creating backup: %v", err)
+	}
+	fmt.Println("[+] Created backup successfully:", path)
+
+	return path, manifest, nil
+}
+
+// function BackupDatabases to back up every database declared in the config file that exists on the server, returns the path of the archive, its manifest and an error
+func BackupDatabases(client *mongo.Client, dir string, format string) (string, *mytypes.BackupManifest, error) {
+	existing, err := GetDatabases(client)
+	if err != nil {
+		return "", nil, fmt.Errorf("[-] Error creating backup: %v", err)
+	}
+	databases := map[string][]string{}
+	for _, database := range existing {
+		if IsManagedDatabase(database) {
+			databases[database] = nil
+		}
+	}
+
+	return Backup(client, dir, format, databases)
+}
+
+// function BackupTarget to back up the collection of a target in every target based database declared in the config file, returns the path of the archive, its manifest and an error
+func BackupTarget(client *mongo.Client, dir string, format string, target string) (string, *mytypes.BackupManifest, error) {
+	databases := map[string][]string{}
+	for _, database := range TargetDatabases() {
+		if !IsManagedDatabase(database) {
+			continue
+		}
+		exists, err := CheckCollection(client, database, target)
+		if err != nil {
+			return "", nil, fmt.Errorf("[-] Error creating backup: %v", err)
+		}
+		if exists {
+			databases[database] = []string{target}
+		}
+	}
+	if len(databases) == 0 {
+		return "", nil, fmt.Errorf("[-] Error creating backup: target %s doesn't exist", target)
+	}
+
+	return Backup(client, dir, format, databases)
+}
+
+// function restoreModel to build the write of a restored document for the conflict policy
+func restoreModel(doc bson.D, policy string) mongo.WriteModel {
+	var id interface{}
+	set := bson.D{}
+	for _, e := range doc {
+		if e.Key == "_id" {
+			id = e.Value
+		} else {
+			set = append(set, e)
+		}
+	}
+	if id == nil || policy == mytypes.RestoreSkip || (policy == mytypes.RestoreMerge && len(set) == 0) {
+		return mongo.NewInsertOneModel().SetDocument(doc)
+	}
+	filter := bson.D{{Key: "_id", Value: id}}
+	if policy == mytypes.RestoreOverwrite {
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true)
+	}
+	// merge keeps the fields that only exist on the server, the backup wins for the others
+	return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{Key: "$set", Value: set}}).SetUpsert(true)
+}
+
+// function flushRestore to write a batch of restored documents, duplicate keys count as skipped documents and the other failures are added to the result errors
+func flushRestore(coll *mongo.Collection, models []mongo.WriteModel, info *mytypes.RestoreCollection, result *mytypes.RestoreResult) {
+	if len(models) == 0 {
+		return
+	}
+	res, err := coll.BulkWrite(context.TODO(), models, options.BulkWrite().SetOrdered(false))
+	if res != nil {
+		info.Inserted += res.InsertedCount + res.UpsertedCount
+		info.Updated += res.MatchedCount
+	}
+	if bulkerr, ok := err.(mongo.BulkWriteException); ok {
+		for _, werr := range bulkerr.WriteErrors {
+			if werr.Code == 11000 {
+				info.Skipped++
+				continue
+			}
+			result.Errors = append(result.Errors, fmt.Sprintf("%s.%s: %v", info.Database, info.Collection, werr.Message))
+		}
+		if bulkerr.WriteConcernError != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s.%s: %v", info.Database, info.Collection, bulkerr.WriteConcernError.Message))
+		}
+	} else if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s.%s: %v", info.Database, info.Collection, err))
+	}
+}
+
+// function readBSONDocument to read the next raw document of a bson dump, returns io.EOF at the end
+func readBSONDocument(r *bufio.Reader) (bson.Raw, error) {
+	header, err := r.Peek(4)
+	if err == io.EOF && len(header) == 0 {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truncated document: %v", err)
+	}
+	size := int(binary.LittleEndian.Uint32(header))
+	if size < 5 || size > 16<<20+16<<10 {
+		return nil, fmt.Errorf("invalid document size %d", size)
+	}
+	doc := make([]byte, size)
+	_, err = io.ReadFull(r, doc)
+	if err != nil {
+		return nil, fmt.Errorf("truncated document: %v", err)
+	}
+	raw := bson.Raw(doc)
+	return raw, raw.Validate()
+}
+
+// function restoreDocuments to restore the documents of an archive entry into a collection
+func restoreDocuments(client *mongo.Client, r io.Reader, bsonformat bool, policy string, info *mytypes.RestoreCollection, result *mytypes.RestoreResult) error {
+	coll := client.Database(info.Database).Collection(info.Collection)
+	models := []mongo.WriteModel{}
+	add := func(doc bson.D) {
+		models = append(models, restoreModel(doc, policy))
+		if len(models) >= restoreBatchSize {
+			flushRestore(coll, models, info, result)
+			models = []mongo.WriteModel{}
+		}
+	}
+
+	if bsonformat {
+		reader := bufio.NewReader(r)
+		for {
+			raw, err := readBSONDocument(reader)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			doc := bson.D{}
+			err = bson.Unmarshal(raw, &doc)
+			if err != nil {
+				return err
+			}
+			add(doc)
+		}
+	} else {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxExtJSONLineLen)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(strings.TrimSpace(string(line))) == 0 {
+				continue
+			}
+			doc := bson.D{}
+			err := bson.UnmarshalExtJSON(line, true, &doc)
+			if err != nil {
+				return err
+			}
+			add(doc)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	flushRestore(coll, models, info, result)
+
+	return nil
+}
+
+// function restoreIndexes to recreate the index definitions of an archive entry, createIndexes keeps every option (ttl, partial, collation, ...) of the saved specs
+func restoreIndexes(client *mongo.Client, r io.Reader, info *mytypes.RestoreCollection, result *mytypes.RestoreResult) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	saved := struct {
+		Indexes []bson.D `bson:"indexes"`
+	}{}
+	err = bson.UnmarshalExtJSON(data, true, &saved)
+	if err != nil {
+		return err
+	}
+	if len(saved.Indexes) == 0 {
+		return nil
+	}
+	command := bson.D{{Key: "createIndexes", Value: info.Collection}, {Key: "indexes", Value: saved.Indexes}}
+	err = client.Database(info.Database).RunCommand(context.TODO(), command).Err()
+	if err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s.%s indexes: %v", info.Database, info.Collection, err))
+		return nil
+	}
+	info.Indexes += len(saved.Indexes)
+
+	return nil
+}
+
+// function walkArchive to call fn with the name and content of every entry of a backup archive, the file is closed before returning, returns the error of fn, of the archive or of closing it
+func walkArchive(path string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			err = fn(header.Name, tr)
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// function Restore to load a backup archive into the server, a fresh server gets everything and on an existing one the documents whose _id already exists are skipped, overwritten or merged (fields of the backup win, the others are kept) depending on the policy, entries of databases that aren't declared in the config file (admin, config, local, unrelated ones) are skipped, the archive is read twice so the indexes exist (and unique ones apply) before the documents are written, returns what was restored and an error
+func Restore(client *mongo.Client, path string, policy string) (*mytypes.RestoreResult, error) {
+	if policy == "" {
+		policy = mytypes.RestoreSkip
+	}
+	if policy != mytypes.RestoreSkip && policy != mytypes.RestoreOverwrite && policy != mytypes.RestoreMerge {
+		return nil, fmt.Errorf("[-] Error restoring backup: unknown conflict policy %q", policy)
+	}
+
+	result := &mytypes.RestoreResult{Policy: policy, Collections: []mytypes.RestoreCollection{}, Errors: []string{}}
+	infos := map[string]*mytypes.RestoreCollection{}
+	order := []string{}
+	skipped := map[string]bool{}
+	for _, documents := range []bool{false, true} {
+		err := walkArchive(path, func(name string, r io.Reader) error {
+			if name == "manifest.json" {
+				if documents {
+					return nil
+				}
+				manifest := mytypes.BackupManifest{}
+				err := json.NewDecoder(r).Decode(&manifest)
+				if err != nil || manifest.Version > backupVersion {
+					return fmt.Errorf("unsupported manifest (version %d): %v", manifest.Version, err)
+				}
+				return nil
+			}
+
+			parts := strings.Split(name, "/")
+			if len(parts) != 2 || parts[0] == "" || parts[0] == ".." || parts[1] == "" {
+				return fmt.Errorf("unexpected entry %s", name)
+			}
+			database, entry := parts[0], parts[1]
+			collection := entry
+			kind := ""
+			for _, ext := range []string{".indexes.json", ".json", ".bson"} {
+				if strings.HasSuffix(entry, ext) {
+					collection, kind = strings.TrimSuffix(entry, ext), ext
+					break
+				}
+			}
+			if kind == "" {
+				return fmt.Errorf("unexpected entry %s", name)
+			}
+			if !IsManagedDatabase(database) {
+				if !skipped[database] {
+					skipped[database] = true
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: not declared in the config, skipped", database))
+				}
+				return nil
+			}
+			// the indexes go in the first pass and the documents in the second
+			if (kind == ".indexes.json") == documents {
+				return nil
+			}
+			key := database + "." + collection
+			info, ok := infos[key]
+			if !ok {
+				info = &mytypes.RestoreCollection{Database: database, Collection: collection}
+				infos[key] = info
+				order = append(order, key)
+			}
+			var err error
+			if kind == ".indexes.json" {
+				err = restoreIndexes(client, r, info, result)
+			} else {
+				err = restoreDocuments(client, r, kind == ".bson", policy, info, result)
+				auditOperation(context.TODO(), client, mytypes.AuditRestore, database, collection, nil, nil, nil, info.Inserted+info.Updated, err)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return nil
+		})
+		if err != nil {
+			for _, key := range order {
+				result.Collections = append(result.Collections, *infos[key])
+			}
+			return result, fmt.Errorf("[-] Error restoring backup: %v", err)
+		}
+	}
+	for _, key := range order {
+		result.Collections = append(result.Collections, *infos[key])
+	}
+	fmt.Printf("[+] Restored backup successfully: %d collections, %d errors\n", len(result.Collections), len(result.Errors))
+
+	return result, nil
+}
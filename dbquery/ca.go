@@ -0,0 +1,272 @@
+package dbquery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Certificates             ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the ca database, it is target based so the collection is the target name
+var CADatabase = "ca"
+
+// function ParseCertificates to parse one or more certificates given as PEM blocks or as a single DER certificate, returns a slice of certificates and an error
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error parsing certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	// not pem, try der
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error parsing certificate: %v", err)
+	}
+
+	return []*x509.Certificate{cert}, nil
+}
+
+// function certKeyType to describe the public key of a certificate, returns the key type (RSA, ECDSA-P256, Ed25519, ...) and its size in bits
+func certKeyType(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + key.Curve.Params().Name, key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// function CertificateRecord to convert a parsed certificate into the document stored in the ca database
+func CertificateRecord(target string, cert *x509.Certificate) mytypes.Certificate {
+	sum256 := sha256.Sum256(cert.Raw)
+	sum1 := sha1.Sum(cert.Raw)
+	keytype, keybits := certKeyType(cert)
+	ips := []string{}
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	dnsnames := []string{}
+	for _, name := range cert.DNSNames {
+		dnsnames = append(dnsnames, strings.ToLower(strings.TrimSuffix(name, ".")))
+	}
+
+	return mytypes.Certificate{
+		Target:             target,
+		FingerprintSHA256:  hex.EncodeToString(sum256[:]),
+		FingerprintSHA1:    hex.EncodeToString(sum1[:]),
+		Subject:            cert.Subject.String(),
+		CommonName:         cert.Subject.CommonName,
+		Issuer:             cert.Issuer.String(),
+		IssuerCommonName:   cert.Issuer.CommonName,
+		SerialNumber:       cert.SerialNumber.Text(16),
+		DNSNames:           dnsnames,
+		IPAddresses:        ips,
+		EmailAddresses:     cert.EmailAddresses,
+		NotBefore:          cert.NotBefore.UTC(),
+		NotAfter:           cert.NotAfter.UTC(),
+		KeyType:            keytype,
+		KeyBits:            keybits,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		IsCA:               cert.IsCA,
+		SelfSigned:         cert.CheckSignatureFrom(cert) == nil,
+		Hosts:              []string{},
+		PEM:                string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+	}
+}
+
+// function EnsureCertIndexes to create the declared indexes of a target's collection in the ca database, returns an error
+func EnsureCertIndexes(client *mongo.Client, target string) error {
+	return ensureIndexes(client, CADatabase, target)
+}
+
+// function AddCertificates to store the certificates (PEM or DER) observed on a host of a target, a certificate seen before only gets the host linked and its last-seen time updated, returns the sha256 fingerprints of the certificates and an error
+func AddCertificates(client *mongo.Client, target string, data []byte, host string) ([]string, error) {
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error adding certificates: %v", err)
+	}
+	err = EnsureCertIndexes(client, target)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error adding certificates: %v", err)
+	}
+
+	coll := client.Database(CADatabase).Collection(target)
+	now := time.Now().UTC()
+	fingerprints := []string{}
+	for _, cert := range certs {
+		record := CertificateRecord(target, cert)
+		raw, err := bson.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error converting certificate to bson: %v", err)
+		}
+		oninsert := bson.M{}
+		err = bson.Unmarshal(raw, &oninsert)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error converting certificate to bson: %v", err)
+		}
+		delete(oninsert, "_id")
+		delete(oninsert, "hosts")
+		delete(oninsert, "last_seen")
+		oninsert["first_seen"] = now
+
+		update := bson.M{
+			"$setOnInsert": oninsert,
+			"$set":         bson.M{"last_seen": now},
+		}
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			update["$addToSet"] = bson.M{"hosts": host}
+		}
+		filter := bson.M{"fingerprint_sha256": record.FingerprintSHA256}
+		result, err := coll.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, CADatabase, target, filter, nil, nil, 0, err)
+			return nil, fmt.Errorf("[-] Error adding certificate: %v", err)
+		}
+		if result.UpsertedID != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditInsert, CADatabase, target, nil, nil, bson.M{"_id": result.UpsertedID, "fingerprint_sha256": record.FingerprintSHA256, "subject": oninsert["subject"], "host": host}, 1, nil)
+		} else {
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, CADatabase, target, filter, nil, bson.M{"host": host, "last_seen": now}, result.ModifiedCount, nil)
+		}
+		fingerprints = append(fingerprints, record.FingerprintSHA256)
+	}
+	fmt.Println("[+] Added certificates successfully")
+
+	return fingerprints, nil
+}
+
+// function findCertificates to find the certificates of a target matching the given filter, sorted by expiry
+func findCertificates(client *mongo.Client, target string, filter bson.M) ([]mytypes.Certificate, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "not_after", Value: 1}})
+	cursor, err := client.Database(CADatabase).Collection(target).Find(context.TODO(), skipMarker(filter), opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting certificates: %v", err)
+	}
+	certs := []mytypes.Certificate{}
+	err = cursor.All(context.TODO(), &certs)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding certificates: %v", err)
+	}
+
+	return certs, nil
+}
+
+// function GetCertificate to get the certificate of a target with the given sha256 fingerprint, returns a pointer to the certificate and an error
+func GetCertificate(client *mongo.Client, target string, fingerprint string) (*mytypes.Certificate, error) {
+	cert := &mytypes.Certificate{}
+	err := client.Database(CADatabase).Collection(target).FindOne(context.TODO(), bson.M{"fingerprint_sha256": strings.ToLower(fingerprint)}).Decode(cert)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting certificate: %v", err)
+	}
+
+	return cert, nil
+}
+
+// function GetCertificatesForHost to get the certificates seen on the given host (subdomain) of a target, returns a slice of certificates and an error
+func GetCertificatesForHost(client *mongo.Client, target string, host string) ([]mytypes.Certificate, error) {
+	return findCertificates(client, target, bson.M{"hosts": strings.ToLower(host)})
+}
+
+// function GetExpiringCertificates to get the certificates of a target that are still valid but expire within the given duration, soonest first, returns a slice of certificates and an error
+func GetExpiringCertificates(client *mongo.Client, target string, within time.Duration) ([]mytypes.Certificate, error) {
+	now := time.Now().UTC()
+	return findCertificates(client, target, bson.M{"not_after": bson.M{"$gte": now, "$lte": now.Add(within)}})
+}
+
+// function GetAllExpiringCertificates to get the expiring-soon certificates of every target in the ca database, returns a map of target to certificates and an error
+func GetAllExpiringCertificates(client *mongo.Client, within time.Duration) (map[string][]mytypes.Certificate, error) {
+	targets, err := GetCollections(client, CADatabase)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting expiring certificates: %v", err)
+	}
+	expiring := map[string][]mytypes.Certificate{}
+	for _, target := range targets {
+		if target == "exists" {
+			continue
+		}
+		certs, err := GetExpiringCertificates(client, target, within)
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) > 0 {
+			expiring[target] = certs
+		}
+	}
+
+	return expiring, nil
+}
+
+// function ImportSANSubdomains to add the DNS SANs of a certificate as candidate subdomains into the enum tree of the target, a SAN is only added under a domain of the target it belongs to and wildcards are reduced to their base name, every SAN is recorded through SeeAsset with the source "ca" and the certificate's fingerprint as its run, returns the newly added subdomains and an error
+func ImportSANSubdomains(client *mongo.Client, target string, fingerprint string) ([]string, error) {
+	cert, err := GetCertificate(client, target, fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error importing SANs: %v", err)
+	}
+	domains, err := GetTargetDomains(client, target)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error importing SANs: %v", err)
+	}
+
+	source := mytypes.AssetSource{Source: "ca", Run: cert.FingerprintSHA256}
+	seen := []string{}
+	added := []string{}
+	for _, san := range cert.DNSNames {
+		name := normalizeHost(strings.TrimPrefix(strings.TrimSpace(san), "*."))
+		best := MatchDomain(domains, name)
+		if best == "" || best == name || myutils.ContainsString(seen, name) {
+			continue
+		}
+		seen = append(seen, name)
+		first, err := SeeAsset(client, target, mytypes.TargetAsset{Type: mytypes.AssetSubdomain, Domain: best, Subdomain: name}, source)
+		if err != nil {
+			return added, fmt.Errorf("[-] Error importing SAN %s: %v", name, err)
+		}
+		if first {
+			added = append(added, name)
+		}
+	}
+	fmt.Printf("[+] Imported SANs successfully: %d new subdomains\n", len(added))
+
+	return added, nil
+}
@@ -0,0 +1,323 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Creds                    ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the creds database (not target based) and its collection
+var (
+	CredsDatabase = "creds"
+	CredsSecrets  = "secrets"
+)
+
+// function secretAAD to build the additional authenticated data of a secret version, binding the ciphertext to its name and version so it can't be swapped with another one
+func secretAAD(purpose string, name string, version int) []byte {
+	return []byte("healerdb-" + purpose + "\x00" + name + "\x00" + strconv.Itoa(version))
+}
+
+// function sealSecret to encrypt a value with a fresh data key and wrap the data key with the master key, returns the encrypted version and an error
+func sealSecret(masterkey []byte, name string, version int, value []byte) (*mytypes.SecretVersion, error) {
+	datakey, err := myutils.RandomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, nonce, err := myutils.EncryptAESGCM(datakey, value, secretAAD("value", name, version))
+	if err != nil {
+		return nil, err
+	}
+	wrapped, keynonce, err := myutils.EncryptAESGCM(masterkey, datakey, secretAAD("datakey", name, version))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mytypes.SecretVersion{
+		Version:    version,
+		Ciphertext: ciphertext,
+		Nonce:      nonce,
+		WrappedKey: wrapped,
+		KeyNonce:   keynonce,
+		KeyID:      myutils.KeyID(masterkey),
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// function unwrapDataKey to decrypt the data key of a secret version with the master key
+func unwrapDataKey(masterkey []byte, name string, version *mytypes.SecretVersion) ([]byte, error) {
+	if version.KeyID != myutils.KeyID(masterkey) {
+		return nil, fmt.Errorf("[-] Error decrypting secret: version %d is wrapped with master key %s", version.Version, version.KeyID)
+	}
+	return myutils.DecryptAESGCM(masterkey, version.WrappedKey, version.KeyNonce, secretAAD("datakey", name, version.Version))
+}
+
+// function openSecret to decrypt a secret version with the master key, returns the plaintext value and an error
+func openSecret(masterkey []byte, name string, version *mytypes.SecretVersion) ([]byte, error) {
+	datakey, err := unwrapDataKey(masterkey, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return myutils.DecryptAESGCM(datakey, version.Ciphertext, version.Nonce, secretAAD("value", name, version.Version))
+}
+
+// function PutSecret to store a new version of the named secret (creating it if needed), the value is encrypted with envelope encryption under the master key, returns the new version number and an error
+func PutSecret(client *mongo.Client, masterkey []byte, name string, kind string, target string, description string, value []byte) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("[-] Error storing secret: name is required")
+	}
+	coll := client.Database(CredsDatabase).Collection(CredsSecrets)
+	err := ensureIndexes(client, CredsDatabase, CredsSecrets)
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error storing secret: %v", err)
+	}
+
+	for {
+		current := &mytypes.Secret{}
+		err := coll.FindOne(context.TODO(), bson.M{"name": name}).Decode(current)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return 0, fmt.Errorf("[-] Error storing secret: %v", err)
+		}
+		exists := err == nil
+		version := current.CurrentVersion + 1
+		sealed, err := sealSecret(masterkey, name, version, value)
+		if err != nil {
+			return 0, fmt.Errorf("[-] Error storing secret: %v", err)
+		}
+
+		if !exists {
+			secret := mytypes.Secret{
+				Name:           name,
+				Kind:           kind,
+				Target:         target,
+				Description:    description,
+				CurrentVersion: version,
+				Versions:       []mytypes.SecretVersion{*sealed},
+				CreatedAt:      sealed.CreatedAt,
+				UpdatedAt:      sealed.CreatedAt,
+			}
+			_, err = coll.InsertOne(context.TODO(), secret)
+			if mongo.IsDuplicateKeyError(err) {
+				// created concurrently, store ours as the next version
+				continue
+			}
+			if err != nil {
+				auditOperation(context.TODO(), client, mytypes.AuditInsert, CredsDatabase, CredsSecrets, nil, nil, nil, 0, err)
+				return 0, fmt.Errorf("[-] Error storing secret: %v", err)
+			}
+			// only the metadata goes into the record, never the sealed value
+			auditOperation(context.TODO(), client, mytypes.AuditInsert, CredsDatabase, CredsSecrets, nil, nil, bson.M{"name": name, "kind": kind, "target": target, "current_version": version}, 1, nil)
+			fmt.Println("[+] Stored secret successfully")
+			return version, nil
+		}
+
+		set := bson.M{"current_version": version, "updated_at": sealed.CreatedAt}
+		if kind != "" {
+			set["kind"] = kind
+		}
+		if description != "" {
+			set["description"] = description
+		}
+		filter := bson.M{"name": name, "current_version": current.CurrentVersion}
+		update := bson.M{"$set": set, "$push": bson.M{"versions": sealed}}
+		result, err := coll.UpdateOne(context.TODO(), filter, update)
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, CredsDatabase, CredsSecrets, filter, nil, nil, 0, err)
+			return 0, fmt.Errorf("[-] Error storing secret: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			// another version was stored in the meantime, retry on top of it
+			continue
+		}
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, CredsDatabase, CredsSecrets, filter, bson.M{"current_version": current.CurrentVersion}, set, 1, nil)
+		fmt.Println("[+] Stored secret successfully")
+		return version, nil
+	}
+}
+
+// function auditSecretAccess to write a secret access record into the log database, in the target's collection or the global one, secret fetches are always audited even when AuditEnabled is off
+func auditSecretAccess(client *mongo.Client, target string, access mytypes.AuditRecord) {
+	access.Target = target
+	err := WriteAuditRecord(client, access)
+	if err != nil {
+		fmt.Println("[-] Error auditing secret access:", err)
+	}
+}
+
+// function GetSecret to fetch and decrypt the current version of the named secret, the fetch is audited into the log database under the given actor, returns the plaintext value and an error
+func GetSecret(client *mongo.Client, masterkey []byte, name string, actor string) ([]byte, error) {
+	return GetSecretVersion(client, masterkey, name, 0, actor)
+}
+
+// function GetSecretVersion to fetch and decrypt the given version (0 for the current one) of the named secret, the fetch is audited into the log database under the given actor, returns the plaintext value and an error
+func GetSecretVersion(client *mongo.Client, masterkey []byte, name string, version int, actor string) ([]byte, error) {
+	access := mytypes.AuditRecord{
+		Operation:  mytypes.AuditGetSecret,
+		Actor:      actor,
+		Database:   CredsDatabase,
+		Collection: CredsSecrets,
+		Details:    map[string]interface{}{"name": name, "version": version},
+		Timestamp:  time.Now().UTC(),
+	}
+	secret := &mytypes.Secret{}
+	err := client.Database(CredsDatabase).Collection(CredsSecrets).FindOne(context.TODO(), bson.M{"name": name}).Decode(secret)
+	if err != nil {
+		access.Error = err.Error()
+		auditSecretAccess(client, "", access)
+		return nil, fmt.Errorf("[-] Error getting secret: %v", err)
+	}
+	if version == 0 {
+		version = secret.CurrentVersion
+		access.Details["version"] = version
+	}
+
+	var found *mytypes.SecretVersion
+	for i := range secret.Versions {
+		if secret.Versions[i].Version == version {
+			found = &secret.Versions[i]
+		}
+	}
+	if found == nil {
+		access.Error = "version doesn't exist"
+		auditSecretAccess(client, secret.Target, access)
+		return nil, fmt.Errorf("[-] Error getting secret: version %d doesn't exist", version)
+	}
+	value, err := openSecret(masterkey, name, found)
+	if err != nil {
+		access.Error = err.Error()
+		auditSecretAccess(client, secret.Target, access)
+		return nil, fmt.Errorf("[-] Error getting secret: %v", err)
+	}
+	auditSecretAccess(client, secret.Target, access)
+
+	return value, nil
+}
+
+// function ListSecrets to list the secrets of a target (all secrets if target is empty), only metadata is returned, never the encrypted values, returns a slice of secret infos and an error
+func ListSecrets(client *mongo.Client, target string) ([]mytypes.SecretInfo, error) {
+	filter := bson.M{}
+	if target != "" {
+		filter["target"] = target
+	}
+	opts := options.Find().SetProjection(bson.M{"versions": 0}).SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := client.Database(CredsDatabase).Collection(CredsSecrets).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error listing secrets: %v", err)
+	}
+	secrets := []mytypes.SecretInfo{}
+	err = cursor.All(context.TODO(), &secrets)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding secrets: %v", err)
+	}
+
+	return secrets, nil
+}
+
+// function DeleteSecret to delete the named secret with all its versions, returns an error
+func DeleteSecret(client *mongo.Client, name string) error {
+	filter := bson.M{"name": name}
+	result, err := client.Database(CredsDatabase).Collection(CredsSecrets).DeleteOne(context.TODO(), filter)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditDelete, CredsDatabase, CredsSecrets, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error deleting secret: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("[-] Error deleting secret: secret doesn't exist")
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditDelete, CredsDatabase, CredsSecrets, filter, nil, nil, 1, nil)
+	fmt.Println("[+] Deleted secret successfully")
+
+	return nil
+}
+
+// function PruneSecretVersions to drop all but the latest keep versions of the named secret, returns an error
+func PruneSecretVersions(client *mongo.Client, name string, keep int) error {
+	if keep < 1 {
+		return fmt.Errorf("[-] Error pruning secret: at least one version must be kept")
+	}
+	filter := bson.M{"name": name}
+	update := bson.M{"$push": bson.M{"versions": bson.M{"$each": bson.A{}, "$slice": -keep}}}
+	result, err := client.Database(CredsDatabase).Collection(CredsSecrets).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, CredsDatabase, CredsSecrets, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error pruning secret: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error pruning secret: secret doesn't exist")
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, CredsDatabase, CredsSecrets, filter, nil, bson.M{"name": name, "kept_versions": keep}, result.ModifiedCount, nil)
+	fmt.Println("[+] Pruned secret versions successfully")
+
+	return nil
+}
+
+// function RotateMasterKey to re-wrap the data keys of every secret version wrapped with the old master key under the new one, the values themselves are not re-encrypted, returns the number of re-wrapped versions and an error
+func RotateMasterKey(client *mongo.Client, oldkey []byte, newkey []byte) (int, error) {
+	coll := client.Database(CredsDatabase).Collection(CredsSecrets)
+	oldid := myutils.KeyID(oldkey)
+	newid := myutils.KeyID(newkey)
+	cursor, err := coll.Find(context.TODO(), bson.M{"versions.key_id": oldid})
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error rotating master key: %v", err)
+	}
+	secrets := []mytypes.Secret{}
+	err = cursor.All(context.TODO(), &secrets)
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error rotating master key: %v", err)
+	}
+
+	rotated := 0
+	for _, secret := range secrets {
+		count := 0
+		for i := range secret.Versions {
+			version := &secret.Versions[i]
+			if version.KeyID != oldid {
+				continue
+			}
+			datakey, err := unwrapDataKey(oldkey, secret.Name, version)
+			if err != nil {
+				return rotated, fmt.Errorf("[-] Error rotating master key for %s: %v", secret.Name, err)
+			}
+			wrapped, keynonce, err := myutils.EncryptAESGCM(newkey, datakey, secretAAD("datakey", secret.Name, version.Version))
+			if err != nil {
+				return rotated, fmt.Errorf("[-] Error rotating master key for %s: %v", secret.Name, err)
+			}
+			version.WrappedKey = wrapped
+			version.KeyNonce = keynonce
+			version.KeyID = newid
+			count++
+		}
+		// only replace the versions if no new version was stored while we were re-wrapping
+		filter := bson.M{"_id": secret.ID, "current_version": secret.CurrentVersion}
+		update := bson.M{"$set": bson.M{"versions": secret.Versions}}
+		result, err := coll.UpdateOne(context.TODO(), filter, update)
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, CredsDatabase, CredsSecrets, filter, nil, nil, 0, err)
+			return rotated, fmt.Errorf("[-] Error rotating master key for %s: %v", secret.Name, err)
+		}
+		if result.MatchedCount == 0 {
+			return rotated, fmt.Errorf("[-] Error rotating master key: %s changed during rotation, run it again", secret.Name)
+		}
+		// the wrapped keys stay out of the record, only how many versions were re-wrapped
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, CredsDatabase, CredsSecrets, filter, nil, bson.M{"_id": secret.ID, "name": secret.Name, "rewrapped_versions": count}, 1, nil)
+		rotated += count
+	}
+	fmt.Printf("[+] Rotated master key successfully: %d versions re-wrapped\n", rotated)
+
+	return rotated, nil
+}
@@ -6,8 +6,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"healerdb/mytypes"
+
 	"github.com/tidwall/gjson"
 	"go.mongodb.org/mongo-driver/bson"          // ignore this error
 	"go.mongodb.org/mongo-driver/mongo"         // ignore this error
@@ -90,26 +94,41 @@ func CheckDatabase(client *mongo.Client, database string) (bool, error) {
 	return false, nil
 }
 
-// function PurgeDatabases to delete all the databases in the database except the admin and config databases, returns an error
-func PurgeDatabases(client *mongo.Client) error {
+// function PurgeDatabases to delete all the databases declared in the config file that exist on the server, other databases (admin, config, local, unrelated ones) are never touched, with opts.DryRun it only lists what would be removed and the confirmation token, otherwise opts.Confirm must be that token and opts.Backup takes a backup first, returns the plan and an error
+func PurgeDatabases(client *mongo.Client, opts mytypes.DestructiveOptions) (*mytypes.DestructivePlan, error) {
 	// Get all the databases
 	databases, err := GetDatabases(client)
 	if err != nil {
-		return fmt.Errorf("[-] Error purging databases: %v", err)
+		return nil, fmt.Errorf("[-] Error purging databases: %v", err)
 	}
 
-	// Delete all the databases except the admin and config databases
+	// Only the databases declared in the config file are purged
+	plan := &mytypes.DestructivePlan{Operation: "purge", Items: []mytypes.DestructiveItem{}}
 	for _, db := range databases {
-		if db != "admin" && db != "config" {
-			err = client.Database(db).Drop(context.Background())
+		if !IsManagedDatabase(db) {
+			continue
+		}
+		item, err := databaseItem(client, db)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error purging databases: %v", err)
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	return runDestructive(client, plan, opts, func() error {
+		var dropped int64
+		for _, item := range plan.Items {
+			err := client.Database(item.Database).Drop(context.Background())
 			if err != nil {
+				auditOperation(context.TODO(), client, mytypes.AuditPurge, "*", "", nil, nil, nil, dropped, err)
 				return fmt.Errorf("[-] Error purging databases: %v", err)
 			}
+			dropped++
 		}
-	}
-	fmt.Println("[+] Purged databases successfully")
-
-	return nil
+		auditOperation(context.TODO(), client, mytypes.AuditPurge, "*", "", nil, nil, nil, dropped, nil)
+		fmt.Println("[+] Purged databases successfully")
+		return nil
+	})
 }
 
 // function to check if a collection with the given name exists in the given database, returns a boolean and an error
@@ -154,10 +173,14 @@ func CreateDocument(client *mongo.Client, database string, collection string, do
 	}
 
 	// insert the document into the collection
-	_, err = client.Database(database).Collection(collection).InsertOne(context.TODO(), doc)
+	result, err := client.Database(database).Collection(collection).InsertOne(context.TODO(), doc)
 	if err != nil {
 		return fmt.Errorf("[-] error creating document: %v", err)
 	}
+	if after, ok := toM(doc); ok {
+		after["_id"] = result.InsertedID
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, database, collection, nil, nil, after, 1, nil)
+	}
 	fmt.Println("[+] Created document successfully")
 
 	return nil
@@ -184,9 +207,21 @@ func CreateCollection(client *mongo.Client, database string, collection string)
 	return nil
 }
 
-// function to create a database, with the provided name, returns an error -> for creating a database, it just creates a collection in the database with the name 'exists' and the content as 'exists': true
+// function skipMarker to copy the given filter and exclude the '{"exists": true}' marker documents that CreateCollection inserts, returns the new filter
+func skipMarker(filter bson.M) bson.M {
+	newfilter := bson.M{}
+	for k, v := range filter {
+		newfilter[k] = v
+	}
+	if _, ok := newfilter["exists"]; !ok {
+		newfilter["exists"] = bson.M{"$exists": false}
+	}
+	return newfilter
+}
+
+// function to create a database, with the provided name, returns an error -> for creating a database, it just creates a collection in the database with the name 'exists' and the content as 'exists': true, the marker has a fixed _id so of two concurrent calls only one creates the database
 func CreateDatabase(client *mongo.Client, database string) error {
-	// Check if the database exists
+	// Check if the database exists, databases that weren't created here have no marker
 	exists, err2 := CheckDatabase(client, database)
 	if err2 != nil {
 		return fmt.Errorf("[-] Error creating database: %v", err2)
@@ -195,38 +230,75 @@ func CreateDatabase(client *mongo.Client, database string) error {
 		return fmt.Errorf("[-] Error creating database: database already exists")
 	}
 
-	// Create a database, use CreateCollection function to create a collection in the database
-	err := CreateCollection(client, database, "exists")
+	// Create a database, the insert of the marker decides between racing callers
+	_, err := client.Database(database).Collection("exists").InsertOne(context.TODO(), bson.M{"_id": "exists", "exists": true})
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("[-] Error creating database: database already exists")
+	}
 	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, database, "exists", nil, nil, nil, 0, err)
 		return fmt.Errorf("[-] Error creating database: %v", err)
 	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, database, "exists", nil, nil, bson.M{"_id": "exists", "exists": true}, 1, nil)
 	fmt.Println("[+] Created database successfully")
 
 	return nil
 }
 
-// function to drop a collection, with the provided name(removes if exists), in the given database, returns an error
-func DropCollection(client *mongo.Client, database string, collection string) error {
-	// Drop a collection
-	err := client.Database(database).Collection(collection).Drop(nil)
+// function to drop a collection, with the provided name(removes if exists), in the given database declared in the config file, see PurgeDatabases for the dry run, confirmation and backup options, returns the plan and an error
+func DropCollection(client *mongo.Client, database string, collection string, opts mytypes.DestructiveOptions) (*mytypes.DestructivePlan, error) {
+	if !IsManagedDatabase(database) {
+		return nil, fmt.Errorf("[-] Error dropping collection: database %s is not declared in the config", database)
+	}
+	exists, err := CheckCollection(client, database, collection)
 	if err != nil {
-		return fmt.Errorf("[-] Error dropping collection: %v", err)
+		return nil, fmt.Errorf("[-] Error dropping collection: %v", err)
+	}
+	plan := &mytypes.DestructivePlan{Operation: "drop_collection", Items: []mytypes.DestructiveItem{}}
+	if exists {
+		plan.Items = append(plan.Items, mytypes.DestructiveItem{Database: database, Collection: collection, Documents: countDocuments(client, database, collection)})
 	}
-	fmt.Println("[+] Dropped collection successfully")
 
-	return nil
+	return runDestructive(client, plan, opts, func() error {
+		// Drop a collection
+		err := client.Database(database).Collection(collection).Drop(nil)
+		auditOperation(context.TODO(), client, mytypes.AuditDropCollection, database, collection, nil, nil, nil, plan.Items[0].Documents, err)
+		if err != nil {
+			return fmt.Errorf("[-] Error dropping collection: %v", err)
+		}
+		fmt.Println("[+] Dropped collection successfully")
+		return nil
+	})
 }
 
-// function to drop a database, with the provided name(removes if exists), returns an error
-func DropDatabase(client *mongo.Client, database string) error {
-	// Drop a database
-	err := client.Database(database).Drop(nil)
+// function to drop a database, with the provided name(removes if exists) and declared in the config file, see PurgeDatabases for the dry run, confirmation and backup options, returns the plan and an error
+func DropDatabase(client *mongo.Client, database string, opts mytypes.DestructiveOptions) (*mytypes.DestructivePlan, error) {
+	if !IsManagedDatabase(database) {
+		return nil, fmt.Errorf("[-] Error dropping database: database %s is not declared in the config", database)
+	}
+	exists, err := CheckDatabase(client, database)
 	if err != nil {
-		return fmt.Errorf("[-] Error dropping database: %v", err)
+		return nil, fmt.Errorf("[-] Error dropping database: %v", err)
+	}
+	plan := &mytypes.DestructivePlan{Operation: "drop_database", Items: []mytypes.DestructiveItem{}}
+	if exists {
+		item, err := databaseItem(client, database)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error dropping database: %v", err)
+		}
+		plan.Items = append(plan.Items, item)
 	}
-	fmt.Println("[+] Dropped database successfully")
 
-	return nil
+	return runDestructive(client, plan, opts, func() error {
+		// Drop a database
+		err := client.Database(database).Drop(nil)
+		auditOperation(context.TODO(), client, mytypes.AuditDropDatabase, database, "", nil, nil, nil, plan.Items[0].Documents, err)
+		if err != nil {
+			return fmt.Errorf("[-] Error dropping database: %v", err)
+		}
+		fmt.Println("[+] Dropped database successfully")
+		return nil
+	})
 }
 
 // function to get the pointer to the client to the database, a database name, fetches all collection names in the given database and returns a slice of strings containing the names of the collections and an error
@@ -241,6 +313,24 @@ func GetCollections(client *mongo.Client, database string) ([]string, error) {
 	return collections, nil
 }
 
+// function ListTargets to get the targets of a target based database, its collections without the database marker, the global log and the system collections, returns the sorted names and an error
+func ListTargets(client *mongo.Client, database string) ([]string, error) {
+	names, err := client.Database(database).ListCollectionNames(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error listing targets: %v", err)
+	}
+	targets := []string{}
+	for _, name := range names {
+		if name == "exists" || name == GlobalLogCollection || strings.HasPrefix(name, "system.") {
+			continue
+		}
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+
+	return targets, nil
+}
+
 // function to get the pointer to the client to the database, a database name and a collection name, fetches all documents in the given collection, converts each document to a string of json object and returns a slice of strings containing the json objects and an error
 func GetDocuments(client *mongo.Client, database string, collection string) ([]string, error) {
 	// Get all the documents in the collection
@@ -288,7 +378,7 @@ func GetDocument(client *mongo.Client, database string, collection string, id st
 // function to get pointer to client to database, database name, collection name and document id, deletes the document with the given id from the given collection in the given database, returns an error
 func DeleteDocument(client *mongo.Client, database string, collection string, id string) error {
 	// Delete the document with the given id from the collection if exists
-	var document string = ""
+	var document bson.M
 
 	// Convert the id to an object id
 	objectid, err := primitive.ObjectIDFromHex(id)
@@ -303,6 +393,7 @@ func DeleteDocument(client *mongo.Client, database string, collection string, id
 	if err != nil {
 		return fmt.Errorf("[-] Error deleting document: %v", err)
 	}
+	auditOperation(context.TODO(), client, mytypes.AuditDelete, database, collection, filter, document, nil, 1, nil)
 	fmt.Println("[+] Deleted document successfully")
 
 	return nil
@@ -318,10 +409,12 @@ func InsertDocument(client *mongo.Client, database string, collection string, do
 	}
 
 	// Insert the document into the collection
-	_, err = client.Database(database).Collection(collection).InsertOne(nil, bsondocument)
+	result, err := client.Database(database).Collection(collection).InsertOne(nil, bsondocument)
 	if err != nil {
 		return fmt.Errorf("[-] Error inserting document: %v", err)
 	}
+	bsondocument["_id"] = result.InsertedID
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, database, collection, nil, nil, bsondocument, 1, nil)
 	fmt.Println("[+] Inserted document successfully")
 
 	return nil
@@ -337,10 +430,11 @@ func InsertDocuments(client *mongo.Client, database string, collection string, d
 	}
 
 	// Insert the documents into the collection
-	_, err = client.Database(database).Collection(collection).InsertMany(nil, bsondocuments)
+	result, err := client.Database(database).Collection(collection).InsertMany(nil, bsondocuments)
 	if err != nil {
 		return fmt.Errorf("[-] Error inserting documents: %v", err)
 	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, database, collection, nil, nil, nil, int64(len(result.InsertedIDs)), nil)
 	fmt.Println("[+] Inserted documents successfully")
 
 	return nil
@@ -382,6 +476,47 @@ func AddTarget(client *mongo.Client, database string, target string) error {
 	return nil
 }
 
+// function CreateTarget to create a target in every target based database declared in the config file (the log database gets its collection with the first audit record), the marker documents are inserted in one transaction so the target is created in all of them or in none (on a standalone server this fails unless AllowNonTransactional is set, and then the inserts aren't atomic), databases that already have it are left alone, returns the databases the target was created in and an error
+func CreateTarget(client *mongo.Client, target string) ([]string, error) {
+	if target == "" || target == "exists" || target == GlobalLogCollection {
+		return nil, fmt.Errorf("[-] Error creating target: invalid target name %q", target)
+	}
+
+	created := []string{}
+	err := WithTransaction(context.TODO(), client, func(tx mongo.SessionContext) error {
+		// a retried transaction starts over
+		created = created[:0]
+		for _, database := range TargetDatabases() {
+			if database == LogDatabase {
+				continue
+			}
+			coll := client.Database(database).Collection(target)
+			count, err := coll.CountDocuments(tx, bson.M{"exists": true}, options.Count().SetLimit(1))
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+			_, err = coll.InsertOne(tx, bson.M{"exists": true})
+			if err != nil {
+				return err
+			}
+			created = append(created, database)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error creating target: %v", err)
+	}
+	for _, database := range created {
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, database, target, nil, nil, bson.M{"exists": true}, 1, nil)
+	}
+	fmt.Println("[+] Created target successfully")
+
+	return created, nil
+}
+
 // function CheckTarget to check if a collection exists in the database, returns a boolean and an error
 func CheckTarget(client *mongo.Client, database string, target string) (bool, error) {
 	// Query the database for the collection, use CheckCollection() to check if a collection exists
@@ -447,75 +582,80 @@ func CheckDomain(client *mongo.Client, database string, collection string, domai
 	}
 }
 
-// function AddDomain to add a domain to the database, returns an error -> get client, db, collection(target), domain string
-func AddDomain(client *mongo.Client, database string, target string, domain string) error {
-	// Check if the domain already exists, use CheckDomain() to check if a domain exists
-	exists, err := CheckDomain(client, database, target, domain)
+// function GetTargetDomains to get the domain names stored in the enum tree of a target, returns a slice of strings and an error
+func GetTargetDomains(client *mongo.Client, target string) ([]string, error) {
+	values, err := client.Database(EnumDatabase).Collection(target).Distinct(context.TODO(), "domain", bson.M{"domain": bson.M{"$exists": true}})
 	if err != nil {
-		return fmt.Errorf("[-] Error checking domain: %v", err)
+		return nil, fmt.Errorf("[-] Error getting target domains: %v", err)
 	}
-	if exists {
-		return fmt.Errorf("[-] Domain already exists")
-	}
-	// Create a bson object with the domain `{"domain": domain}`
-	bsondomain := bson.M{"domain": domain}
-	// convert to json string
-	jsondomain, err := json.Marshal(bsondomain)
-	if err != nil {
-		return fmt.Errorf("[-] Error converting bson to json: %v", err)
+	domains := []string{}
+	for _, value := range values {
+		if domain, ok := value.(string); ok {
+			domains = append(domains, domain)
+		}
 	}
 
-	// Check if the domain exists in the collection, use CheckDomain() to check if a domain exists
-	// Insert the bson object into the collection use InsertDocument() to insert a document
-	err = InsertDocument(client, database, target, string(jsondomain))
-	if err != nil {
-		return fmt.Errorf("[-] Error adding domain: %v", err)
+	return domains, nil
+}
+
+// function MatchDomain to find the most specific of the given domains that the host name is equal to or a subdomain of, returns an empty string if none matches
+func MatchDomain(domains []string, host string) string {
+	best := ""
+	for _, domain := range domains {
+		if (host == domain || strings.HasSuffix(host, "."+domain)) && len(domain) > len(best) {
+			best = domain
+		}
 	}
-	fmt.Println("[+] Added domain successfully")
+	return best
+}
 
-	return nil
+// function ensureEnumIndexes to create the declared indexes of a target's enum collection once per process, the unique index on the domain is what makes the domain upserts race free (the marker document has no domain and is left out by its partial filter)
+func ensureEnumIndexes(client *mongo.Client, database string, target string) error {
+	return ensureIndexes(client, database, target)
+}
+
+// function normalizeHost to lower case a domain or subdomain and strip the spaces and the trailing dot
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
 }
 
-// function UpdateDocument to update a document in the database, returns an error -> get client, db, collection name, document id, json string as parameters
-func UpdateOneDocument(client *mongo.Client, database string, collection string, id string, jsonstring string) error {
-	filter := bson.M{"_id": id}
-	fmt.Println("[+] Updating document with id: " + "\"" + id + "\"")
-	// Query document with the provided id, use QueryDocuments() to query the database
-	jsondocuments, err := QueryDocuments(client, database, collection, filter)
+// function AddDomain to add a domain to a target, the domain document is created by a single upsert on the unique domain index so concurrent workers never create duplicates, adding an existing domain is not an error, returns whether the domain was created and an error
+func AddDomain(client *mongo.Client, database string, target string, domain string) (bool, error) {
+	domain = normalizeHost(domain)
+	if domain == "" {
+		return false, fmt.Errorf("[-] Error adding domain: domain is required")
+	}
+	err := ensureEnumIndexes(client, database, target)
 	if err != nil {
-		return fmt.Errorf("[-] Error updating document: %v", err)
+		return false, fmt.Errorf("[-] Error adding domain: %v", err)
 	}
 
-	// Check if the json string is empty, if it is return an error
-	if jsondocuments == "null" {
-		return fmt.Errorf("[-] Document doesn't exist")
-	}
-	if jsondocuments == "" {
-		return fmt.Errorf("[-] Document doesn't exist")
+	now := time.Now().UTC()
+	update := bson.M{"$setOnInsert": bson.M{"domain": domain, "subdomains": bson.A{}, "first_seen": now, "last_seen": now, "seen_count": 1, "sources": bson.A{}}}
+	result, err := client.Database(database).Collection(target).UpdateOne(context.TODO(), bson.M{"domain": domain}, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent upsert inserted it first
+		return false, nil
 	}
-	if jsondocuments == "[]" {
-		return fmt.Errorf("[-] Document doesn't exist")
+	if err != nil {
+		return false, fmt.Errorf("[-] Error adding domain: %v", err)
 	}
-	if jsondocuments == "{}" {
-		return fmt.Errorf("[-] Document doesn't exist")
+	if result.UpsertedCount == 0 {
+		return false, nil
 	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, database, target, nil, nil, bson.M{"_id": result.UpsertedID, "domain": domain}, 1, nil)
+	fmt.Println("[+] Added domain successfully")
 
-	// Convert the raw json to bson
-	var bsondocument bson.M
-	err = bson.UnmarshalExtJSON([]byte(jsonstring), true, &bsondocument)
-	if err != nil {
-		return fmt.Errorf("[-] Error converting json to bson: %v", err)
-	}
+	return true, nil
+}
 
-	// Update the document with the provided id, use UpdateOne() to update a document
-	update := bson.M{"$set": bsondocument}
-	result, err := client.Database(database).Collection(collection).UpdateOne(context.TODO(), filter, update)
+// function UpdateOneDocument to update the document with the given id (an ObjectID, or a string that matches either an ObjectID or a string _id, an upsert of a hex string that matches neither inserts a document with a new ObjectID) in the database, the json string is an update document ($set, $unset, $inc, $push, $addToSet, ...) or plain fields which are $set, returns the matched/modified/upserted counts and an error
+func UpdateOneDocument(client *mongo.Client, database string, collection string, id interface{}, jsonstring string, upsert bool) (*mytypes.UpdateResult, error) {
+	filter, err := IDFilter(id)
 	if err != nil {
-		return fmt.Errorf("[-] Error updating document: %v", err)
+		return nil, fmt.Errorf("[-] Error updating document: %v", err)
 	}
-	fmt.Printf("[+] Updated document successfully: %v", result.ModifiedCount)
-
-	return nil
+	return UpdateOne(client, database, collection, filter, jsonstring, upsert)
 }
 
 // function AddSubdomain to add a subdomain to the provided database name, coll name, inside the document with the provided domain name, returns an error, create the document with the provided domain name if it doesn't exist... also return the jsondocuments which is the result of the query
@@ -555,4 +695,43 @@ func CheckSubdomain(client *mongo.Client, database string, collection string, do
 	return jsondocuments, false, nil
 }
 
-// function AddNewSubdomain to add new subdomain to the provided database name, coll name, inside the document with the provided domain name, returns an error, create the document with the provided domain name if it doesn't exist
+// function AddSubdomain to add a subdomain under a domain of a target, the domain document is created if it doesn't exist, the subdomain is pushed by one conditional upsert (only if it isn't there yet) so concurrent workers never create duplicates, adding an existing subdomain is not an error, returns whether the subdomain was created and an error
+func AddSubdomain(client *mongo.Client, database string, target string, domain string, subdomain string) (bool, error) {
+	domain = normalizeHost(domain)
+	subdomain = normalizeHost(subdomain)
+	if domain == "" || !strings.HasSuffix(subdomain, "."+domain) {
+		return false, fmt.Errorf("[-] Error adding subdomain: %q is not a subdomain of %q", subdomain, domain)
+	}
+	err := ensureEnumIndexes(client, database, target)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error adding subdomain: %v", err)
+	}
+
+	coll := client.Database(database).Collection(target)
+	// subdomain nodes may be documents or plain names
+	filter := bson.M{"domain": domain, "subdomains.subdomain": bson.M{"$ne": subdomain}, "subdomains": bson.M{"$ne": subdomain}}
+	now := time.Now().UTC()
+	update := bson.M{
+		"$push": bson.M{"subdomains": bson.M{"subdomain": subdomain, "first_seen": now, "last_seen": now, "seen_count": 1, "sources": bson.A{}}},
+		// a domain document created by the upsert gets its provenance like AddDomain gives it
+		"$setOnInsert": bson.M{"first_seen": now, "last_seen": now, "seen_count": 1, "sources": bson.A{}},
+	}
+	result, err := coll.UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// the domain exists and either has the subdomain or was inserted by a concurrent upsert, push again without inserting
+		result, err = coll.UpdateOne(context.TODO(), filter, update)
+	}
+	if err != nil {
+		return false, fmt.Errorf("[-] Error adding subdomain: %v", err)
+	}
+	if result.ModifiedCount == 0 && result.UpsertedCount == 0 {
+		return false, nil
+	}
+	if result.UpsertedID != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, database, target, nil, nil, bson.M{"_id": result.UpsertedID, "domain": domain}, 1, nil)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, database, target, bson.M{"domain": domain}, nil, bson.M{"domain": domain, "subdomain": subdomain}, 1, nil)
+	fmt.Println("[+] Added subdomain successfully")
+
+	return true, nil
+}
@@ -0,0 +1,267 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		DNS                      ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// function normalizeIPs to write ip addresses in their canonical form, sorted and without duplicates, returns the addresses and an error for the first invalid one
+func normalizeIPs(ips []string) ([]string, error) {
+	normalized := []string{}
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid ip address %q", ip)
+		}
+		if !myutils.ContainsString(normalized, parsed.String()) {
+			normalized = append(normalized, parsed.String())
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// function normalizeHosts to lower case host names and strip their trailing dot, sorted and without duplicates unless keeporder is set (a CNAME chain keeps its order)
+func normalizeHosts(hosts []string, keeporder bool) []string {
+	normalized := []string{}
+	for _, host := range hosts {
+		host = normalizeHost(host)
+		if host != "" && !myutils.ContainsString(normalized, host) {
+			normalized = append(normalized, host)
+		}
+	}
+	if !keeporder {
+		sort.Strings(normalized)
+	}
+	return normalized
+}
+
+// function normalizeDNS to check and normalize the records of a resolution, a CNAME without any address is flagged as dangling, returns the records and an error
+func normalizeDNS(records mytypes.DNSRecords) (mytypes.DNSRecords, error) {
+	var err error
+	records.A, err = normalizeIPs(records.A)
+	if err != nil {
+		return records, err
+	}
+	records.AAAA, err = normalizeIPs(records.AAAA)
+	if err != nil {
+		return records, err
+	}
+	records.CNAME = normalizeHosts(records.CNAME, true)
+	records.NS = normalizeHosts(records.NS, false)
+	mx := []mytypes.MXRecord{}
+	for _, record := range records.MX {
+		record.Host = normalizeHost(record.Host)
+		if record.Host != "" {
+			mx = append(mx, record)
+		}
+	}
+	sort.Slice(mx, func(i, j int) bool {
+		if mx[i].Preference != mx[j].Preference {
+			return mx[i].Preference < mx[j].Preference
+		}
+		return mx[i].Host < mx[j].Host
+	})
+	records.MX = mx
+	if records.TXT == nil {
+		records.TXT = []string{}
+	}
+	if len(records.CNAME) > 0 && len(records.A) == 0 && len(records.AAAA) == 0 {
+		records.DanglingCNAME = true
+	}
+	if records.ResolvedAt.IsZero() {
+		records.ResolvedAt = time.Now().UTC()
+	}
+	return records, nil
+}
+
+// function SetSubdomainDNS to store the resolution of a subdomain of a target, the subdomain is added to the enum doc tree if needed and its previous resolution is replaced, returns an error
+func SetSubdomainDNS(client *mongo.Client, target string, domain string, subdomain string, records mytypes.DNSRecords) error {
+	asset := canonicalAsset(mytypes.TargetAsset{Type: mytypes.AssetSubdomain, Domain: domain, Subdomain: subdomain})
+	if asset.Domain == "" || asset.Subdomain != asset.Domain && !strings.HasSuffix(asset.Subdomain, "."+asset.Domain) {
+		return fmt.Errorf("[-] Error setting dns records: %q is not a subdomain of %q", asset.Subdomain, asset.Domain)
+	}
+	records, err := normalizeDNS(records)
+	if err != nil {
+		return fmt.Errorf("[-] Error setting dns records: %v", err)
+	}
+	err = ensureEnumIndexes(client, EnumDatabase, target)
+	if err != nil {
+		return fmt.Errorf("[-] Error setting dns records: %v", err)
+	}
+
+	// the subdomain is added, and a plain string node becomes a document, before the array filter can match it
+	coll := client.Database(EnumDatabase).Collection(target)
+	doc, err := assetDocument(asset)
+	if err == nil {
+		_, err = mergeDocument(coll, stampProvenance(doc))
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error setting dns records: %v", err)
+	}
+	prefix, filters, _ := assetUpdatePath(asset)
+	update := bson.M{"$set": bson.M{prefix + "dns": records}}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters})
+	result, err := coll.UpdateOne(context.TODO(), bson.M{"domain": asset.Domain}, update, opts)
+	if err != nil {
+		return fmt.Errorf("[-] Error setting dns records: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, bson.M{"domain": asset.Domain}, nil, bson.M{"subdomain": asset.Subdomain, "dns": records}, result.ModifiedCount, nil)
+	fmt.Println("[+] Set dns records successfully")
+
+	return nil
+}
+
+// function GetSubdomainDNS to get the stored resolution of a subdomain of a target, returns the records (nil if it was never resolved) and an error
+func GetSubdomainDNS(client *mongo.Client, target string, subdomain string) (*mytypes.DNSRecords, error) {
+	subdomain = normalizeHost(subdomain)
+	opts := options.FindOne().SetProjection(bson.M{"subdomains": bson.M{"$elemMatch": bson.M{"subdomain": subdomain}}})
+	doc := bson.M{}
+	err := client.Database(EnumDatabase).Collection(target).FindOne(context.TODO(), bson.M{"subdomains.subdomain": subdomain}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting dns records: %v", err)
+	}
+	for _, node := range toA(doc["subdomains"]) {
+		_, subdoc := enumNodeName(node, "subdomain")
+		if records, ok := nodeDNS(subdoc); ok {
+			return &records, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// function nodeDNS to decode the resolution of a subdomain node, returns false if it has none
+func nodeDNS(node bson.M) (mytypes.DNSRecords, bool) {
+	records := mytypes.DNSRecords{}
+	if node == nil || node["dns"] == nil {
+		return records, false
+	}
+	raw, err := bson.Marshal(node["dns"])
+	if err != nil || bson.Unmarshal(raw, &records) != nil {
+		return records, false
+	}
+	return records, true
+}
+
+// function dnsFilter to select the domain documents having a subdomain that matches the query with the dns indexes
+func dnsFilter(query mytypes.DNSQuery) (bson.M, error) {
+	conds := bson.A{}
+	if query.IP != "" {
+		ip := net.ParseIP(query.IP)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip address %q", query.IP)
+		}
+		conds = append(conds, bson.M{"$or": bson.A{bson.M{"dns.a": ip.String()}, bson.M{"dns.aaaa": ip.String()}}})
+	}
+	if query.CNAME != "" {
+		conds = append(conds, bson.M{"dns.cname": normalizeHost(query.CNAME)})
+	}
+	if query.DanglingOnly {
+		conds = append(conds, bson.M{"dns.dangling_cname": true})
+	}
+	if query.ExcludeWildcard {
+		conds = append(conds, bson.M{"dns.wildcard": bson.M{"$ne": true}})
+	}
+	if len(conds) == 0 {
+		conds = append(conds, bson.M{"dns": bson.M{"$exists": true}})
+	}
+	return bson.M{"subdomains": bson.M{"$elemMatch": bson.M{"$and": conds}}}, nil
+}
+
+// function dnsMatches to check the resolution of a subdomain against the query
+func dnsMatches(query mytypes.DNSQuery, records mytypes.DNSRecords) bool {
+	if query.IP != "" {
+		ip := net.ParseIP(query.IP).String()
+		if !myutils.ContainsString(records.A, ip) && !myutils.ContainsString(records.AAAA, ip) {
+			return false
+		}
+	}
+	if query.CNAME != "" && !myutils.ContainsString(records.CNAME, normalizeHost(query.CNAME)) {
+		return false
+	}
+	if query.DanglingOnly && !records.DanglingCNAME {
+		return false
+	}
+	if query.ExcludeWildcard && records.Wildcard {
+		return false
+	}
+	return true
+}
+
+// function FindSubdomainsByDNS to get the subdomains whose resolution matches the query across targets, e.g. the subdomains pointing to an ip or sharing a CNAME target, returns the matches in the order of the targets and domains and an error
+func FindSubdomainsByDNS(client *mongo.Client, query mytypes.DNSQuery) ([]mytypes.DNSMatch, error) {
+	filter, err := dnsFilter(query)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error finding subdomains: %v", err)
+	}
+	targets := query.Targets
+	if len(targets) == 0 {
+		targets, err = ListTargets(client, EnumDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding subdomains: %v", err)
+		}
+	}
+
+	matches := []mytypes.DNSMatch{}
+	for _, target := range targets {
+		opts := options.Find().SetSort(bson.D{{Key: "domain", Value: 1}}).SetProjection(bson.M{"domain": 1, "subdomains.subdomain": 1, "subdomains.dns": 1})
+		cursor, err := client.Database(EnumDatabase).Collection(target).Find(context.TODO(), filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding subdomains: %v", err)
+		}
+		docs := []bson.M{}
+		err = cursor.All(context.TODO(), &docs)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding subdomains: %v", err)
+		}
+		for _, doc := range docs {
+			domain, _ := doc["domain"].(string)
+			for _, node := range toA(doc["subdomains"]) {
+				name, subdoc := enumNodeName(node, "subdomain")
+				records, ok := nodeDNS(subdoc)
+				if !ok || !dnsMatches(query, records) {
+					continue
+				}
+				matches = append(matches, mytypes.DNSMatch{Target: target, Domain: domain, Subdomain: name, DNS: records})
+				if query.Limit > 0 && len(matches) >= query.Limit {
+					return matches, nil
+				}
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// function SubdomainsByIP to get the subdomains of all the targets pointing to an ip address, returns the matches and an error
+func SubdomainsByIP(client *mongo.Client, ip string) ([]mytypes.DNSMatch, error) {
+	return FindSubdomainsByDNS(client, mytypes.DNSQuery{IP: ip})
+}
+
+// function SubdomainsByCNAME to get the subdomains of all the targets whose CNAME chain goes through a host, returns the matches and an error
+func SubdomainsByCNAME(client *mongo.Client, cname string) ([]mytypes.DNSMatch, error) {
+	return FindSubdomainsByDNS(client, mytypes.DNSQuery{CNAME: cname})
+}
@@ -0,0 +1,188 @@
+package dbquery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"healerdb/config"
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Destructive guard        ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// databases declared in the config file, and the target based ones, read on first use
+var (
+	databasesOnce    sync.Once
+	managedDatabases []string
+	targetDatabases  []string
+)
+
+// function loadDatabases to read the databases declared in the config file once per process, when it can't be read no database is managed so destructive operations refuse everything
+func loadDatabases() {
+	databasesOnce.Do(func() {
+		dbs, err := config.GetDatabases()
+		if err != nil {
+			fmt.Println("[-] Error reading databases config:", err)
+			return
+		}
+		for _, db := range dbs {
+			managedDatabases = append(managedDatabases, db.Name)
+			if db.TargetBased {
+				targetDatabases = append(targetDatabases, db.Name)
+			}
+		}
+	})
+}
+
+// function ManagedDatabases to get the databases declared in the config file, destructive operations refuse to touch any other database
+func ManagedDatabases() []string {
+	loadDatabases()
+	return managedDatabases
+}
+
+// function TargetDatabases to get the databases declared target based in the config file, their collections are targets
+func TargetDatabases() []string {
+	loadDatabases()
+	return targetDatabases
+}
+
+// function IsManagedDatabase to check whether a database is declared in the config file
+func IsManagedDatabase(database string) bool {
+	return myutils.ContainsString(ManagedDatabases(), database)
+}
+
+// function planToken to compute the confirmation token of a plan, it only depends on the operation and the removed databases and collections so a dry run token stays valid until that set changes, it guards against mistakes and is not an authorization
+func planToken(plan *mytypes.DestructivePlan) string {
+	hash := sha256.New()
+	hash.Write([]byte(plan.Operation))
+	for _, item := range plan.Items {
+		hash.Write([]byte("\x00" + item.Database + "\x00" + item.Collection))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// function countDocuments to estimate the documents of a collection for a plan
+func countDocuments(client *mongo.Client, database string, collection string) int64 {
+	count, err := client.Database(database).Collection(collection).EstimatedDocumentCount(context.TODO())
+	if err != nil {
+		return -1
+	}
+	return count
+}
+
+// function databaseItem to describe a whole database for a plan, with the documents of all its collections
+func databaseItem(client *mongo.Client, database string) (mytypes.DestructiveItem, error) {
+	item := mytypes.DestructiveItem{Database: database}
+	collections, err := client.Database(database).ListCollectionNames(context.TODO(), bson.M{})
+	if err != nil {
+		return item, err
+	}
+	for _, collection := range collections {
+		if count := countDocuments(client, database, collection); count > 0 {
+			item.Documents += count
+		}
+	}
+	return item, nil
+}
+
+// function runDestructive to carry out a destructive plan, a dry run only prints and returns the plan, otherwise the confirmation token must match, an optional backup of the items is taken and then execute is called, returns the plan and an error
+func runDestructive(client *mongo.Client, plan *mytypes.DestructivePlan, opts mytypes.DestructiveOptions, execute func() error) (*mytypes.DestructivePlan, error) {
+	plan.Token = planToken(plan)
+	if opts.DryRun {
+		plan.DryRun = true
+		for _, item := range plan.Items {
+			if item.Collection == "" {
+				fmt.Printf("[*] %s would drop database %s (%d documents)\n", plan.Operation, item.Database, item.Documents)
+			} else {
+				fmt.Printf("[*] %s would drop collection %s.%s (%d documents)\n", plan.Operation, item.Database, item.Collection, item.Documents)
+			}
+		}
+		fmt.Printf("[*] confirm with token %s\n", plan.Token)
+		return plan, nil
+	}
+	if len(plan.Items) == 0 {
+		fmt.Printf("[+] %s: nothing to remove\n", plan.Operation)
+		return plan, nil
+	}
+	if opts.Confirm != plan.Token {
+		return plan, fmt.Errorf("[-] Error in %s: confirmation token doesn't match, do a dry run to review what would be removed and get the token", plan.Operation)
+	}
+
+	if opts.Backup {
+		databases := map[string][]string{}
+		for _, item := range plan.Items {
+			if item.Collection == "" {
+				databases[item.Database] = nil
+				continue
+			}
+			databases[item.Database] = append(databases[item.Database], item.Collection)
+		}
+		path, _, err := Backup(client, opts.BackupDir, "", databases)
+		if err != nil {
+			return plan, fmt.Errorf("[-] Error in %s: backup failed, nothing was removed: %v", plan.Operation, err)
+		}
+		plan.Backup = path
+	}
+
+	return plan, execute()
+}
+
+// function DeleteTarget to drop the collection of a target in every target based database declared in the config file, the documents of all of them are deleted in one transaction before the collections are dropped (on a standalone server this fails unless AllowNonTransactional is set, and then a failure can leave the target half deleted), the target's audit log is kept and expires with the audit retention, see PurgeDatabases for the dry run, confirmation and backup options, returns the plan and an error
+func DeleteTarget(client *mongo.Client, target string, opts mytypes.DestructiveOptions) (*mytypes.DestructivePlan, error) {
+	if target == "" || target == "exists" || target == GlobalLogCollection {
+		return nil, fmt.Errorf("[-] Error deleting target: invalid target name %q", target)
+	}
+	plan := &mytypes.DestructivePlan{Operation: "delete_target", Items: []mytypes.DestructiveItem{}}
+	for _, database := range TargetDatabases() {
+		if !IsManagedDatabase(database) || database == LogDatabase {
+			continue
+		}
+		exists, err := CheckCollection(client, database, target)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error deleting target: %v", err)
+		}
+		if exists {
+			plan.Items = append(plan.Items, mytypes.DestructiveItem{Database: database, Collection: target, Documents: countDocuments(client, database, target)})
+		}
+	}
+
+	return runDestructive(client, plan, opts, func() error {
+		// the documents go in one transaction so a failure can't leave the target half deleted, very large targets can hit the transaction time limit and need DropCollection per database instead
+		err := WithTransaction(context.TODO(), client, func(tx mongo.SessionContext) error {
+			for _, item := range plan.Items {
+				_, err := client.Database(item.Database).Collection(item.Collection).DeleteMany(tx, bson.M{})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditDropCollection, "*", target, nil, nil, nil, 0, err)
+			return fmt.Errorf("[-] Error deleting target: %v", err)
+		}
+		// collections can't be dropped in a transaction, they are empty by now
+		for _, item := range plan.Items {
+			err := client.Database(item.Database).Collection(item.Collection).Drop(context.TODO())
+			auditOperation(context.TODO(), client, mytypes.AuditDropCollection, item.Database, item.Collection, nil, nil, nil, item.Documents, err)
+			if err != nil {
+				return fmt.Errorf("[-] Error deleting target: %v", err)
+			}
+		}
+		fmt.Println("[+] Deleted target successfully")
+		return nil
+	})
+}
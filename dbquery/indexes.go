@@ -0,0 +1,424 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"healerdb/config"
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Indexes                  ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// the indexes declared in the config file (or the embedded one), read on first use
+var (
+	indexSpecsOnce sync.Once
+	indexSpecs     []mytypes.IndexSpec
+	indexSpecsErr  error
+)
+
+// collections whose declared indexes were already created by this process, by database.collection
+var ensuredIndexes sync.Map
+
+// function IndexSpecs to get the indexes declared in the config file, they are the only source of the indexes the code relies on, returns the specs and an error if no config could be read
+func IndexSpecs() ([]mytypes.IndexSpec, error) {
+	indexSpecsOnce.Do(func() {
+		indexSpecs, indexSpecsErr = config.GetIndexSpecs()
+		if indexSpecsErr != nil {
+			indexSpecsErr = fmt.Errorf("[-] Error reading indexes config: %v", indexSpecsErr)
+		}
+	})
+	return indexSpecs, indexSpecsErr
+}
+
+// function specApplies to check whether an index spec applies to a collection, "*" or no collection means every collection of a target based database but the marker one
+func specApplies(spec mytypes.IndexSpec, database string, collection string) bool {
+	if spec.Database != database {
+		return false
+	}
+	if spec.Collection != "" && spec.Collection != "*" {
+		return spec.Collection == collection
+	}
+	return collection != "exists" && myutils.ContainsString(TargetDatabases(), database)
+}
+
+// function ensureIndexes to create the indexes declared for a collection once per process, existing ones are left as they are (SyncIndexes fixes drift), returns an error
+func ensureIndexes(client *mongo.Client, database string, collection string) error {
+	if _, done := ensuredIndexes.Load(database + "." + collection); done {
+		return nil
+	}
+	// without the specs the upserts relying on unique indexes wouldn't be race free, so this is an error
+	specs, err := IndexSpecs()
+	if err != nil {
+		return err
+	}
+	models := []mongo.IndexModel{}
+	for _, spec := range specs {
+		if !specApplies(spec, database, collection) {
+			continue
+		}
+		model, err := indexModel(spec)
+		if err != nil {
+			return fmt.Errorf("[-] Error creating indexes of %s.%s: %v", database, collection, err)
+		}
+		models = append(models, model)
+	}
+	if len(models) > 0 {
+		_, err := client.Database(database).Collection(collection).Indexes().CreateMany(context.TODO(), models)
+		if err != nil {
+			return fmt.Errorf("[-] Error creating indexes of %s.%s (a conflicting older index is replaced by 'healerdb indexes -drop'): %v", database, collection, err)
+		}
+	}
+	ensuredIndexes.Store(database+"."+collection, true)
+
+	return nil
+}
+
+// existingIndex is an index as listed by the server
+type existingIndex struct {
+	Name               string `bson:"name"`
+	Key                bson.D `bson:"key"`
+	Unique             bool   `bson:"unique"`
+	Sparse             bool   `bson:"sparse"`
+	ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	Partial            bson.D `bson:"partialFilterExpression"`
+	Weights            bson.D `bson:"weights"`
+	Collation          *struct {
+		Locale   string `bson:"locale"`
+		Strength int    `bson:"strength"`
+	} `bson:"collation"`
+}
+
+// function indexKeys to parse the keys of an index spec, "field" is ascending and "field:-1", "field:text", "field:hashed" or "field:2dsphere" give the direction or type, returns the ordered keys and an error
+func indexKeys(spec mytypes.IndexSpec) (bson.D, error) {
+	if len(spec.Keys) == 0 {
+		return nil, fmt.Errorf("index on %s.%s has no keys", spec.Database, spec.Collection)
+	}
+	keys := bson.D{}
+	for _, key := range spec.Keys {
+		field, kind, _ := strings.Cut(strings.TrimSpace(key), ":")
+		if field == "" {
+			return nil, fmt.Errorf("invalid index key %q", key)
+		}
+		switch kind {
+		case "", "1":
+			keys = append(keys, bson.E{Key: field, Value: int32(1)})
+		case "-1":
+			keys = append(keys, bson.E{Key: field, Value: int32(-1)})
+		case "text", "hashed", "2dsphere":
+			keys = append(keys, bson.E{Key: field, Value: kind})
+		default:
+			return nil, fmt.Errorf("invalid index key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// function IndexName to get the name of an index spec, the given name or the one mongodb generates from the keys (email_1, target_1_host_1, title_text), returns the name
+func IndexName(spec mytypes.IndexSpec) string {
+	if spec.Name != "" {
+		return spec.Name
+	}
+	keys, err := indexKeys(spec)
+	if err != nil {
+		return ""
+	}
+	parts := []string{}
+	for _, key := range keys {
+		parts = append(parts, key.Key, keyValue(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// function keyValue to format the direction or type of an index key, the server may list numbers as int32, int64 or double
+func keyValue(value interface{}) string {
+	switch v := value.(type) {
+	case int32:
+		return strconv.Itoa(int(v))
+	case int64:
+		return strconv.Itoa(int(v))
+	case float64:
+		return strconv.Itoa(int(v))
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// function indexModel to build the index model of a spec, returns the model and an error
+func indexModel(spec mytypes.IndexSpec) (mongo.IndexModel, error) {
+	keys, err := indexKeys(spec)
+	if err != nil {
+		return mongo.IndexModel{}, err
+	}
+	opts := options.Index().SetName(IndexName(spec))
+	if spec.Unique {
+		opts.SetUnique(true)
+	}
+	if spec.Sparse {
+		opts.SetSparse(true)
+	}
+	if spec.TTLSeconds != nil {
+		opts.SetExpireAfterSeconds(*spec.TTLSeconds)
+	}
+	if spec.Partial != "" {
+		partial := bson.D{}
+		err = bson.UnmarshalExtJSON([]byte(spec.Partial), false, &partial)
+		if err != nil {
+			return mongo.IndexModel{}, fmt.Errorf("invalid partial filter of index %s: %v", IndexName(spec), err)
+		}
+		opts.SetPartialFilterExpression(partial)
+	}
+	if spec.Collation != nil {
+		opts.SetCollation(&options.Collation{Locale: spec.Collation.Locale, Strength: spec.Collation.Strength})
+	}
+	return mongo.IndexModel{Keys: keys, Options: opts}, nil
+}
+
+// function indexDrift to compare a declared index with the existing one of the same name, returns what differs or an empty string when they match
+func indexDrift(spec mytypes.IndexSpec, index existingIndex) string {
+	diffs := []string{}
+
+	keys, _ := indexKeys(spec)
+	want, got := []string{}, []string{}
+	text := []string{}
+	for _, key := range keys {
+		if key.Value == "text" {
+			text = append(text, key.Key)
+			continue
+		}
+		want = append(want, key.Key+":"+keyValue(key.Value))
+	}
+	for _, key := range index.Key {
+		// text fields are listed as _fts/_ftsx with the fields in the weights
+		if key.Key == "_fts" || key.Key == "_ftsx" {
+			continue
+		}
+		got = append(got, key.Key+":"+keyValue(key.Value))
+	}
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		diffs = append(diffs, fmt.Sprintf("keys %v != %v", got, want))
+	}
+	weights := []string{}
+	for _, weight := range index.Weights {
+		weights = append(weights, weight.Key)
+	}
+	sort.Strings(text)
+	sort.Strings(weights)
+	if strings.Join(text, ",") != strings.Join(weights, ",") {
+		diffs = append(diffs, fmt.Sprintf("text fields %v != %v", weights, text))
+	}
+
+	if spec.Unique != index.Unique {
+		diffs = append(diffs, fmt.Sprintf("unique %v != %v", index.Unique, spec.Unique))
+	}
+	if spec.Sparse != index.Sparse {
+		diffs = append(diffs, fmt.Sprintf("sparse %v != %v", index.Sparse, spec.Sparse))
+	}
+
+	switch {
+	case spec.TTLSeconds == nil && index.ExpireAfterSeconds != nil:
+		diffs = append(diffs, fmt.Sprintf("ttl %ds != none", *index.ExpireAfterSeconds))
+	case spec.TTLSeconds != nil && index.ExpireAfterSeconds == nil:
+		diffs = append(diffs, fmt.Sprintf("ttl none != %ds", *spec.TTLSeconds))
+	case spec.TTLSeconds != nil && int64(*spec.TTLSeconds) != *index.ExpireAfterSeconds:
+		diffs = append(diffs, fmt.Sprintf("ttl %ds != %ds", *index.ExpireAfterSeconds, *spec.TTLSeconds))
+	}
+
+	gotpartial, wantpartial := "", ""
+	if index.Partial != nil {
+		raw, _ := bson.MarshalExtJSON(index.Partial, false, false)
+		gotpartial = string(raw)
+	}
+	if spec.Partial != "" {
+		partial := bson.D{}
+		if bson.UnmarshalExtJSON([]byte(spec.Partial), false, &partial) == nil {
+			raw, _ := bson.MarshalExtJSON(partial, false, false)
+			wantpartial = string(raw)
+		}
+	}
+	if gotpartial != wantpartial {
+		diffs = append(diffs, fmt.Sprintf("partial filter %s != %s", gotpartial, wantpartial))
+	}
+
+	gotlocale, wantlocale := "", ""
+	if index.Collation != nil {
+		gotlocale = index.Collation.Locale
+	}
+	if spec.Collation != nil {
+		wantlocale = spec.Collation.Locale
+	}
+	// the server fills in the default strength, it is only compared when declared
+	if gotlocale != wantlocale || (spec.Collation != nil && spec.Collation.Strength != 0 && index.Collation != nil && spec.Collation.Strength != index.Collation.Strength) {
+		diffs = append(diffs, "collation differs")
+	}
+
+	return strings.Join(diffs, ", ")
+}
+
+// function listIndexes to get the existing indexes of a collection by name, the _id index is left out, returns the indexes and an error
+func listIndexes(client *mongo.Client, database string, collection string) (map[string]existingIndex, error) {
+	indexes := map[string]existingIndex{}
+	cursor, err := client.Database(database).Collection(collection).Indexes().List(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var index existingIndex
+		err = cursor.Decode(&index)
+		if err != nil {
+			return nil, err
+		}
+		if index.Name == "_id_" {
+			continue
+		}
+		indexes[index.Name] = index
+	}
+	return indexes, cursor.Err()
+}
+
+// function specCollections to get the collections an index spec applies to, "*" or no collection means every target collection of the database, returns the collections and an error
+func specCollections(client *mongo.Client, spec mytypes.IndexSpec) ([]string, error) {
+	if spec.Collection != "" && spec.Collection != "*" {
+		return []string{spec.Collection}, nil
+	}
+	if !myutils.ContainsString(TargetDatabases(), spec.Database) {
+		return nil, fmt.Errorf("index %s on every collection of %s, which isn't target based", IndexName(spec), spec.Database)
+	}
+	collections, err := ListTargets(client, spec.Database)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Database == LogDatabase {
+		// the global audit log has the same indexes as the targets' ones
+		collections = append(collections, GlobalLogCollection)
+	}
+	return collections, nil
+}
+
+// function SyncIndexes to bring the indexes of the collections named by the specs in line with them, missing indexes are created and extra or mismatched ones are reported, with drop the extra indexes are dropped and the mismatched ones recreated, collections without a declared index are left alone, returns the changes and an error
+func SyncIndexes(client *mongo.Client, specs []mytypes.IndexSpec, drop bool) ([]mytypes.IndexChange, error) {
+	// group the specs by the collections they apply to
+	type target struct{ database, collection string }
+	order := []target{}
+	declared := map[target][]mytypes.IndexSpec{}
+	for _, spec := range specs {
+		if spec.Database == "" {
+			return nil, fmt.Errorf("[-] Error syncing indexes: index %s has no database", IndexName(spec))
+		}
+		if _, err := indexModel(spec); err != nil {
+			return nil, fmt.Errorf("[-] Error syncing indexes: %v", err)
+		}
+		collections, err := specCollections(client, spec)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error syncing indexes: %v", err)
+		}
+		for _, collection := range collections {
+			t := target{spec.Database, collection}
+			if _, ok := declared[t]; !ok {
+				order = append(order, t)
+			}
+			declared[t] = append(declared[t], spec)
+		}
+	}
+
+	changes := []mytypes.IndexChange{}
+	for _, t := range order {
+		existing, err := listIndexes(client, t.database, t.collection)
+		if err != nil {
+			return changes, fmt.Errorf("[-] Error listing indexes of %s.%s: %v", t.database, t.collection, err)
+		}
+		coll := client.Database(t.database).Collection(t.collection)
+		change := func(kind string, name string, detail string) {
+			changes = append(changes, mytypes.IndexChange{Kind: kind, Database: t.database, Collection: t.collection, Name: name, Detail: detail})
+		}
+		audit := func(operation string, name string, detail string, err error) {
+			after := bson.M{"index": name}
+			if detail != "" {
+				after["detail"] = detail
+			}
+			count := int64(1)
+			if err != nil {
+				count = 0
+			}
+			auditOperation(context.TODO(), client, operation, t.database, t.collection, nil, nil, after, count, err)
+		}
+		// whatever is dropped below is created again on the next use
+		ensuredIndexes.Delete(t.database + "." + t.collection)
+
+		names := map[string]bool{}
+		for _, spec := range declared[t] {
+			name := IndexName(spec)
+			names[name] = true
+			model, _ := indexModel(spec)
+			index, ok := existing[name]
+			if ok {
+				drift := indexDrift(spec, index)
+				if drift == "" {
+					continue
+				}
+				if !drop {
+					change(mytypes.IndexMismatched, name, drift)
+					continue
+				}
+				_, err = coll.Indexes().DropOne(context.TODO(), name)
+				audit(mytypes.AuditDropIndex, name, drift, err)
+				if err != nil {
+					return changes, fmt.Errorf("[-] Error dropping index %s of %s.%s: %v", name, t.database, t.collection, err)
+				}
+				_, err = coll.Indexes().CreateOne(context.TODO(), model)
+				audit(mytypes.AuditCreateIndex, name, drift, err)
+				if err != nil {
+					return changes, fmt.Errorf("[-] Error recreating index %s of %s.%s: %v", name, t.database, t.collection, err)
+				}
+				change(mytypes.IndexRecreated, name, drift)
+				continue
+			}
+			_, err = coll.Indexes().CreateOne(context.TODO(), model)
+			audit(mytypes.AuditCreateIndex, name, "", err)
+			if err != nil {
+				return changes, fmt.Errorf("[-] Error creating index %s of %s.%s: %v", name, t.database, t.collection, err)
+			}
+			change(mytypes.IndexCreated, name, "")
+		}
+
+		extra := []string{}
+		for name := range existing {
+			if !names[name] {
+				extra = append(extra, name)
+			}
+		}
+		sort.Strings(extra)
+		for _, name := range extra {
+			if !drop {
+				change(mytypes.IndexExtra, name, "")
+				continue
+			}
+			_, err = coll.Indexes().DropOne(context.TODO(), name)
+			audit(mytypes.AuditDropIndex, name, "", err)
+			if err != nil {
+				return changes, fmt.Errorf("[-] Error dropping index %s of %s.%s: %v", name, t.database, t.collection, err)
+			}
+			change(mytypes.IndexDropped, name, "")
+		}
+	}
+	fmt.Println("[+] Synced indexes successfully")
+
+	return changes, nil
+}
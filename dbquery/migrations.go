@@ -0,0 +1,455 @@
+package dbquery
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Migrations               ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// the database and collections of the applied migrations and of the migration lock
+var (
+	MigrationDatabase    = "schema"
+	MigrationsCollection = "migrations"
+	MigrationLock        = "migrations_lock"
+)
+
+// how long the migration lock is held without a renewal, a heartbeat renews it three times per lease while the migrations run so a crashed process only blocks the others for this long
+var MigrationLockLease = 10 * time.Minute
+
+// Migration is a numbered change of the stored documents, per target migrations run once for every target of their databases (all the target based ones if none are given) and get the target, the others get an empty one, Up and Down have to use their context for the database calls, it is cancelled when the migration lock is lost, and return the number of documents they changed, Down is optional but a migration without it can't be reverted
+type Migration struct {
+	Version     int
+	Name        string
+	Description string
+	PerTarget   bool
+	Databases   []string
+	Up          func(ctx context.Context, client *mongo.Client, target string) (int64, error)
+	Down        func(ctx context.Context, client *mongo.Client, target string) (int64, error)
+}
+
+// the registered migrations by version
+var migrations = map[int]Migration{}
+
+// function RegisterMigration to register a migration, it is meant to be called from an init function of the file that defines the migration, returns an error if the migration is incomplete or its version is taken
+func RegisterMigration(migration Migration) error {
+	if migration.Version <= 0 || migration.Name == "" || migration.Up == nil {
+		return fmt.Errorf("[-] Error registering migration: a version above 0, a name and an up function are required")
+	}
+	if existing, ok := migrations[migration.Version]; ok {
+		return fmt.Errorf("[-] Error registering migration %s: version %d is taken by %s", migration.Name, migration.Version, existing.Name)
+	}
+	migrations[migration.Version] = migration
+
+	return nil
+}
+
+// function registeredMigrations to get the registered migrations ordered by version
+func registeredMigrations() []Migration {
+	list := []Migration{}
+	for _, migration := range migrations {
+		list = append(list, migration)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list
+}
+
+// function MigrationChecksum to get the checksum of a migration, code can't be hashed so it covers the version, name, description and scope, the description has to change with the behaviour of a migration for the drift to show, returns the checksum
+func MigrationChecksum(migration Migration) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%s\n%t\n%s", migration.Version, migration.Name, migration.Description, migration.PerTarget, strings.Join(migration.Databases, ","))))
+	return hex.EncodeToString(sum[:])
+}
+
+// function migrationID to get the id of the record of a migration for a target
+func migrationID(version int, target string) string {
+	if target == "" {
+		return strconv.Itoa(version)
+	}
+	return strconv.Itoa(version) + ":" + target
+}
+
+// function migrationTargets to get the targets a per target migration runs on, the collections of its databases, only the given target if one is given, returns the sorted targets and an error
+func migrationTargets(client *mongo.Client, migration Migration, target string) ([]string, error) {
+	databases := migration.Databases
+	if len(databases) == 0 {
+		databases = TargetDatabases()
+	}
+	targets := []string{}
+	for _, database := range databases {
+		names, err := ListTargets(client, database)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if target != "" && name != target {
+				continue
+			}
+			if !myutils.ContainsString(targets, name) {
+				targets = append(targets, name)
+			}
+		}
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// function appliedMigrations to get the applied migration records by id, returns the records and an error
+func appliedMigrations(client *mongo.Client) (map[string]mytypes.MigrationRecord, error) {
+	records := map[string]mytypes.MigrationRecord{}
+	cursor, err := client.Database(MigrationDatabase).Collection(MigrationsCollection).Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var record mytypes.MigrationRecord
+		err = cursor.Decode(&record)
+		if err != nil {
+			return nil, err
+		}
+		records[record.ID] = record
+	}
+	return records, cursor.Err()
+}
+
+// function migrationOwner to get a name for this process in the migration lock
+func migrationOwner() string {
+	host, _ := os.Hostname()
+	random := make([]byte, 4)
+	rand.Read(random)
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(random))
+}
+
+// function lockMigrations to take the migration lock, the lock is a single document which a process can only take once the lease of the previous holder ran out, returns an error if another process holds it
+func lockMigrations(client *mongo.Client, owner string) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": "lock", "$or": bson.A{bson.M{"owner": owner}, bson.M{"locked_until": bson.M{"$lte": now}}}}
+	update := bson.M{"$set": bson.M{"owner": owner, "locked_at": now, "locked_until": now.Add(MigrationLockLease)}}
+	_, err := client.Database(MigrationDatabase).Collection(MigrationLock).UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// the lock document exists and didn't match, so it is held by someone else
+		held := bson.M{}
+		client.Database(MigrationDatabase).Collection(MigrationLock).FindOne(context.TODO(), bson.M{"_id": "lock"}).Decode(&held)
+		return fmt.Errorf("migrations are locked by %v until %v", held["owner"], held["locked_until"])
+	}
+	return err
+}
+
+// function unlockMigrations to release the migration lock if it is still held by the owner
+func unlockMigrations(client *mongo.Client, owner string) {
+	client.Database(MigrationDatabase).Collection(MigrationLock).DeleteOne(context.TODO(), bson.M{"_id": "lock", "owner": owner})
+}
+
+// function withMigrationLock to run the steps while holding the migration lock, one heartbeat renews the lease three times per lease for the whole run, if a renewal fails the context of the steps is cancelled so they stop before another process can take the lock and run them too, returns the error of the steps or the lost lock
+func withMigrationLock(client *mongo.Client, run func(ctx context.Context) error) error {
+	owner := migrationOwner()
+	err := lockMigrations(client, owner)
+	if err != nil {
+		return err
+	}
+	defer unlockMigrations(client, owner)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	var lost error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(MigrationLockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := lockMigrations(client, owner)
+				if err != nil {
+					lost = err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = run(ctx)
+	close(done)
+	wg.Wait()
+	if lost != nil {
+		return fmt.Errorf("lost the migration lock: %v", lost)
+	}
+	return err
+}
+
+// function checkMigrationDrift to refuse to migrate when applied migrations were changed or aren't registered anymore, returns an error listing them
+func checkMigrationDrift(applied map[string]mytypes.MigrationRecord) error {
+	drift := []string{}
+	for _, record := range applied {
+		migration, ok := migrations[record.Version]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("%s is applied but not registered", record.ID))
+		} else if record.Checksum != MigrationChecksum(migration) {
+			drift = append(drift, fmt.Sprintf("%s changed after it was applied", record.ID))
+		}
+	}
+	if len(drift) > 0 {
+		sort.Strings(drift)
+		return fmt.Errorf("%s", strings.Join(drift, ", "))
+	}
+	return nil
+}
+
+// function auditMigrationStep to record a migration step that ran or failed in the audit log, the documents it changed are only counted
+func auditMigrationStep(client *mongo.Client, step mytypes.MigrationStep, err error) {
+	after := bson.M{"_id": migrationID(step.Version, step.Target), "version": step.Version, "name": step.Name, "target": step.Target, "direction": step.Direction}
+	auditOperation(context.TODO(), client, mytypes.AuditMigrate, MigrationDatabase, MigrationsCollection, nil, nil, after, step.Documents, err)
+}
+
+// function MigrateUp to apply the pending migrations up to the given version (all of them with 0), per target migrations run on every target or only on the given one, with dryrun nothing runs and the steps that would run are returned, the steps run one at a time under the migration lock and stop at the first error, returns the steps and an error
+func MigrateUp(client *mongo.Client, target string, to int, dryrun bool) ([]mytypes.MigrationStep, error) {
+	steps := []mytypes.MigrationStep{}
+	plan := func() ([]mytypes.MigrationStep, error) {
+		applied, err := appliedMigrations(client)
+		if err != nil {
+			return nil, err
+		}
+		err = checkMigrationDrift(applied)
+		if err != nil {
+			return nil, err
+		}
+		pending := []mytypes.MigrationStep{}
+		for _, migration := range registeredMigrations() {
+			if to > 0 && migration.Version > to {
+				break
+			}
+			targets := []string{""}
+			if migration.PerTarget {
+				targets, err = migrationTargets(client, migration, target)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, t := range targets {
+				if _, ok := applied[migrationID(migration.Version, t)]; !ok {
+					pending = append(pending, mytypes.MigrationStep{Version: migration.Version, Name: migration.Name, Target: t, Direction: mytypes.MigrationUp})
+				}
+			}
+		}
+		return pending, nil
+	}
+
+	if dryrun {
+		pending, err := plan()
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error migrating up: %v", err)
+		}
+		return pending, nil
+	}
+
+	err := withMigrationLock(client, func(ctx context.Context) error {
+		// plan under the lock so two processes never run the same step
+		pending, err := plan()
+		if err != nil {
+			return err
+		}
+		for _, step := range pending {
+			// don't start a step once the lock is lost
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			migration := migrations[step.Version]
+			start := time.Now()
+			step.Documents, err = migration.Up(ctx, client, step.Target)
+			if err != nil {
+				auditMigrationStep(client, step, err)
+				return fmt.Errorf("migration %s failed: %v", migrationID(step.Version, step.Target), err)
+			}
+			record := mytypes.MigrationRecord{
+				ID:         migrationID(step.Version, step.Target),
+				Version:    step.Version,
+				Name:       step.Name,
+				Target:     step.Target,
+				Checksum:   MigrationChecksum(migration),
+				Documents:  step.Documents,
+				DurationMs: time.Since(start).Milliseconds(),
+				AppliedBy:  AuditActor,
+				AppliedAt:  time.Now().UTC(),
+			}
+			_, err = client.Database(MigrationDatabase).Collection(MigrationsCollection).InsertOne(ctx, record)
+			if err != nil {
+				err = fmt.Errorf("migration %s ran but couldn't be recorded: %v", record.ID, err)
+				auditMigrationStep(client, step, err)
+				return err
+			}
+			auditMigrationStep(client, step, nil)
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	if err != nil {
+		return steps, fmt.Errorf("[-] Error migrating up: %v", err)
+	}
+	fmt.Println("[+] Migrated up successfully")
+
+	return steps, nil
+}
+
+// function MigrateDown to revert the last applied migrations, the given number of versions (1 if below 1), of every target or only of the given one, with dryrun nothing runs and the steps that would run are returned, returns the steps and an error
+func MigrateDown(client *mongo.Client, target string, versions int, dryrun bool) ([]mytypes.MigrationStep, error) {
+	if versions < 1 {
+		versions = 1
+	}
+	steps := []mytypes.MigrationStep{}
+	plan := func() ([]mytypes.MigrationStep, error) {
+		applied, err := appliedMigrations(client)
+		if err != nil {
+			return nil, err
+		}
+		err = checkMigrationDrift(applied)
+		if err != nil {
+			return nil, err
+		}
+		records := []mytypes.MigrationRecord{}
+		for _, record := range applied {
+			if target == "" || record.Target == target {
+				records = append(records, record)
+			}
+		}
+		// newest version first, the targets of a version in order
+		sort.Slice(records, func(i, j int) bool {
+			if records[i].Version != records[j].Version {
+				return records[i].Version > records[j].Version
+			}
+			return records[i].Target < records[j].Target
+		})
+		pending := []mytypes.MigrationStep{}
+		reverted := []int{}
+		for _, record := range records {
+			if len(reverted) == 0 || reverted[len(reverted)-1] != record.Version {
+				if len(reverted) == versions {
+					break
+				}
+				reverted = append(reverted, record.Version)
+			}
+			if migrations[record.Version].Down == nil {
+				return nil, fmt.Errorf("migration %d %s can't be reverted", record.Version, record.Name)
+			}
+			pending = append(pending, mytypes.MigrationStep{Version: record.Version, Name: record.Name, Target: record.Target, Direction: mytypes.MigrationDown})
+		}
+		return pending, nil
+	}
+
+	if dryrun {
+		pending, err := plan()
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error migrating down: %v", err)
+		}
+		return pending, nil
+	}
+
+	err := withMigrationLock(client, func(ctx context.Context) error {
+		pending, err := plan()
+		if err != nil {
+			return err
+		}
+		for _, step := range pending {
+			// don't start a step once the lock is lost
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			step.Documents, err = migrations[step.Version].Down(ctx, client, step.Target)
+			if err != nil {
+				auditMigrationStep(client, step, err)
+				return fmt.Errorf("reverting migration %s failed: %v", migrationID(step.Version, step.Target), err)
+			}
+			_, err = client.Database(MigrationDatabase).Collection(MigrationsCollection).DeleteOne(ctx, bson.M{"_id": migrationID(step.Version, step.Target)})
+			if err != nil {
+				err = fmt.Errorf("migration %s was reverted but is still recorded: %v", migrationID(step.Version, step.Target), err)
+				auditMigrationStep(client, step, err)
+				return err
+			}
+			auditMigrationStep(client, step, nil)
+			steps = append(steps, step)
+		}
+		return nil
+	})
+	if err != nil {
+		return steps, fmt.Errorf("[-] Error migrating down: %v", err)
+	}
+	fmt.Println("[+] Migrated down successfully")
+
+	return steps, nil
+}
+
+// function GetMigrationStatus to get the state of every registered migration, for every target of the per target ones or only for the given target, applied migrations that aren't registered anymore are listed as unknown, returns the states ordered by version and target and an error
+func GetMigrationStatus(client *mongo.Client, target string) ([]mytypes.MigrationStatus, error) {
+	applied, err := appliedMigrations(client)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting migration status: %v", err)
+	}
+
+	statuses := []mytypes.MigrationStatus{}
+	seen := map[string]bool{}
+	for _, migration := range registeredMigrations() {
+		targets := []string{""}
+		if migration.PerTarget {
+			targets, err = migrationTargets(client, migration, target)
+			if err != nil {
+				return nil, fmt.Errorf("[-] Error getting migration status: %v", err)
+			}
+		}
+		for _, t := range targets {
+			status := mytypes.MigrationStatus{Version: migration.Version, Name: migration.Name, Target: t}
+			id := migrationID(migration.Version, t)
+			if record, ok := applied[id]; ok {
+				appliedat := record.AppliedAt
+				status.Applied = true
+				status.AppliedAt = &appliedat
+				status.Changed = record.Checksum != MigrationChecksum(migration)
+			}
+			seen[id] = true
+			statuses = append(statuses, status)
+		}
+	}
+	for id, record := range applied {
+		if seen[id] || (target != "" && record.Target != target) {
+			continue
+		}
+		// applied on a target whose collections are gone, or not registered anymore
+		_, registered := migrations[record.Version]
+		appliedat := record.AppliedAt
+		statuses = append(statuses, mytypes.MigrationStatus{Version: record.Version, Name: record.Name, Target: record.Target, Applied: true, AppliedAt: &appliedat, Unknown: !registered})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Version != statuses[j].Version {
+			return statuses[i].Version < statuses[j].Version
+		}
+		return statuses[i].Target < statuses[j].Target
+	})
+
+	return statuses, nil
+}
@@ -0,0 +1,276 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Modules API              ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the modules_api database (not target based) and its collection
+var (
+	ModulesDatabase = "modules_api"
+	ModulesRegistry = "modules"
+)
+
+// function majorVersion to get the major number of a semantic version (e.g. v2.1.0 -> 2), returns -1 if it can't be parsed
+func majorVersion(version string) int {
+	major := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 2)[0]
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// function parseModuleSchemas to check that the schemas of a module are valid json schemas, an empty schema accepts everything
+func parseModuleSchemas(module *mytypes.Module) (map[string]interface{}, map[string]interface{}, error) {
+	if module.InputSchema == "" {
+		module.InputSchema = "{}"
+	}
+	if module.OutputSchema == "" {
+		module.OutputSchema = "{}"
+	}
+	input, err := myutils.ParseJSONSchema(module.InputSchema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("input schema: %v", err)
+	}
+	output, err := myutils.ParseJSONSchema(module.OutputSchema)
+	if err != nil {
+		return nil, nil, fmt.Errorf("output schema: %v", err)
+	}
+	return input, output, nil
+}
+
+// function RegisterModule to add a new module to the registry, the module name is unique, returns an error
+func RegisterModule(client *mongo.Client, module mytypes.Module) error {
+	if module.Name == "" || module.Version == "" {
+		return fmt.Errorf("[-] Error registering module: name and version are required")
+	}
+	_, _, err := parseModuleSchemas(&module)
+	if err != nil {
+		return fmt.Errorf("[-] Error registering module: %v", err)
+	}
+	err = ensureIndexes(client, ModulesDatabase, ModulesRegistry)
+	if err != nil {
+		return fmt.Errorf("[-] Error registering module: %v", err)
+	}
+
+	now := time.Now().UTC()
+	if module.Reads == nil {
+		module.Reads = []string{}
+	}
+	if module.Writes == nil {
+		module.Writes = []string{}
+	}
+	module.History = []mytypes.ModuleRelease{{
+		Version:      module.Version,
+		InputSchema:  module.InputSchema,
+		OutputSchema: module.OutputSchema,
+		RegisteredAt: now,
+	}}
+	module.CreatedAt = now
+	module.UpdatedAt = now
+	result, err := client.Database(ModulesDatabase).Collection(ModulesRegistry).InsertOne(context.TODO(), module)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("[-] Error registering module: %s is already registered, use UpdateModule", module.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error registering module: %v", err)
+	}
+	after := auditDocument(module)
+	after["_id"] = result.InsertedID
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, ModulesDatabase, ModulesRegistry, nil, nil, after, 1, nil)
+	fmt.Println("[+] Registered module successfully")
+
+	return nil
+}
+
+// function GetModule to get the registered module with the given name, returns a pointer to the module and an error
+func GetModule(client *mongo.Client, name string) (*mytypes.Module, error) {
+	module := &mytypes.Module{}
+	err := client.Database(ModulesDatabase).Collection(ModulesRegistry).FindOne(context.TODO(), bson.M{"name": name}).Decode(module)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting module: %v", err)
+	}
+
+	return module, nil
+}
+
+// function ListModules to list the registered modules, optionally only the ones reading or writing the given database (empty for all), returns a slice of modules and an error
+func ListModules(client *mongo.Client, database string) ([]mytypes.Module, error) {
+	filter := bson.M{}
+	if database != "" {
+		filter["$or"] = bson.A{bson.M{"reads": database}, bson.M{"writes": database}}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}}).SetProjection(bson.M{"history": 0})
+	cursor, err := client.Database(ModulesDatabase).Collection(ModulesRegistry).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error listing modules: %v", err)
+	}
+	modules := []mytypes.Module{}
+	err = cursor.All(context.TODO(), &modules)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding modules: %v", err)
+	}
+
+	return modules, nil
+}
+
+// function CheckModuleCompatibility to compare the schemas of a new module declaration with the registered ones, returns the breaking changes (empty if compatible) and an error
+func CheckModuleCompatibility(client *mongo.Client, module mytypes.Module) ([]string, error) {
+	current, err := GetModule(client, module.Name)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error checking compatibility: %v", err)
+	}
+	oldinput, oldoutput, err := parseModuleSchemas(current)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error checking compatibility: registered %v", err)
+	}
+	newinput, newoutput, err := parseModuleSchemas(&module)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error checking compatibility: %v", err)
+	}
+
+	issues := []string{}
+	for _, issue := range myutils.CompareSchemas(oldinput, newinput, true) {
+		issues = append(issues, "input "+issue)
+	}
+	for _, issue := range myutils.CompareSchemas(oldoutput, newoutput, false) {
+		issues = append(issues, "output "+issue)
+	}
+
+	return issues, nil
+}
+
+// function UpdateModule to update a registered module, a schema change needs a new version and breaking schema changes need a new major version (or force), returns the breaking changes found and an error
+func UpdateModule(client *mongo.Client, module mytypes.Module, force bool) ([]string, error) {
+	current, err := GetModule(client, module.Name)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating module: %v", err)
+	}
+	_, _, err = parseModuleSchemas(&module)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating module: %v", err)
+	}
+	if module.Reads == nil {
+		module.Reads = []string{}
+	}
+	if module.Writes == nil {
+		module.Writes = []string{}
+	}
+	issues, err := CheckModuleCompatibility(client, module)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating module: %v", err)
+	}
+	schemachanged := current.InputSchema != module.InputSchema || current.OutputSchema != module.OutputSchema
+	if schemachanged && module.Version == current.Version {
+		return issues, fmt.Errorf("[-] Error updating module: schemas changed but version is still %s", current.Version)
+	}
+	if len(issues) > 0 && !force && majorVersion(module.Version) <= majorVersion(current.Version) {
+		return issues, fmt.Errorf("[-] Error updating module: %d breaking schema changes need a new major version", len(issues))
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{
+		"version":       module.Version,
+		"description":   module.Description,
+		"input_schema":  module.InputSchema,
+		"output_schema": module.OutputSchema,
+		"reads":         module.Reads,
+		"writes":        module.Writes,
+		"config":        module.Config,
+		"updated_at":    now,
+	}
+	update := bson.M{"$set": set}
+	if module.Version != current.Version {
+		update["$push"] = bson.M{"history": mytypes.ModuleRelease{
+			Version:      module.Version,
+			InputSchema:  module.InputSchema,
+			OutputSchema: module.OutputSchema,
+			RegisteredAt: now,
+		}}
+	}
+	// the registered version is part of the filter so two concurrent updates can't both pass the compatibility check
+	filter := bson.M{"name": module.Name, "version": current.Version, "output_schema": current.OutputSchema, "input_schema": current.InputSchema}
+	before, err := updateOneAudited(context.TODO(), client, ModulesDatabase, ModulesRegistry, filter, set, update)
+	if err != nil {
+		return issues, fmt.Errorf("[-] Error updating module: %v", err)
+	}
+	if before == nil {
+		return issues, fmt.Errorf("[-] Error updating module: module was modified concurrently")
+	}
+	fmt.Println("[+] Updated module successfully")
+
+	return issues, nil
+}
+
+// function ValidateModuleOutput to validate a json document against the output schema of a registered module, returns the violations (empty if valid) and an error
+func ValidateModuleOutput(client *mongo.Client, name string, document string) ([]string, error) {
+	module, err := GetModule(client, name)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error validating module output: %v", err)
+	}
+	if module.OutputSchema == "" {
+		module.OutputSchema = "{}"
+	}
+	violations, err := myutils.ValidateJSON(module.OutputSchema, document)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error validating module output: %v", err)
+	}
+
+	return violations, nil
+}
+
+// function WriteModuleOutput to insert a json document produced by a module into healerdb, the module must declare the database in its writes and the document must be valid against its output schema, returns an error
+func WriteModuleOutput(client *mongo.Client, name string, database string, collection string, document string) error {
+	module, err := GetModule(client, name)
+	if err != nil {
+		return fmt.Errorf("[-] Error writing module output: %v", err)
+	}
+	if !myutils.ContainsString(module.Writes, database) {
+		return fmt.Errorf("[-] Error writing module output: %s doesn't declare writes to %s", name, database)
+	}
+	violations, err := ValidateModuleOutput(client, name, document)
+	if err != nil {
+		return fmt.Errorf("[-] Error writing module output: %v", err)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("[-] Error writing module output: invalid against the output schema: %s", strings.Join(violations, "; "))
+	}
+
+	return InsertDocument(client, database, collection, document)
+}
+
+// function DeleteModule to remove a module from the registry, returns an error
+func DeleteModule(client *mongo.Client, name string) error {
+	filter := bson.M{"name": name}
+	before := bson.M{}
+	err := client.Database(ModulesDatabase).Collection(ModulesRegistry).FindOneAndDelete(context.TODO(), filter, options.FindOneAndDelete().SetProjection(bson.M{"history": 0})).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("[-] Error deleting module: module doesn't exist")
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error deleting module: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditDelete, ModulesDatabase, ModulesRegistry, filter, before, nil, 1, nil)
+	fmt.Println("[+] Deleted module successfully")
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package dbquery
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Networks                 ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the network database, it is target based so the collection is the target name
+var NetworkDatabase = "network"
+
+// how many times a service update is tried again when a concurrent update adds or removes the same port
+var serviceRetries = 3
+
+// function ipKey to write an ip address as 16 bytes hex, ipv4 addresses are mapped into ipv6 so every key has the same length and the keys sort like the addresses
+func ipKey(ip net.IP) string {
+	return hex.EncodeToString(ip.To16())
+}
+
+// function ParseNetworkAddress to parse an ip address or a cidr (the host bits of a cidr are cleared), returns the asset with its type, canonical address, version and range and an error
+func ParseNetworkAddress(address string) (mytypes.NetworkAsset, error) {
+	asset := mytypes.NetworkAsset{}
+	address = strings.TrimSpace(address)
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		if err != nil {
+			return asset, fmt.Errorf("invalid cidr %q", address)
+		}
+		start := network.IP.To16()
+		end := make(net.IP, len(start))
+		copy(end, start)
+		offset := len(end) - len(network.Mask)
+		for i, b := range network.Mask {
+			end[offset+i] |= ^b
+		}
+		asset.Type = mytypes.NetworkCIDR
+		asset.Address = network.String()
+		asset.Start = ipKey(start)
+		asset.End = ipKey(end)
+		asset.Version = 6
+		if network.IP.To4() != nil {
+			asset.Version = 4
+		}
+		return asset, nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return asset, fmt.Errorf("invalid ip address %q", address)
+	}
+	asset.Type = mytypes.NetworkIP
+	asset.Address = ip.String()
+	asset.Start = ipKey(ip)
+	asset.End = asset.Start
+	asset.Version = 6
+	if ip.To4() != nil {
+		asset.Version = 4
+	}
+
+	return asset, nil
+}
+
+// function EnsureNetworkIndexes to create the declared indexes of a target's collection in the network database, returns an error
+func EnsureNetworkIndexes(client *mongo.Client, target string) error {
+	return ensureIndexes(client, NetworkDatabase, target)
+}
+
+// function AddNetworkAsset to add an ip address or a cidr to a target, adding an existing one only updates its last-seen time and the asn and organization when given, returns whether the asset was created and an error
+func AddNetworkAsset(client *mongo.Client, target string, address string, asn uint32, organization string) (bool, error) {
+	asset, err := ParseNetworkAddress(address)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error adding network asset: %v", err)
+	}
+	err = EnsureNetworkIndexes(client, target)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error adding network asset: %v", err)
+	}
+
+	now := time.Now().UTC()
+	set := bson.M{"last_seen": now}
+	if asn != 0 {
+		set["asn"] = asn
+	}
+	if organization = strings.TrimSpace(organization); organization != "" {
+		set["organization"] = organization
+	}
+	update := bson.M{
+		"$setOnInsert": bson.M{"target": target, "type": asset.Type, "address": asset.Address, "version": asset.Version, "start": asset.Start, "end": asset.End, "ports": bson.A{}, "first_seen": now},
+		"$set":         set,
+	}
+	coll := client.Database(NetworkDatabase).Collection(target)
+	result, err := coll.UpdateOne(context.TODO(), bson.M{"address": asset.Address}, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// a concurrent upsert inserted it first, update it
+		delete(update, "$setOnInsert")
+		_, err = coll.UpdateOne(context.TODO(), bson.M{"address": asset.Address}, update)
+		result = nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("[-] Error adding network asset: %v", err)
+	}
+	if result == nil || result.UpsertedCount == 0 {
+		return false, nil
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, NetworkDatabase, target, nil, nil, bson.M{"_id": result.UpsertedID, "address": asset.Address}, 1, nil)
+	fmt.Println("[+] Added network asset successfully")
+
+	return true, nil
+}
+
+// function normalizeService to check the port and protocol of a service, the protocol defaults to tcp, returns the service and an error
+func normalizeService(service mytypes.Service) (mytypes.Service, error) {
+	if service.Port < 1 || service.Port > 65535 {
+		return service, fmt.Errorf("invalid port %d", service.Port)
+	}
+	service.Protocol = strings.ToLower(strings.TrimSpace(service.Protocol))
+	if service.Protocol == "" {
+		service.Protocol = "tcp"
+	}
+	if service.Protocol != "tcp" && service.Protocol != "udp" && service.Protocol != "sctp" {
+		return service, fmt.Errorf("invalid protocol %q", service.Protocol)
+	}
+	service.Service = strings.ToLower(strings.TrimSpace(service.Service))
+	if service.LastSeen.IsZero() {
+		service.LastSeen = time.Now().UTC()
+	}
+	return service, nil
+}
+
+// function AddService to store an open port of an ip address of a target, the ip is added if needed and a port seen before is replaced by the latest observation, returns an error
+func AddService(client *mongo.Client, target string, ip string, service mytypes.Service) error {
+	asset, err := ParseNetworkAddress(ip)
+	if err == nil && asset.Type != mytypes.NetworkIP {
+		err = fmt.Errorf("%q is not an ip address", ip)
+	}
+	if err == nil {
+		service, err = normalizeService(service)
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error adding service: %v", err)
+	}
+	_, err = AddNetworkAsset(client, target, asset.Address, 0, "")
+	if err != nil {
+		return fmt.Errorf("[-] Error adding service: %v", err)
+	}
+
+	// either the port is there and gets replaced or it isn't and gets pushed, each update checks its condition atomically so a concurrent scan can only make both miss
+	coll := client.Database(NetworkDatabase).Collection(target)
+	port := bson.M{"port": service.Port, "protocol": service.Protocol}
+	for attempt := 0; attempt < serviceRetries; attempt++ {
+		result, err := coll.UpdateOne(context.TODO(), bson.M{"address": asset.Address, "ports": bson.M{"$elemMatch": port}}, bson.M{"$set": bson.M{"ports.$": service, "last_seen": service.LastSeen}})
+		if err != nil {
+			return fmt.Errorf("[-] Error adding service: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			result, err = coll.UpdateOne(context.TODO(), bson.M{"address": asset.Address, "ports": bson.M{"$not": bson.M{"$elemMatch": port}}}, bson.M{"$push": bson.M{"ports": service}, "$set": bson.M{"last_seen": service.LastSeen}})
+			if err != nil {
+				return fmt.Errorf("[-] Error adding service: %v", err)
+			}
+		}
+		if result.MatchedCount > 0 {
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, NetworkDatabase, target, bson.M{"address": asset.Address}, nil, bson.M{"port": service}, result.ModifiedCount, nil)
+			fmt.Println("[+] Added service successfully")
+			return nil
+		}
+	}
+
+	return fmt.Errorf("[-] Error adding service: %s %d/%s kept changing", asset.Address, service.Port, service.Protocol)
+}
+
+// function RemoveService to remove a port (closed since) of an ip address of a target, returns an error
+func RemoveService(client *mongo.Client, target string, ip string, port int, protocol string) error {
+	service, err := normalizeService(mytypes.Service{Port: port, Protocol: protocol})
+	if err != nil {
+		return fmt.Errorf("[-] Error removing service: %v", err)
+	}
+	asset, err := ParseNetworkAddress(ip)
+	if err != nil {
+		return fmt.Errorf("[-] Error removing service: %v", err)
+	}
+	update := bson.M{"$pull": bson.M{"ports": bson.M{"port": service.Port, "protocol": service.Protocol}}}
+	result, err := client.Database(NetworkDatabase).Collection(target).UpdateOne(context.TODO(), bson.M{"address": asset.Address}, update)
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, NetworkDatabase, target, bson.M{"address": asset.Address}, bson.M{"port": bson.M{"port": service.Port, "protocol": service.Protocol}}, nil, 0, err)
+	if err != nil {
+		return fmt.Errorf("[-] Error removing service: %v", err)
+	}
+	if result.ModifiedCount > 0 {
+		fmt.Println("[+] Removed service successfully")
+	}
+
+	return nil
+}
+
+// function IPSubdomains to get the subdomains of a target resolving to an ip address, from their dns records in the enum database, returns the subdomains and an error
+func IPSubdomains(client *mongo.Client, target string, ip string) ([]string, error) {
+	matches, err := FindSubdomainsByDNS(client, mytypes.DNSQuery{Targets: []string{target}, IP: ip})
+	if err != nil {
+		return nil, err
+	}
+	subdomains := []string{}
+	for _, match := range matches {
+		subdomains = append(subdomains, match.Subdomain)
+	}
+
+	return subdomains, nil
+}
+
+// function GetNetworkAsset to get an ip address or a cidr of a target, an ip comes with the subdomains resolving to it, returns a pointer to the asset and an error
+func GetNetworkAsset(client *mongo.Client, target string, address string) (*mytypes.NetworkAsset, error) {
+	parsed, err := ParseNetworkAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting network asset: %v", err)
+	}
+	asset := &mytypes.NetworkAsset{}
+	err = client.Database(NetworkDatabase).Collection(target).FindOne(context.TODO(), bson.M{"address": parsed.Address}).Decode(asset)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting network asset: %v", err)
+	}
+	if asset.Type == mytypes.NetworkIP {
+		asset.Subdomains, err = IPSubdomains(client, target, asset.Address)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error getting network asset: %v", err)
+		}
+	}
+
+	return asset, nil
+}
+
+// function networkFilter to build the filter of a network query, the cidr and contains conditions are ranges on the start and end keys so they use the start_1_end_1 index
+func networkFilter(query mytypes.NetworkQuery) (bson.M, error) {
+	filter := bson.M{}
+	if query.Type != "" {
+		if query.Type != mytypes.NetworkIP && query.Type != mytypes.NetworkCIDR {
+			return nil, fmt.Errorf("invalid network asset type %q", query.Type)
+		}
+		filter["type"] = query.Type
+	}
+	if query.CIDR != "" {
+		network, err := ParseNetworkAddress(query.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		filter["start"] = bson.M{"$gte": network.Start}
+		filter["end"] = bson.M{"$lte": network.End}
+	}
+	if query.Contains != "" {
+		ip, err := ParseNetworkAddress(query.Contains)
+		if err != nil {
+			return nil, err
+		}
+		// the cidrs holding the ip, and the ip itself, kept apart from the cidr condition on the same keys
+		filter["$and"] = bson.A{bson.M{"start": bson.M{"$lte": ip.Start}}, bson.M{"end": bson.M{"$gte": ip.End}}}
+	}
+	port := bson.M{}
+	if query.Port != 0 {
+		port["port"] = query.Port
+	}
+	if query.Protocol != "" {
+		port["protocol"] = strings.ToLower(query.Protocol)
+	}
+	if query.Service != "" {
+		port["service"] = strings.ToLower(query.Service)
+	}
+	if query.Product != "" {
+		port["product"] = query.Product
+	}
+	if len(port) > 0 {
+		filter["ports"] = bson.M{"$elemMatch": port}
+	}
+	if query.ASN != 0 {
+		filter["asn"] = query.ASN
+	}
+
+	return skipMarker(filter), nil
+}
+
+// function FindNetworkAssets to get the ip addresses and cidrs matching the query across targets, e.g. the ips of a target inside a network or the hosts with a port open, returns the assets in the order of the targets and addresses and an error
+func FindNetworkAssets(client *mongo.Client, query mytypes.NetworkQuery) ([]mytypes.NetworkAsset, error) {
+	filter, err := networkFilter(query)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error finding network assets: %v", err)
+	}
+	targets := query.Targets
+	if len(targets) == 0 {
+		targets, err = ListTargets(client, NetworkDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding network assets: %v", err)
+		}
+	}
+
+	assets := []mytypes.NetworkAsset{}
+	for _, target := range targets {
+		opts := options.Find().SetSort(bson.D{{Key: "start", Value: 1}, {Key: "end", Value: 1}})
+		if query.Limit > 0 {
+			opts.SetLimit(int64(query.Limit - len(assets)))
+		}
+		cursor, err := client.Database(NetworkDatabase).Collection(target).Find(context.TODO(), filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding network assets: %v", err)
+		}
+		found := []mytypes.NetworkAsset{}
+		err = cursor.All(context.TODO(), &found)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding network assets: %v", err)
+		}
+		for i := range found {
+			if query.WithSubdomains && found[i].Type == mytypes.NetworkIP {
+				found[i].Subdomains, err = IPSubdomains(client, target, found[i].Address)
+				if err != nil {
+					return nil, fmt.Errorf("[-] Error finding network assets: %v", err)
+				}
+			}
+		}
+		assets = append(assets, found...)
+		if query.Limit > 0 && len(assets) >= query.Limit {
+			break
+		}
+	}
+
+	return assets, nil
+}
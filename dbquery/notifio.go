@@ -0,0 +1,415 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Notifio                  ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the notifio database (not target based) and its collections
+var (
+	NotifioDatabase    = "notifio"
+	NotifOutbox        = "outbox"
+	NotifChannels      = "channels"
+	NotifRoutes        = "routes"
+	NotifDedup         = "dedup"
+	NotifLeaseDuration = 2 * time.Minute
+)
+
+// defaults used when a channel doesn't set its own retry policy, and the longest wait between two attempts
+const (
+	defaultNotifMaxAttempts    = 5
+	defaultNotifBackoffSeconds = 30
+	maxNotifBackoff            = 6 * time.Hour
+)
+
+// settings of a channel config that are masked in the audit records
+var notifSecretSettings = []string{"password", "authorization"}
+
+// function channelAuditDocument to mask the secret settings of a channel document before it goes into an audit record
+func channelAuditDocument(document bson.M) bson.M {
+	config, ok := toM(document["config"])
+	if !ok {
+		return document
+	}
+	masked := bson.M{}
+	for k, v := range config {
+		masked[k] = v
+		if myutils.ContainsString(notifSecretSettings, k) && v != "" {
+			masked[k] = "********"
+		}
+	}
+	document["config"] = masked
+	return document
+}
+
+// function replaceNotifAudited to add or replace (by name) a channel or route document and audit the change, returns an error
+func replaceNotifAudited(client *mongo.Client, collection string, name string, document interface{}, mask func(bson.M) bson.M) error {
+	filter := bson.M{"name": name}
+	before := bson.M{}
+	opts := options.FindOneAndReplace().SetUpsert(true)
+	err := client.Database(NotifioDatabase).Collection(collection).FindOneAndReplace(context.TODO(), filter, document, opts).Decode(&before)
+	operation := mytypes.AuditUpdate
+	if err == mongo.ErrNoDocuments {
+		// the upsert created it
+		before, operation, err = nil, mytypes.AuditInsert, nil
+	}
+	if err != nil {
+		auditOperation(context.TODO(), client, operation, NotifioDatabase, collection, filter, nil, nil, 0, err)
+		return err
+	}
+	after := auditDocument(document)
+	delete(after, "_id")
+	if before != nil {
+		after["_id"] = before["_id"]
+		before = mask(before)
+	}
+	auditOperation(context.TODO(), client, operation, NotifioDatabase, collection, filter, before, mask(after), 1, nil)
+
+	return nil
+}
+
+// function AddNotifChannel to add or replace (by name) a notification channel, the change is audited with the password and authorization settings masked, returns an error
+func AddNotifChannel(client *mongo.Client, channel mytypes.NotifChannel) error {
+	if _, ok := GetNotifSink(channel.Sink); !ok {
+		return fmt.Errorf("[-] Error adding channel: unknown sink %q", channel.Sink)
+	}
+	channel.ID = primitive.NilObjectID
+	err := replaceNotifAudited(client, NotifChannels, channel.Name, channel, channelAuditDocument)
+	if err != nil {
+		return fmt.Errorf("[-] Error adding channel: %v", err)
+	}
+	fmt.Println("[+] Added channel successfully")
+
+	return nil
+}
+
+// function GetNotifChannels to get all the notification channels, returns a map of channel name to channel and an error
+func GetNotifChannels(client *mongo.Client) (map[string]mytypes.NotifChannel, error) {
+	cursor, err := client.Database(NotifioDatabase).Collection(NotifChannels).Find(context.TODO(), bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting channels: %v", err)
+	}
+	channels := []mytypes.NotifChannel{}
+	err = cursor.All(context.TODO(), &channels)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding channels: %v", err)
+	}
+	channelmap := map[string]mytypes.NotifChannel{}
+	for _, channel := range channels {
+		channelmap[channel.Name] = channel
+	}
+
+	return channelmap, nil
+}
+
+// function AddNotifRoute to add or replace (by name) a routing rule, returns an error
+func AddNotifRoute(client *mongo.Client, route mytypes.NotifRoute) error {
+	route.ID = primitive.NilObjectID
+	err := replaceNotifAudited(client, NotifRoutes, route.Name, route, func(document bson.M) bson.M { return document })
+	if err != nil {
+		return fmt.Errorf("[-] Error adding route: %v", err)
+	}
+	fmt.Println("[+] Added route successfully")
+
+	return nil
+}
+
+// function GetNotifRoutes to get all the enabled routing rules, returns a slice of routes and an error
+func GetNotifRoutes(client *mongo.Client) ([]mytypes.NotifRoute, error) {
+	cursor, err := client.Database(NotifioDatabase).Collection(NotifRoutes).Find(context.TODO(), bson.M{"enabled": true})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting routes: %v", err)
+	}
+	routes := []mytypes.NotifRoute{}
+	err = cursor.All(context.TODO(), &routes)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding routes: %v", err)
+	}
+
+	return routes, nil
+}
+
+// function routeMatches to check whether a routing rule applies to a notification type and target, empty lists match everything
+func routeMatches(route mytypes.NotifRoute, ntype string, target string) bool {
+	if len(route.Types) > 0 && !myutils.ContainsString(route.Types, ntype) {
+		return false
+	}
+	if len(route.Targets) > 0 && !myutils.ContainsString(route.Targets, target) {
+		return false
+	}
+	return true
+}
+
+// function claimDedupWindow to take the dedup window of a dedup key on a channel, there is one claim document per key and channel (unique index) and it is only taken over once its window is over, so of two concurrent producers only one gets it, returns whether the window was taken and an error
+func claimDedupWindow(client *mongo.Client, dedupkey string, channel string, window time.Duration, now time.Time) (bool, error) {
+	filter := bson.M{"dedup_key": dedupkey, "channel": channel, "last_at": bson.M{"$lte": now.Add(-window)}}
+	update := bson.M{"$set": bson.M{"last_at": now, "expires_at": now.Add(window)}}
+	_, err := client.Database(NotifioDatabase).Collection(NotifDedup).UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// the claim exists and its window isn't over, the upsert tried to insert a second one
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// function EnqueueNotification to put a notification into the outbox, it is routed to the channels of every matching route except the ones that already got the same dedup key inside the route's dedup window (taken atomically per channel), an empty dedup key is derived from the type, target and title, returns the id of the notification (empty if nothing was enqueued) and an error
+func EnqueueNotification(client *mongo.Client, ntype string, target string, title string, body string, data bson.M, dedupkey string) (string, error) {
+	if dedupkey == "" {
+		dedupkey = myutils.HashString(ntype + "\x00" + target + "\x00" + title)
+	}
+	routes, err := GetNotifRoutes(client)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error enqueueing notification: %v", err)
+	}
+
+	err = ensureIndexes(client, NotifioDatabase, NotifDedup)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error enqueueing notification: %v", err)
+	}
+
+	outbox := client.Database(NotifioDatabase).Collection(NotifOutbox)
+	now := time.Now().UTC()
+	deliveries := []mytypes.NotifDelivery{}
+	added := []string{}
+	claims := []string{}
+	for _, route := range routes {
+		if !routeMatches(route, ntype, target) {
+			continue
+		}
+		for _, channel := range route.Channels {
+			if myutils.ContainsString(added, channel) {
+				continue
+			}
+			if route.DedupWindowSeconds > 0 {
+				claimed, err := claimDedupWindow(client, dedupkey, channel, time.Duration(route.DedupWindowSeconds)*time.Second, now)
+				if err != nil {
+					return "", fmt.Errorf("[-] Error checking dedup window: %v", err)
+				}
+				if !claimed {
+					continue
+				}
+				claims = append(claims, channel)
+			}
+			added = append(added, channel)
+			deliveries = append(deliveries, mytypes.NotifDelivery{
+				Channel:     channel,
+				Status:      mytypes.NotifPending,
+				NextAttempt: now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		fmt.Println("[+] No route matched or notification is a duplicate, nothing enqueued")
+		return "", nil
+	}
+
+	notification := mytypes.Notification{
+		Type:        ntype,
+		Target:      target,
+		Title:       title,
+		Body:        body,
+		Data:        data,
+		DedupKey:    dedupkey,
+		Status:      mytypes.NotifPending,
+		Deliveries:  deliveries,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+	result, err := outbox.InsertOne(context.TODO(), notification)
+	if err != nil {
+		// give the windows back, nothing was enqueued
+		client.Database(NotifioDatabase).Collection(NotifDedup).DeleteMany(context.TODO(), bson.M{"dedup_key": dedupkey, "channel": bson.M{"$in": claims}, "last_at": now})
+		return "", fmt.Errorf("[-] Error enqueueing notification: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, NotifioDatabase, NotifOutbox, nil, nil, bson.M{"_id": result.InsertedID, "type": ntype, "target": target, "dedup_key": dedupkey, "channels": added}, 1, nil)
+	fmt.Println("[+] Enqueued notification successfully")
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// function claimNotification to lease the next due notification of the outbox so no other process delivers it at the same time, returns nil if nothing is due
+func claimNotification(client *mongo.Client, now time.Time) (*mytypes.Notification, error) {
+	filter := bson.M{
+		"status":       mytypes.NotifPending,
+		"next_attempt": bson.M{"$lte": now},
+		"locked_until": bson.M{"$lte": now},
+	}
+	// every claim gets its own owner so a delivery whose lease expired can't overwrite the state of the next claim
+	update := bson.M{"$set": bson.M{"locked_by": primitive.NewObjectID().Hex(), "locked_until": now.Add(NotifLeaseDuration)}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "next_attempt", Value: 1}}).SetReturnDocument(options.After)
+
+	notification := &mytypes.Notification{}
+	err := client.Database(NotifioDatabase).Collection(NotifOutbox).FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(notification)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error claiming notification: %v", err)
+	}
+
+	return notification, nil
+}
+
+// function notifBackoff to get the wait before the next attempt of a delivery, the backoff doubles with every failed attempt and is capped so a misconfigured channel doesn't push retries out for days, the doubling stops at the cap so it never overflows, returns a positive duration
+func notifBackoff(backoffseconds int, attempts int) time.Duration {
+	if backoffseconds <= 0 {
+		backoffseconds = defaultNotifBackoffSeconds
+	}
+	wait := time.Duration(backoffseconds) * time.Second
+	for i := 1; i < attempts && wait < maxNotifBackoff; i++ {
+		wait *= 2
+	}
+	if wait <= 0 || wait > maxNotifBackoff {
+		wait = maxNotifBackoff
+	}
+	return wait
+}
+
+// function attemptDeliveries to try every due delivery of a notification once through its channel's sink, a failed delivery is rescheduled with backoff until the channel's max attempts, returns the number of successful deliveries, the notification status and its next attempt
+func attemptDeliveries(notification *mytypes.Notification, channels map[string]mytypes.NotifChannel, now time.Time) (int, string, time.Time) {
+	delivered := 0
+	for i := range notification.Deliveries {
+		delivery := &notification.Deliveries[i]
+		if delivery.Status != mytypes.NotifPending || delivery.NextAttempt.After(now) {
+			continue
+		}
+		channel, ok := channels[delivery.Channel]
+		if !ok || !channel.Enabled {
+			delivery.Status = mytypes.NotifSkipped
+			delivery.LastError = "channel is missing or disabled"
+			continue
+		}
+		maxattempts := channel.MaxAttempts
+		if maxattempts <= 0 {
+			maxattempts = defaultNotifMaxAttempts
+		}
+
+		delivery.Attempts++
+		err := SendNotification(channel, *notification)
+		if err == nil {
+			delivered++
+			deliveredat := time.Now().UTC()
+			delivery.Status = mytypes.NotifDelivered
+			delivery.DeliveredAt = &deliveredat
+			delivery.LastError = ""
+			continue
+		}
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= maxattempts {
+			delivery.Status = mytypes.NotifFailed
+			continue
+		}
+		delivery.NextAttempt = now.Add(notifBackoff(channel.BackoffSeconds, delivery.Attempts))
+	}
+
+	// the notification is pending while any delivery is pending, delivered if at least one delivery went out, failed otherwise
+	status := mytypes.NotifFailed
+	var next time.Time
+	for _, delivery := range notification.Deliveries {
+		if delivery.Status == mytypes.NotifPending {
+			if status != mytypes.NotifPending || delivery.NextAttempt.Before(next) {
+				next = delivery.NextAttempt
+			}
+			status = mytypes.NotifPending
+		} else if delivery.Status == mytypes.NotifDelivered && status != mytypes.NotifPending {
+			status = mytypes.NotifDelivered
+		}
+	}
+
+	return delivered, status, next
+}
+
+// function deliverNotification to try every due delivery of a leased notification once, then stores the new delivery states and releases the lease, the states are only stored while the lease is still held, returns the number of successful deliveries and an error if the lease was lost
+func deliverNotification(client *mongo.Client, notification *mytypes.Notification, channels map[string]mytypes.NotifChannel, now time.Time) (int, error) {
+	delivered, status, next := attemptDeliveries(notification, channels, now)
+	set := bson.M{
+		"deliveries":   notification.Deliveries,
+		"status":       status,
+		"locked_until": time.Time{},
+	}
+	if status == mytypes.NotifPending {
+		set["next_attempt"] = next
+	}
+	filter := bson.M{"_id": notification.ID, "locked_by": notification.LockedBy, "locked_until": notification.LockedUntil}
+	update := bson.M{"$set": set, "$unset": bson.M{"locked_by": ""}}
+	result, err := client.Database(NotifioDatabase).Collection(NotifOutbox).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, NotifioDatabase, NotifOutbox, filter, nil, nil, 0, err)
+		return delivered, fmt.Errorf("[-] Error storing delivery state: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		// the lease expired and the notification was claimed again, the other claim stores its own state
+		return delivered, fmt.Errorf("[-] Error storing delivery state: lease on notification %s was lost", notification.ID.Hex())
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, NotifioDatabase, NotifOutbox, filter, bson.M{"_id": notification.ID, "status": notification.Status}, bson.M{"_id": notification.ID, "status": status}, 1, nil)
+
+	return delivered, nil
+}
+
+// function ProcessOutbox to deliver up to limit due notifications of the outbox through their channels' sinks, failed deliveries are retried with exponential backoff until the channel's max attempts, returns the number of successful deliveries and an error
+func ProcessOutbox(client *mongo.Client, limit int) (int, error) {
+	channels, err := GetNotifChannels(client)
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error processing outbox: %v", err)
+	}
+
+	delivered := 0
+	for i := 0; i < limit; i++ {
+		now := time.Now().UTC()
+		notification, err := claimNotification(client, now)
+		if err != nil {
+			return delivered, fmt.Errorf("[-] Error processing outbox: %v", err)
+		}
+		if notification == nil {
+			break
+		}
+		n, err := deliverNotification(client, notification, channels, now)
+		delivered += n
+		if err != nil {
+			return delivered, fmt.Errorf("[-] Error processing outbox: %v", err)
+		}
+	}
+	fmt.Printf("[+] Processed outbox successfully: %d delivered\n", delivered)
+
+	return delivered, nil
+}
+
+// function GetNotifications to get the notifications of the outbox with the given status (empty for all), newest first, returns a slice of notifications and an error
+func GetNotifications(client *mongo.Client, status string, limit int64) ([]mytypes.Notification, error) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := client.Database(NotifioDatabase).Collection(NotifOutbox).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting notifications: %v", err)
+	}
+	notifications := []mytypes.Notification{}
+	err = cursor.All(context.TODO(), &notifications)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding notifications: %v", err)
+	}
+
+	return notifications, nil
+}
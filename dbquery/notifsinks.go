@@ -0,0 +1,250 @@
+package dbquery
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"healerdb/mytypes"
+)
+
+// NotifSink delivers a notification to a channel, the channel's Config holds the sink specific settings
+type NotifSink interface {
+	Send(channel mytypes.NotifChannel, notification mytypes.Notification) error
+}
+
+// NotifSinkFunc is an adapter to use a plain function as a NotifSink
+type NotifSinkFunc func(channel mytypes.NotifChannel, notification mytypes.Notification) error
+
+// Send calls f(channel, notification)
+func (f NotifSinkFunc) Send(channel mytypes.NotifChannel, notification mytypes.Notification) error {
+	return f(channel, notification)
+}
+
+// http client used by the webhook sinks
+var notifHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// deadline of a whole smtp exchange, kept well under NotifLeaseDuration
+var notifSMTPTimeout = 30 * time.Second
+
+var (
+	notifSinksMu sync.RWMutex
+	notifSinks   = map[string]NotifSink{
+		"webhook": NotifSinkFunc(webhookSink),
+		"slack":   NotifSinkFunc(slackSink),
+		"smtp":    NotifSinkFunc(smtpSink),
+		"file":    NotifSinkFunc(fileSink),
+	}
+)
+
+// function RegisterNotifSink to register (or replace) a sink under the given name, channels refer to sinks by name
+func RegisterNotifSink(name string, sink NotifSink) {
+	notifSinksMu.Lock()
+	defer notifSinksMu.Unlock()
+	notifSinks[name] = sink
+}
+
+// function GetNotifSink to get the sink registered under the given name, returns the sink and whether it exists
+func GetNotifSink(name string) (NotifSink, bool) {
+	notifSinksMu.RLock()
+	defer notifSinksMu.RUnlock()
+	sink, ok := notifSinks[name]
+	return sink, ok
+}
+
+// function SendNotification to deliver a notification through the sink of the given channel, returns an error
+func SendNotification(channel mytypes.NotifChannel, notification mytypes.Notification) error {
+	sink, ok := GetNotifSink(channel.Sink)
+	if !ok {
+		return fmt.Errorf("[-] Error sending notification: unknown sink %q", channel.Sink)
+	}
+	return sink.Send(channel, notification)
+}
+
+// function postJSON to post a json body to the given url, any non-2xx response is an error
+func postJSON(url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("[-] Error converting notification to json: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("[-] Error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := notifHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("[-] Error posting notification: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("[-] Error posting notification: %s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
+
+// function webhookSink to post the whole notification as json to config["url"], config["authorization"] is sent as the Authorization header if set
+func webhookSink(channel mytypes.NotifChannel, notification mytypes.Notification) error {
+	url := channel.Config["url"]
+	if url == "" {
+		return fmt.Errorf("[-] Error sending webhook: channel %q has no url", channel.Name)
+	}
+	headers := map[string]string{}
+	if auth := channel.Config["authorization"]; auth != "" {
+		headers["Authorization"] = auth
+	}
+	return postJSON(url, headers, notification)
+}
+
+// function slackSink to post a slack-compatible '{"text": ...}' message to config["url"]
+func slackSink(channel mytypes.NotifChannel, notification mytypes.Notification) error {
+	url := channel.Config["url"]
+	if url == "" {
+		return fmt.Errorf("[-] Error sending slack message: channel %q has no url", channel.Name)
+	}
+	text := fmt.Sprintf("*[%s] %s*", notification.Type, notification.Title)
+	if notification.Target != "" {
+		text += fmt.Sprintf(" (target: %s)", notification.Target)
+	}
+	if notification.Body != "" {
+		text += "\n" + notification.Body
+	}
+	return postJSON(url, nil, map[string]string{"text": text})
+}
+
+// function headerValue to make a value safe to put in a mail header, CR and LF would start a new header so they become spaces
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+// function smtpMessage to build the mail of a notification, every header value is sanitised and the subject is encoded if it isn't plain ascii, returns the message
+func smtpMessage(from *mail.Address, to []*mail.Address, notification mytypes.Notification) string {
+	recipients := []string{}
+	for _, addr := range to {
+		recipients = append(recipients, headerValue(addr.String()))
+	}
+	subject := headerValue(fmt.Sprintf("[healer][%s] %s", notification.Type, notification.Title))
+	return "From: " + headerValue(from.String()) + "\r\n" +
+		"To: " + strings.Join(recipients, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + notification.Body + "\r\n"
+}
+
+// function smtpSink to mail the notification using config["host"], config["port"], config["username"], config["password"], config["from"] and the comma separated config["to"], the whole exchange has to finish within notifSMTPTimeout so a stuck server can't outlive the outbox lease
+func smtpSink(channel mytypes.NotifChannel, notification mytypes.Notification) error {
+	host := channel.Config["host"]
+	port := channel.Config["port"]
+	if port == "" {
+		port = "25"
+	}
+	if host == "" || channel.Config["from"] == "" || strings.TrimSpace(channel.Config["to"]) == "" {
+		return fmt.Errorf("[-] Error sending mail: channel %q needs host, from and to", channel.Name)
+	}
+	from, err := mail.ParseAddress(channel.Config["from"])
+	if err != nil {
+		return fmt.Errorf("[-] Error sending mail: invalid from address: %v", err)
+	}
+	to := []*mail.Address{}
+	for _, addr := range strings.Split(channel.Config["to"], ",") {
+		if addr = strings.TrimSpace(addr); addr == "" {
+			continue
+		}
+		parsed, err := mail.ParseAddress(addr)
+		if err != nil {
+			return fmt.Errorf("[-] Error sending mail: invalid to address: %v", err)
+		}
+		to = append(to, parsed)
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), notifSMTPTimeout)
+	if err != nil {
+		return fmt.Errorf("[-] Error sending mail: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(notifSMTPTimeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("[-] Error sending mail: %v", err)
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return fmt.Errorf("[-] Error sending mail: %v", err)
+		}
+	}
+	if channel.Config["username"] != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("[-] Error sending mail: %s doesn't support AUTH", host)
+		}
+		err = c.Auth(smtp.PlainAuth("", channel.Config["username"], channel.Config["password"], host))
+		if err != nil {
+			return fmt.Errorf("[-] Error sending mail: %v", err)
+		}
+	}
+	err = c.Mail(from.Address)
+	if err != nil {
+		return fmt.Errorf("[-] Error sending mail: %v", err)
+	}
+	for _, addr := range to {
+		err = c.Rcpt(addr.Address)
+		if err != nil {
+			return fmt.Errorf("[-] Error sending mail: %v", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("[-] Error sending mail: %v", err)
+	}
+	_, err = w.Write([]byte(smtpMessage(from, to, notification)))
+	if err != nil {
+		return fmt.Errorf("[-] Error sending mail: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return fmt.Errorf("[-] Error sending mail: %v", err)
+	}
+	c.Quit()
+
+	return nil
+}
+
+// function fileSink to append the notification as one json line to config["path"]
+func fileSink(channel mytypes.NotifChannel, notification mytypes.Notification) error {
+	path := channel.Config["path"]
+	if path == "" {
+		return fmt.Errorf("[-] Error writing notification: channel %q has no path", channel.Name)
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("[-] Error converting notification to json: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("[-] Error writing notification: %v", err)
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("[-] Error writing notification: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,283 @@
+package dbquery
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"healerdb/mytypes"
+)
+
+// sinkRecorder is a local stand-in for a webhook endpoint, it records every request and answers with status
+type sinkRecorder struct {
+	mu       sync.Mutex
+	status   int
+	bodies   [][]byte
+	auths    []string
+	ctypes   []string
+	requests int
+}
+
+func (r *sinkRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests++
+	r.bodies = append(r.bodies, body)
+	r.auths = append(r.auths, req.Header.Get("Authorization"))
+	r.ctypes = append(r.ctypes, req.Header.Get("Content-Type"))
+	status := r.status
+	r.mu.Unlock()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+}
+
+func newSinkServer(t *testing.T, status int) (*httptest.Server, *sinkRecorder) {
+	t.Helper()
+	recorder := &sinkRecorder{status: status}
+	server := httptest.NewServer(recorder)
+	t.Cleanup(server.Close)
+	return server, recorder
+}
+
+func testNotification() mytypes.Notification {
+	return mytypes.Notification{
+		Type:     mytypes.NotifNewSubdomain,
+		Target:   "example",
+		Title:    "new subdomain api.example.com",
+		Body:     "api.example.com was found by subfinder",
+		DedupKey: "example/api.example.com",
+		Status:   mytypes.NotifPending,
+	}
+}
+
+func TestWebhookSinkPostsNotification(t *testing.T) {
+	server, recorder := newSinkServer(t, http.StatusNoContent)
+	channel := mytypes.NotifChannel{
+		Name:    "hook",
+		Sink:    "webhook",
+		Config:  map[string]string{"url": server.URL, "authorization": "Bearer s3cret"},
+		Enabled: true,
+	}
+
+	err := SendNotification(channel, testNotification())
+	if err != nil {
+		t.Fatalf("SendNotification: %v", err)
+	}
+	if recorder.requests != 1 {
+		t.Fatalf("got %d requests, want 1", recorder.requests)
+	}
+	if recorder.auths[0] != "Bearer s3cret" {
+		t.Errorf("Authorization = %q, want %q", recorder.auths[0], "Bearer s3cret")
+	}
+	if recorder.ctypes[0] != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", recorder.ctypes[0])
+	}
+	var got mytypes.Notification
+	err = json.Unmarshal(recorder.bodies[0], &got)
+	if err != nil {
+		t.Fatalf("webhook body isn't a notification: %v", err)
+	}
+	if got.Title != testNotification().Title || got.Target != "example" || got.Type != mytypes.NotifNewSubdomain {
+		t.Errorf("webhook body = %+v", got)
+	}
+}
+
+func TestSlackSinkPostsText(t *testing.T) {
+	server, recorder := newSinkServer(t, http.StatusOK)
+	channel := mytypes.NotifChannel{Name: "slack", Sink: "slack", Config: map[string]string{"url": server.URL}, Enabled: true}
+
+	err := SendNotification(channel, testNotification())
+	if err != nil {
+		t.Fatalf("SendNotification: %v", err)
+	}
+	var got map[string]string
+	err = json.Unmarshal(recorder.bodies[0], &got)
+	if err != nil {
+		t.Fatalf("slack body isn't json: %v", err)
+	}
+	want := "*[new_subdomain] new subdomain api.example.com* (target: example)\napi.example.com was found by subfinder"
+	if got["text"] != want {
+		t.Errorf("slack text = %q, want %q", got["text"], want)
+	}
+	if recorder.auths[0] != "" {
+		t.Errorf("slack sink sent an Authorization header: %q", recorder.auths[0])
+	}
+}
+
+func TestWebhookSinkNon2xxIsError(t *testing.T) {
+	for _, status := range []int{http.StatusMovedPermanently, http.StatusBadRequest, http.StatusInternalServerError} {
+		server, _ := newSinkServer(t, status)
+		for _, sink := range []string{"webhook", "slack"} {
+			channel := mytypes.NotifChannel{Name: sink, Sink: sink, Config: map[string]string{"url": server.URL}, Enabled: true}
+			err := SendNotification(channel, testNotification())
+			if err == nil {
+				t.Errorf("%s sink: status %d wasn't reported as an error", sink, status)
+			}
+		}
+	}
+}
+
+func TestSinkMissingURL(t *testing.T) {
+	for _, sink := range []string{"webhook", "slack"} {
+		channel := mytypes.NotifChannel{Name: sink, Sink: sink, Config: map[string]string{}, Enabled: true}
+		if err := SendNotification(channel, testNotification()); err == nil {
+			t.Errorf("%s sink without url didn't fail", sink)
+		}
+	}
+}
+
+func TestAttemptDeliveriesRetrySchedule(t *testing.T) {
+	server, recorder := newSinkServer(t, http.StatusInternalServerError)
+	channels := map[string]mytypes.NotifChannel{
+		"hook": {Name: "hook", Sink: "webhook", Config: map[string]string{"url": server.URL}, MaxAttempts: 3, BackoffSeconds: 10, Enabled: true},
+	}
+	notification := testNotification()
+	notification.Deliveries = []mytypes.NotifDelivery{{Channel: "hook", Status: mytypes.NotifPending}}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// every failed attempt is rescheduled with a doubling backoff
+	for attempt, wait := range []time.Duration{10 * time.Second, 20 * time.Second} {
+		delivered, status, next := attemptDeliveries(&notification, channels, now)
+		delivery := notification.Deliveries[0]
+		if delivered != 0 || status != mytypes.NotifPending {
+			t.Fatalf("attempt %d: delivered %d, status %q, want 0 and pending", attempt+1, delivered, status)
+		}
+		if delivery.Attempts != attempt+1 || delivery.LastError == "" {
+			t.Fatalf("attempt %d: delivery = %+v", attempt+1, delivery)
+		}
+		if !delivery.NextAttempt.Equal(now.Add(wait)) || !next.Equal(delivery.NextAttempt) {
+			t.Fatalf("attempt %d: next attempt %v (notification %v), want %v", attempt+1, delivery.NextAttempt, next, now.Add(wait))
+		}
+
+		// not due yet, so nothing is sent
+		requests := recorder.requests
+		attemptDeliveries(&notification, channels, now.Add(wait-time.Second))
+		if recorder.requests != requests || notification.Deliveries[0].Attempts != attempt+1 {
+			t.Fatalf("attempt %d: a delivery that wasn't due was attempted", attempt+1)
+		}
+		now = now.Add(wait)
+	}
+
+	// the last attempt fails the delivery for good
+	delivered, status, _ := attemptDeliveries(&notification, channels, now)
+	if delivered != 0 || status != mytypes.NotifFailed || notification.Deliveries[0].Status != mytypes.NotifFailed {
+		t.Fatalf("after max attempts: delivered %d, status %q, delivery %+v", delivered, status, notification.Deliveries[0])
+	}
+	if recorder.requests != 3 {
+		t.Errorf("got %d requests, want 3", recorder.requests)
+	}
+}
+
+func TestAttemptDeliveriesMixedChannels(t *testing.T) {
+	ok, _ := newSinkServer(t, http.StatusOK)
+	broken, _ := newSinkServer(t, http.StatusBadGateway)
+	channels := map[string]mytypes.NotifChannel{
+		"ok":       {Name: "ok", Sink: "webhook", Config: map[string]string{"url": ok.URL}, Enabled: true},
+		"broken":   {Name: "broken", Sink: "slack", Config: map[string]string{"url": broken.URL}, Enabled: true},
+		"disabled": {Name: "disabled", Sink: "webhook", Config: map[string]string{"url": ok.URL}},
+	}
+	notification := testNotification()
+	notification.Deliveries = []mytypes.NotifDelivery{
+		{Channel: "ok", Status: mytypes.NotifPending},
+		{Channel: "broken", Status: mytypes.NotifPending},
+		{Channel: "disabled", Status: mytypes.NotifPending},
+		{Channel: "gone", Status: mytypes.NotifPending},
+	}
+	now := time.Now().UTC()
+
+	delivered, status, next := attemptDeliveries(&notification, channels, now)
+	if delivered != 1 || status != mytypes.NotifPending {
+		t.Fatalf("delivered %d, status %q, want 1 and pending", delivered, status)
+	}
+	if d := notification.Deliveries[0]; d.Status != mytypes.NotifDelivered || d.DeliveredAt == nil {
+		t.Errorf("ok delivery = %+v", d)
+	}
+	if d := notification.Deliveries[1]; d.Status != mytypes.NotifPending || !d.NextAttempt.Equal(now.Add(defaultNotifBackoffSeconds*time.Second)) || !next.Equal(d.NextAttempt) {
+		t.Errorf("broken delivery = %+v, next %v", d, next)
+	}
+	for _, d := range notification.Deliveries[2:] {
+		if d.Status != mytypes.NotifSkipped || d.Attempts != 0 {
+			t.Errorf("%s delivery = %+v, want skipped", d.Channel, d)
+		}
+	}
+}
+
+func TestNotifBackoff(t *testing.T) {
+	if got := notifBackoff(0, 1); got != defaultNotifBackoffSeconds*time.Second {
+		t.Errorf("default backoff = %v", got)
+	}
+	previous := time.Duration(0)
+	for attempts := 1; attempts < 200; attempts++ {
+		wait := notifBackoff(10, attempts)
+		if wait <= 0 || wait > maxNotifBackoff {
+			t.Fatalf("notifBackoff(10, %d) = %v, out of (0, %v]", attempts, wait, maxNotifBackoff)
+		}
+		if wait < previous {
+			t.Fatalf("notifBackoff(10, %d) = %v, shorter than %v", attempts, wait, previous)
+		}
+		previous = wait
+	}
+	if previous != maxNotifBackoff {
+		t.Errorf("backoff never reached the cap, got %v", previous)
+	}
+	if got := notifBackoff(1<<40, 3); got != maxNotifBackoff {
+		t.Errorf("huge backoff = %v, want %v", got, maxNotifBackoff)
+	}
+}
+
+func TestSMTPMessageHeaders(t *testing.T) {
+	from, err := mail.ParseAddress("Healer <healer@example.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := []*mail.Address{{Address: "ops@example.com"}, {Name: "Sec\r\nBcc: evil@example.com", Address: "sec@example.com"}}
+	notification := testNotification()
+	notification.Title = "injected\r\nBcc: evil@example.com"
+
+	msg := smtpMessage(from, to, notification)
+	header, _, found := strings.Cut(msg, "\r\n\r\n")
+	if !found {
+		t.Fatalf("message has no header/body separator: %q", msg)
+	}
+	lines := strings.Split(header, "\r\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d header lines, want 4: %q", len(lines), lines)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(strings.ToLower(line), "bcc:") || strings.ContainsAny(line, "\r\n") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+
+	for _, addr := range []string{"ops@example.com\r\nBcc: evil@example.com", "ops@example.com\nBcc: evil@example.com"} {
+		if _, err := mail.ParseAddress(addr); err == nil {
+			t.Errorf("ParseAddress accepted %q", addr)
+		}
+	}
+}
+
+func TestSMTPSinkRejectsInjectedAddresses(t *testing.T) {
+	channel := mytypes.NotifChannel{
+		Name: "mail",
+		Sink: "smtp",
+		Config: map[string]string{
+			"host": "127.0.0.1",
+			"port": "1",
+			"from": "healer@example.com\r\nBcc: evil@example.com",
+			"to":   "ops@example.com",
+		},
+		Enabled: true,
+	}
+	err := SendNotification(channel, testNotification())
+	if err == nil || !strings.Contains(err.Error(), "invalid from address") {
+		t.Errorf("injected from address: err = %v", err)
+	}
+}
@@ -0,0 +1,409 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Provenance               ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// fields every node of the enum doc tree gets when it is seen, they are not part of the node's own fields for the watch hashes
+var enumProvenanceFields = []string{"first_seen", "last_seen", "seen_count", "sources"}
+
+func init() {
+	err := RegisterMigration(Migration{
+		Version:     1,
+		Name:        "enum_asset_provenance",
+		Description: "give every node of the enum doc tree first_seen, last_seen (the creation time of its domain document), seen_count 1 and no sources, plain string nodes become documents",
+		PerTarget:   true,
+		Databases:   []string{EnumDatabase},
+		Up:          backfillProvenance,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// function nodeFilter to build the array filter selecting the nodes with the given name under the identifier, the name is in any of the fields
+func nodeFilter(id string, fields []string, name string) bson.M {
+	or := bson.A{}
+	for _, field := range fields {
+		or = append(or, bson.M{id + "." + field: name})
+	}
+	return bson.M{"$or": or}
+}
+
+// function canonicalAsset to clear the fields an asset type doesn't use and write its path the way the flat view does
+func canonicalAsset(asset mytypes.TargetAsset) mytypes.TargetAsset {
+	asset.Domain = normalizeHost(asset.Domain)
+	asset.Subdomain = normalizeHost(asset.Subdomain)
+	switch asset.Type {
+	case mytypes.AssetDomain:
+		asset.Subdomain, asset.Path, asset.Parameter = "", "", ""
+	case mytypes.AssetSubdomain:
+		asset.Path, asset.Parameter = "", ""
+	case mytypes.AssetDirectory, mytypes.AssetFile:
+		asset.Path, asset.Parameter = "/"+strings.Join(pathSegments(asset.Path), "/"), ""
+	case mytypes.AssetParameter:
+		asset.Path = "/" + strings.Join(pathSegments(asset.Path), "/")
+	}
+	return asset
+}
+
+// function assetUpdatePath to get the update path prefix of an asset inside its domain document and the array filters that select its node, the nodes are found by the same names as in mergeChildren, returns the prefix (empty for a domain), the filters and an error
+func assetUpdatePath(asset mytypes.TargetAsset) (string, []interface{}, error) {
+	if asset.Type == mytypes.AssetDomain {
+		return "", nil, nil
+	}
+	prefix := "subdomains.$[s]."
+	filters := []interface{}{bson.M{"s.subdomain": asset.Subdomain}}
+	if asset.Type == mytypes.AssetSubdomain {
+		return prefix, filters, nil
+	}
+	dirs := pathSegments(asset.Path)
+	leaf := ""
+	switch asset.Type {
+	case mytypes.AssetDirectory:
+		if len(dirs) == 0 {
+			return "", nil, fmt.Errorf("path is required for a directory")
+		}
+	case mytypes.AssetFile:
+		if len(dirs) == 0 {
+			return "", nil, fmt.Errorf("path is required for a file")
+		}
+		leaf = dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+	case mytypes.AssetParameter:
+		if asset.Parameter == "" {
+			return "", nil, fmt.Errorf("parameter is required")
+		}
+		leaf = asset.Parameter
+	default:
+		return "", nil, fmt.Errorf("unknown asset type %q", asset.Type)
+	}
+	if len(dirs) == 0 {
+		dirs = []string{"/"}
+	}
+	for i, dir := range dirs {
+		id := fmt.Sprintf("d%d", i)
+		children := "subdirectories"
+		if i == 0 {
+			children = "directories"
+		}
+		prefix += children + ".$[" + id + "]."
+		filters = append(filters, nodeFilter(id, enumChildNames[children], dir))
+	}
+	switch asset.Type {
+	case mytypes.AssetFile:
+		prefix += "files.$[f]."
+		filters = append(filters, nodeFilter("f", enumChildNames["files"], leaf))
+	case mytypes.AssetParameter:
+		prefix += "parameters.$[p]."
+		filters = append(filters, nodeFilter("p", enumChildNames["parameters"], leaf))
+	}
+	return prefix, filters, nil
+}
+
+// function SeeAsset to record that an asset of a target was found (again) by a source, the asset is added to the enum doc tree if needed and then its first_seen, last_seen, seen_count and sources are updated by one atomic update of its domain document, returns whether this was the first time the asset was seen and an error
+func SeeAsset(client *mongo.Client, target string, asset mytypes.TargetAsset, source mytypes.AssetSource) (bool, error) {
+	asset = canonicalAsset(asset)
+	prefix, filters, err := assetUpdatePath(asset)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error recording asset: %v", err)
+	}
+	doc, err := assetDocument(asset)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error recording asset: %v", err)
+	}
+	err = ensureEnumIndexes(client, EnumDatabase, target)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error recording asset: %v", err)
+	}
+	coll := client.Database(EnumDatabase).Collection(target)
+	_, err = mergeDocument(coll, doc)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error recording asset: %v", err)
+	}
+
+	now := time.Now().UTC()
+	update := bson.M{
+		"$min": bson.M{prefix + "first_seen": now},
+		"$max": bson.M{prefix + "last_seen": now},
+		"$inc": bson.M{prefix + "seen_count": 1},
+	}
+	if source.Source != "" {
+		update["$addToSet"] = bson.M{prefix + "sources": source}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if len(filters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: filters})
+	}
+	filter := bson.M{"domain": asset.Domain}
+	after := bson.M{}
+	err = coll.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&after)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, filter, nil, nil, 0, err)
+		return false, fmt.Errorf("[-] Error recording asset: %v", err)
+	}
+
+	// the update increments the count once per sighting so only the first one reads 1
+	node, found := findAssetNode(after, asset)
+	if !found {
+		return false, nil
+	}
+	seen := seenAsset(enumAsset{asset, node})
+	first := seen.SeenCount == 1
+	operation := mytypes.AuditUpdate
+	if first {
+		operation = mytypes.AuditInsert
+	}
+	auditOperation(context.TODO(), client, operation, EnumDatabase, target, filter, nil, bson.M{"_id": after["_id"], "asset": asset.Type + " " + assetKey(asset), "seen_count": seen.SeenCount, "last_seen": seen.LastSeen, "source": source}, 1, nil)
+
+	return first, nil
+}
+
+// function seenAsset to read the provenance fields of a node of the enum doc tree
+func seenAsset(asset enumAsset) mytypes.SeenAsset {
+	seen := mytypes.SeenAsset{TargetAsset: asset.TargetAsset, Sources: []mytypes.AssetSource{}}
+	if asset.node == nil {
+		return seen
+	}
+	if t, ok := asset.node["first_seen"].(primitive.DateTime); ok {
+		first := t.Time().UTC()
+		seen.FirstSeen = &first
+	}
+	if t, ok := asset.node["last_seen"].(primitive.DateTime); ok {
+		last := t.Time().UTC()
+		seen.LastSeen = &last
+	}
+	switch count := asset.node["seen_count"].(type) {
+	case int32:
+		seen.SeenCount = int64(count)
+	case int64:
+		seen.SeenCount = count
+	case float64:
+		seen.SeenCount = int64(count)
+	}
+	for _, value := range toA(asset.node["sources"]) {
+		if doc, ok := toM(value); ok {
+			name, _ := doc["source"].(string)
+			run, _ := doc["run"].(string)
+			seen.Sources = append(seen.Sources, mytypes.AssetSource{Source: name, Run: run})
+		}
+	}
+	return seen
+}
+
+// function seenMatches to check an asset against the provenance query, assets without a time never match a time condition
+func seenMatches(query mytypes.SeenQuery, seen mytypes.SeenAsset) bool {
+	if query.Type != "" && seen.Type != query.Type {
+		return false
+	}
+	if !query.FirstSeenAfter.IsZero() && (seen.FirstSeen == nil || seen.FirstSeen.Before(query.FirstSeenAfter)) {
+		return false
+	}
+	if !query.FirstSeenBefore.IsZero() && (seen.FirstSeen == nil || !seen.FirstSeen.Before(query.FirstSeenBefore)) {
+		return false
+	}
+	if !query.LastSeenAfter.IsZero() && (seen.LastSeen == nil || seen.LastSeen.Before(query.LastSeenAfter)) {
+		return false
+	}
+	if !query.LastSeenBefore.IsZero() && (seen.LastSeen == nil || !seen.LastSeen.Before(query.LastSeenBefore)) {
+		return false
+	}
+	if query.Source != "" {
+		for _, source := range seen.Sources {
+			if source.Source == query.Source {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// function seenFilter to narrow the domain documents on the server for the levels whose fields are at a fixed path (domains and subdomains), the nodes are checked again one by one
+func seenFilter(query mytypes.SeenQuery) bson.M {
+	conds := bson.M{}
+	timerange := func(field string, after time.Time, before time.Time) {
+		r := bson.M{}
+		if !after.IsZero() {
+			r["$gte"] = after
+		}
+		if !before.IsZero() {
+			r["$lt"] = before
+		}
+		if len(r) > 0 {
+			conds[field] = r
+		}
+	}
+	timerange("first_seen", query.FirstSeenAfter, query.FirstSeenBefore)
+	timerange("last_seen", query.LastSeenAfter, query.LastSeenBefore)
+	if query.Source != "" {
+		conds["sources.source"] = query.Source
+	}
+
+	filter := bson.M{"domain": bson.M{"$exists": true}}
+	switch query.Type {
+	case mytypes.AssetDomain:
+		for k, v := range conds {
+			filter[k] = v
+		}
+	case mytypes.AssetSubdomain:
+		if len(conds) > 0 {
+			filter["subdomains"] = bson.M{"$elemMatch": conds}
+		}
+	}
+	return filter
+}
+
+// function FindSeenAssets to get the assets of a target matching a provenance query, e.g. the subdomains first seen in the last 24 hours or the assets not seen for 30 days, returns the assets in the order of the enum doc tree and an error
+func FindSeenAssets(client *mongo.Client, target string, query mytypes.SeenQuery) ([]mytypes.SeenAsset, error) {
+	cursor, err := client.Database(EnumDatabase).Collection(target).Find(context.TODO(), seenFilter(query), options.Find().SetSort(bson.D{{Key: "domain", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error finding assets: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	assets := []mytypes.SeenAsset{}
+	for cursor.Next(context.TODO()) {
+		doc := bson.M{}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding assets: %v", err)
+		}
+		for _, node := range enumAssets([]bson.M{doc}) {
+			seen := seenAsset(node)
+			if !seenMatches(query, seen) {
+				continue
+			}
+			assets = append(assets, seen)
+			if query.Limit > 0 && len(assets) >= query.Limit {
+				return assets, nil
+			}
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, fmt.Errorf("[-] Error finding assets: %v", err)
+	}
+
+	return assets, nil
+}
+
+// function backfillNode to give a node of the enum doc tree and all its children the provenance fields they miss, plain string children become documents, returns the node and whether it changed
+func backfillNode(node bson.D, at time.Time) (bson.D, bool) {
+	changed := false
+	defaults := bson.D{
+		{Key: "first_seen", Value: at},
+		{Key: "last_seen", Value: at},
+		{Key: "seen_count", Value: int64(1)},
+		{Key: "sources", Value: bson.A{}},
+	}
+	for _, e := range defaults {
+		if _, ok := dGet(node, e.Key); !ok {
+			node = append(node, e)
+			changed = true
+		}
+	}
+	for i, e := range node {
+		fields, child := enumChildNames[e.Key]
+		if !child {
+			continue
+		}
+		children := bson.A{}
+		for _, value := range toA(e.Value) {
+			if name, ok := value.(string); ok {
+				value = bson.D{{Key: fields[0], Value: name}}
+				changed = true
+			}
+			if doc, ok := toD(value); ok {
+				var childchanged bool
+				value, childchanged = backfillNode(doc, at)
+				changed = changed || childchanged
+			}
+			children = append(children, value)
+		}
+		node[i].Value = children
+	}
+	return node, changed
+}
+
+// function stampProvenance to give the nodes of a domain document that miss them the provenance fields of an asset seen now, so imported assets are seen like the ones SeeAsset records, other documents are returned as they are
+func stampProvenance(doc bson.D) bson.D {
+	if _, ok := dGet(doc, "domain"); !ok {
+		return doc
+	}
+	doc, _ = backfillNode(doc, time.Now().UTC())
+	return doc
+}
+
+// function backfillProvenance to run the provenance backfill on the enum collection of a target, each domain document is replaced only if it didn't change since it was read, returns the number of changed documents and an error
+func backfillProvenance(ctx context.Context, client *mongo.Client, target string) (int64, error) {
+	coll := client.Database(EnumDatabase).Collection(target)
+	ids, err := coll.Distinct(ctx, "_id", bson.M{"domain": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+
+	var changed int64
+	for _, id := range ids {
+		for attempt := 0; ; attempt++ {
+			if attempt == importRetries {
+				return changed, fmt.Errorf("document %v kept changing during the backfill", id)
+			}
+			raw, err := coll.FindOne(ctx, bson.M{"_id": id}).DecodeBytes()
+			if err == mongo.ErrNoDocuments {
+				break
+			}
+			if err != nil {
+				return changed, err
+			}
+			// the document is decoded twice, the backfill changes nested nodes in place and the current one is the guard
+			current, copied := bson.D{}, bson.D{}
+			err = bson.Unmarshal(raw, &current)
+			if err == nil {
+				err = bson.Unmarshal(raw, &copied)
+			}
+			if err != nil {
+				return changed, err
+			}
+			at := time.Now().UTC()
+			if objectid, ok := id.(primitive.ObjectID); ok {
+				at = objectid.Timestamp().UTC()
+			}
+			backfilled, modified := backfillNode(copied, at)
+			if !modified {
+				break
+			}
+			guard := bson.D{
+				{Key: "_id", Value: id},
+				{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$$ROOT", bson.D{{Key: "$literal", Value: current}}}}}},
+			}
+			result, err := coll.ReplaceOne(ctx, guard, backfilled)
+			if err != nil {
+				return changed, err
+			}
+			if result.MatchedCount == 1 {
+				changed++
+				break
+			}
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,132 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testClient connects to the server in HEALERDB_TEST_MONGO, the test is skipped without one
+func testClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv("HEALERDB_TEST_MONGO")
+	if uri == "" {
+		t.Skip("HEALERDB_TEST_MONGO is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err == nil {
+		err = client.Ping(ctx, nil)
+	}
+	if err != nil {
+		t.Skipf("mongodb at %s isn't reachable: %v", uri, err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+// decodeM converts an ordered document the way it comes back from the server
+func decodeM(t *testing.T, doc bson.D) bson.M {
+	t.Helper()
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := bson.M{}
+	err = bson.Unmarshal(raw, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestStampProvenanceGivesEveryNewNodeProvenance(t *testing.T) {
+	asset := mytypes.TargetAsset{Type: mytypes.AssetParameter, Domain: "example.com", Subdomain: "api.example.com", Path: "/v1/users", Parameter: "id"}
+	doc, err := assetDocument(asset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now().UTC().Add(-time.Second)
+
+	nodes := enumAssets([]bson.M{decodeM(t, stampProvenance(doc))})
+	if len(nodes) < 4 {
+		t.Fatalf("got %d nodes, want the domain, subdomain, directory and parameter", len(nodes))
+	}
+	for _, node := range nodes {
+		seen := seenAsset(node)
+		if seen.SeenCount != 1 || seen.FirstSeen == nil || seen.LastSeen == nil || seen.FirstSeen.Before(before) {
+			t.Errorf("%s %s: seen = %+v", node.Type, assetKey(node.TargetAsset), seen)
+		}
+	}
+}
+
+func TestStampProvenanceKeepsExistingValues(t *testing.T) {
+	first := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	doc := bson.D{
+		{Key: "domain", Value: "example.com"},
+		{Key: "first_seen", Value: first},
+		{Key: "seen_count", Value: int64(7)},
+	}
+	seen := seenAsset(enumAsset{mytypes.TargetAsset{Type: mytypes.AssetDomain, Domain: "example.com"}, decodeM(t, stampProvenance(doc))})
+	if seen.SeenCount != 7 || !seen.FirstSeen.Equal(first) {
+		t.Errorf("existing provenance was overwritten: %+v", seen)
+	}
+
+	marker := stampProvenance(bson.D{{Key: "exists", Value: true}})
+	if len(marker) != 1 {
+		t.Errorf("a document that isn't a domain document got provenance: %v", marker)
+	}
+}
+
+func TestImportThenSeeAsset(t *testing.T) {
+	client := testClient(t)
+	target := fmt.Sprintf("test_provenance_%d", time.Now().UnixNano())
+	t.Cleanup(func() { client.Database(EnumDatabase).Collection(target).Drop(context.Background()) })
+
+	_, err := AddDomain(client, EnumDatabase, target, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().UTC().Add(-time.Second)
+	_, err = ImportTarget(client, target, mytypes.TargetFormatSubdomains, strings.NewReader("api.example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the imported subdomain was seen by the import
+	assets, err := FindSeenAssets(client, target, mytypes.SeenQuery{Type: mytypes.AssetSubdomain, FirstSeenAfter: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assets) != 1 || assets[0].Subdomain != "api.example.com" || assets[0].SeenCount != 1 {
+		t.Fatalf("imported subdomains seen since the import = %+v", assets)
+	}
+
+	imported := mytypes.TargetAsset{Type: mytypes.AssetSubdomain, Domain: "example.com", Subdomain: "api.example.com"}
+	first, err := SeeAsset(client, target, imported, mytypes.AssetSource{Source: "subfinder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first {
+		t.Errorf("an imported subdomain was reported as seen for the first time")
+	}
+
+	fresh := mytypes.TargetAsset{Type: mytypes.AssetSubdomain, Domain: "example.com", Subdomain: "www.example.com"}
+	first, err = SeeAsset(client, target, fresh, mytypes.AssetSource{Source: "subfinder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first {
+		t.Errorf("a new subdomain wasn't reported as seen for the first time")
+	}
+}
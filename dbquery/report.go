@@ -0,0 +1,370 @@
+package dbquery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Report                   ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// names of the databases used by the report functions, both are target based so the collection is the target name
+var (
+	ReportDatabase = "report"
+	VulnDatabase   = "vuln"
+)
+
+// report output formats
+const (
+	ReportFormatMarkdown = "markdown"
+	ReportFormatHTML     = "html"
+)
+
+// built-in report templates, keyed by template name and then by format
+var reportTemplates = map[string]map[string]string{
+	"hackerone": {
+		ReportFormatMarkdown: `## Summary
+{{.Summary}}
+{{range .Vulns}}
+## {{.Title}}
+
+**Severity:** {{.Severity}}
+**Weakness:** {{.Weakness}}
+**Asset:** {{.Asset}}{{if .URL}} ({{.URL}}){{end}}
+
+### Description
+{{.Description}}
+
+## Steps To Reproduce:
+{{range $i, $s := .Steps}}  {{inc $i}}. {{$s}}
+{{end}}
+## Impact
+{{.Impact}}
+{{if .Remediation}}
+## Remediation
+{{.Remediation}}
+{{end}}{{if .References}}
+## Supporting Material/References:
+{{range .References}}  * {{.}}
+{{end}}{{end}}{{end}}`,
+		ReportFormatHTML: `<h2>Summary</h2>
+<p>{{.Summary}}</p>
+{{range .Vulns}}<h2>{{.Title}}</h2>
+<p><strong>Severity:</strong> {{.Severity}}<br>
+<strong>Weakness:</strong> {{.Weakness}}<br>
+<strong>Asset:</strong> {{.Asset}}{{if .URL}} ({{.URL}}){{end}}</p>
+<h3>Description</h3>
+<p>{{.Description}}</p>
+<h2>Steps To Reproduce:</h2>
+<ol>
+{{range .Steps}}<li>{{.}}</li>
+{{end}}</ol>
+<h2>Impact</h2>
+<p>{{.Impact}}</p>
+{{if .Remediation}}<h2>Remediation</h2>
+<p>{{.Remediation}}</p>
+{{end}}{{if .References}}<h2>Supporting Material/References:</h2>
+<ul>
+{{range .References}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{end}}`,
+	},
+	"bugcrowd": {
+		ReportFormatMarkdown: `# {{.Title}}
+{{range .Vulns}}
+**Summary Title:** {{.Title}}
+**Target:** {{.Asset}}
+**VRT:** {{.Weakness}} ({{.Severity}})
+**Vulnerability URL:** {{.URL}}
+
+**Description:**
+{{.Description}}
+
+**Proof of Concept:**
+{{range $i, $s := .Steps}}{{inc $i}}. {{$s}}
+{{end}}
+**Demonstrated Impact:**
+{{.Impact}}
+{{if .Remediation}}
+**Suggested Fix:**
+{{.Remediation}}
+{{end}}{{end}}`,
+		ReportFormatHTML: `<h1>{{.Title}}</h1>
+{{range .Vulns}}<p><strong>Summary Title:</strong> {{.Title}}<br>
+<strong>Target:</strong> {{.Asset}}<br>
+<strong>VRT:</strong> {{.Weakness}} ({{.Severity}})<br>
+<strong>Vulnerability URL:</strong> {{.URL}}</p>
+<p><strong>Description:</strong></p>
+<p>{{.Description}}</p>
+<p><strong>Proof of Concept:</strong></p>
+<ol>
+{{range .Steps}}<li>{{.}}</li>
+{{end}}</ol>
+<p><strong>Demonstrated Impact:</strong></p>
+<p>{{.Impact}}</p>
+{{if .Remediation}}<p><strong>Suggested Fix:</strong></p>
+<p>{{.Remediation}}</p>
+{{end}}{{end}}`,
+	},
+}
+
+// template functions available to every report template
+var reportTemplateFuncs = template.FuncMap{
+	"inc":   func(i int) int { return i + 1 },
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"join":  strings.Join,
+}
+
+// function GetReportTemplates to get the names of the built-in report templates, returns a slice of strings
+func GetReportTemplates() []string {
+	names := []string{}
+	for name := range reportTemplates {
+		names = append(names, name)
+	}
+	return names
+}
+
+// function GetVulns to get the vulns of a target from the vuln database matching the given filter, returns a slice of vulns and an error
+func GetVulns(client *mongo.Client, target string, filter bson.M) ([]mytypes.ReportVuln, error) {
+	cursor, err := client.Database(VulnDatabase).Collection(target).Find(context.TODO(), skipMarker(filter))
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting vulns: %v", err)
+	}
+	vulns := []mytypes.ReportVuln{}
+	err = cursor.All(context.TODO(), &vulns)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding vulns: %v", err)
+	}
+
+	return vulns, nil
+}
+
+// function CreateReport to create a report for a target, picking the vulns that match the given filter from the vuln database, returns the id of the report and an error
+func CreateReport(client *mongo.Client, target string, title string, summary string, filter bson.M) (string, error) {
+	vulns, err := GetVulns(client, target, filter)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating report: %v", err)
+	}
+	if len(vulns) == 0 {
+		return "", fmt.Errorf("[-] Error creating report: no vulns matched the filter")
+	}
+
+	vulnids := []primitive.ObjectID{}
+	for _, vuln := range vulns {
+		vulnids = append(vulnids, vuln.ID)
+	}
+	now := time.Now().UTC()
+	report := mytypes.Report{
+		Target:    target,
+		Title:     title,
+		Summary:   summary,
+		VulnIDs:   vulnids,
+		Versions:  []mytypes.ReportVersion{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	result, err := client.Database(ReportDatabase).Collection(target).InsertOne(context.TODO(), report)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, ReportDatabase, target, nil, nil, nil, 0, err)
+		return "", fmt.Errorf("[-] Error creating report: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, ReportDatabase, target, nil, nil, bson.M{"_id": result.InsertedID, "title": title, "summary": summary, "vuln_ids": vulnids}, 1, nil)
+	fmt.Println("[+] Created report successfully")
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// function GetReport to get the report with the given id of a target, returns a pointer to the report and an error
+func GetReport(client *mongo.Client, target string, id string) (*mytypes.Report, error) {
+	objectid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error converting id to object id: %v", err)
+	}
+
+	report := &mytypes.Report{}
+	err = client.Database(ReportDatabase).Collection(target).FindOne(context.TODO(), bson.M{"_id": objectid}).Decode(report)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting report: %v", err)
+	}
+
+	return report, nil
+}
+
+// function GetReports to get all the reports of a target, returns a slice of reports and an error
+func GetReports(client *mongo.Client, target string) ([]mytypes.Report, error) {
+	cursor, err := client.Database(ReportDatabase).Collection(target).Find(context.TODO(), skipMarker(nil))
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting reports: %v", err)
+	}
+	reports := []mytypes.Report{}
+	err = cursor.All(context.TODO(), &reports)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding reports: %v", err)
+	}
+
+	return reports, nil
+}
+
+// function RenderReportText to render the given report data with the given template text, the html format goes through html/template so the vuln fields are escaped for their context, returns the rendered string and an error
+func RenderReportText(format string, tmpltext string, data mytypes.ReportData) (string, error) {
+	var tmpl interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+	var err error
+	if format == ReportFormatHTML {
+		tmpl, err = htmltemplate.New("report").Funcs(htmltemplate.FuncMap(reportTemplateFuncs)).Parse(tmpltext)
+	} else {
+		tmpl, err = template.New("report").Funcs(reportTemplateFuncs).Parse(tmpltext)
+	}
+	if err != nil {
+		return "", fmt.Errorf("[-] Error parsing report template: %v", err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error executing report template: %v", err)
+	}
+
+	return buf.String(), nil
+}
+
+// function RenderReport to render the report with the given id using one of the built-in templates (e.g. hackerone, bugcrowd) in the given format, the rendered version is stored in the report, returns the rendered version and an error
+func RenderReport(client *mongo.Client, target string, id string, tmplname string, format string) (*mytypes.ReportVersion, error) {
+	formats, ok := reportTemplates[tmplname]
+	if !ok {
+		return nil, fmt.Errorf("[-] Error rendering report: unknown template %q", tmplname)
+	}
+	tmpltext, ok := formats[format]
+	if !ok {
+		return nil, fmt.Errorf("[-] Error rendering report: template %q has no %q format", tmplname, format)
+	}
+
+	return RenderReportWithTemplate(client, target, id, tmplname, format, tmpltext)
+}
+
+// function RenderReportWithTemplate to render the report with the given id using a custom template text, the rendered version is stored in the report under the given template name, returns the rendered version and an error
+func RenderReportWithTemplate(client *mongo.Client, target string, id string, tmplname string, format string, tmpltext string) (*mytypes.ReportVersion, error) {
+	if format != ReportFormatMarkdown && format != ReportFormatHTML {
+		return nil, fmt.Errorf("[-] Error rendering report: unknown format %q", format)
+	}
+	report, err := GetReport(client, target, id)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error rendering report: %v", err)
+	}
+
+	// fetch the vulns again so the rendered version reflects their current state
+	vulns, err := GetVulns(client, target, bson.M{"_id": bson.M{"$in": report.VulnIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error rendering report: %v", err)
+	}
+
+	now := time.Now().UTC()
+	content, err := RenderReportText(format, tmpltext, mytypes.ReportData{
+		Target:     target,
+		Title:      report.Title,
+		Summary:    report.Summary,
+		Vulns:      vulns,
+		RenderedAt: now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error rendering report: %v", err)
+	}
+
+	version := mytypes.ReportVersion{
+		Version:     len(report.Versions) + 1,
+		Template:    tmplname,
+		Format:      format,
+		Content:     content,
+		ContentHash: myutils.HashString(content),
+		RenderedAt:  now,
+	}
+
+	// only push the version if nobody else added one in the meantime, otherwise the version numbers would collide
+	filter := bson.M{"_id": report.ID, "versions": bson.M{"$size": len(report.Versions)}}
+	update := bson.M{
+		"$push": bson.M{"versions": version},
+		"$set":  bson.M{"updated_at": now},
+	}
+	result, err := client.Database(ReportDatabase).Collection(target).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, ReportDatabase, target, filter, nil, nil, 0, err)
+		return nil, fmt.Errorf("[-] Error storing report version: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, fmt.Errorf("[-] Error storing report version: report was modified concurrently")
+	}
+	// the content itself is left out of the record, the hash identifies it
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, ReportDatabase, target, filter, nil, bson.M{"_id": report.ID, "version": version.Version, "template": tmplname, "format": format, "content_hash": version.ContentHash}, result.ModifiedCount, nil)
+	fmt.Println("[+] Rendered report successfully")
+
+	return &version, nil
+}
+
+// function MarkReportSubmitted to mark a rendered version of a report as submitted to the given platform, returns an error
+func MarkReportSubmitted(client *mongo.Client, target string, id string, version int, platform string) error {
+	objectid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("[-] Error converting id to object id: %v", err)
+	}
+
+	now := time.Now().UTC()
+	filter := bson.M{"_id": objectid, "versions.version": version}
+	update := bson.M{"$set": bson.M{
+		"versions.$.submitted":    true,
+		"versions.$.submitted_at": now,
+		"versions.$.submitted_to": platform,
+		"updated_at":              now,
+	}}
+	result, err := client.Database(ReportDatabase).Collection(target).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, ReportDatabase, target, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error marking report as submitted: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error marking report as submitted: report or version doesn't exist")
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, ReportDatabase, target, filter, nil, bson.M{"_id": objectid, "version": version, "submitted": true, "submitted_to": platform}, result.ModifiedCount, nil)
+	fmt.Println("[+] Marked report as submitted successfully")
+
+	return nil
+}
+
+// function GetSubmittedReportVersions to get all the submitted versions of a report, returns a slice of versions and an error
+func GetSubmittedReportVersions(client *mongo.Client, target string, id string) ([]mytypes.ReportVersion, error) {
+	report, err := GetReport(client, target, id)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting submitted versions: %v", err)
+	}
+	versions := []mytypes.ReportVersion{}
+	for _, version := range report.Versions {
+		if version.Submitted {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
+// function DeleteReport to delete the report with the given id of a target, returns an error
+func DeleteReport(client *mongo.Client, target string, id string) error {
+	return DeleteDocument(client, ReportDatabase, target, id)
+}
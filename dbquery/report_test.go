@@ -0,0 +1,49 @@
+package dbquery
+
+import (
+	"strings"
+	"testing"
+
+	"healerdb/mytypes"
+)
+
+func TestRenderReportTextEscapesHTML(t *testing.T) {
+	payload := `<script>alert(1)</script>`
+	data := mytypes.ReportData{
+		Title:   payload,
+		Summary: payload,
+		Vulns: []mytypes.ReportVuln{{
+			Title:       payload,
+			URL:         "https://example.com/?q=" + payload,
+			Description: payload,
+			Steps:       []string{payload},
+			Impact:      payload,
+			References:  []string{payload},
+		}},
+	}
+	for name, formats := range reportTemplates {
+		html, err := RenderReportText(ReportFormatHTML, formats[ReportFormatHTML], data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if strings.Contains(html, "<script>") {
+			t.Errorf("%s: html report isn't escaped:\n%s", name, html)
+		}
+		if strings.Contains(html, "&amp;lt;") {
+			t.Errorf("%s: html report is escaped twice:\n%s", name, html)
+		}
+
+		markdown, err := RenderReportText(ReportFormatMarkdown, formats[ReportFormatMarkdown], data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.Contains(markdown, payload) {
+			t.Errorf("%s: markdown report was escaped:\n%s", name, markdown)
+		}
+	}
+
+	_, err := RenderReportText(ReportFormatHTML, `<a href="{{.Title}}">{{inc 1}}</a>`, data)
+	if err != nil {
+		t.Errorf("the template functions aren't available to html templates: %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Schedule                 ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the schedule database, it is target based so the collection is the target name
+var ScheduleDatabase = "schedule"
+
+// default grace period for the skip policy, a run later than this is considered missed
+const defaultJobGraceSeconds = 60
+
+// function jobNextRun to compute the next run of a job strictly after the given time, using the job's cron expression and timezone, returns the time in UTC and an error
+func jobNextRun(job *mytypes.ScheduledJob, after time.Time) (time.Time, error) {
+	sched, err := myutils.ParseCron(job.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc := time.UTC
+	if job.Timezone != "" {
+		loc, err = time.LoadLocation(job.Timezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("[-] Error loading timezone: %v", err)
+		}
+	}
+	next := sched.Next(after.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("[-] Error computing next run: %q never matches", job.Cron)
+	}
+
+	return next.UTC(), nil
+}
+
+// function AddScheduledJob to add a recurring job to a target, the job name is unique per target, returns the id of the job and an error
+func AddScheduledJob(client *mongo.Client, job mytypes.ScheduledJob) (string, error) {
+	if job.Name == "" || job.Target == "" {
+		return "", fmt.Errorf("[-] Error adding job: name and target are required")
+	}
+	if job.MissedPolicy == "" {
+		job.MissedPolicy = mytypes.MissedRunOnce
+	}
+	if job.MissedPolicy != mytypes.MissedRunOnce && job.MissedPolicy != mytypes.MissedRunAll && job.MissedPolicy != mytypes.MissedSkip {
+		return "", fmt.Errorf("[-] Error adding job: unknown missed-run policy %q", job.MissedPolicy)
+	}
+	now := time.Now().UTC()
+	next, err := jobNextRun(&job, now)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error adding job: %v", err)
+	}
+	job.ID = primitive.NilObjectID
+	job.NextRun = next
+	job.LockedBy = ""
+	job.LockedUntil = time.Time{}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	err = ensureIndexes(client, ScheduleDatabase, job.Target)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error adding job: %v", err)
+	}
+	result, err := client.Database(ScheduleDatabase).Collection(job.Target).InsertOne(context.TODO(), job)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error adding job: %v", err)
+	}
+	after := auditDocument(job)
+	after["_id"] = result.InsertedID
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, ScheduleDatabase, job.Target, nil, nil, after, 1, nil)
+	fmt.Println("[+] Added scheduled job successfully")
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// function GetScheduledJob to get the job with the given name of a target, returns a pointer to the job and an error
+func GetScheduledJob(client *mongo.Client, target string, name string) (*mytypes.ScheduledJob, error) {
+	job := &mytypes.ScheduledJob{}
+	err := client.Database(ScheduleDatabase).Collection(target).FindOne(context.TODO(), bson.M{"name": name}).Decode(job)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting job: %v", err)
+	}
+
+	return job, nil
+}
+
+// function GetScheduledJobs to get all the jobs of a target ordered by their next run, returns a slice of jobs and an error
+func GetScheduledJobs(client *mongo.Client, target string) ([]mytypes.ScheduledJob, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "next_run", Value: 1}})
+	cursor, err := client.Database(ScheduleDatabase).Collection(target).Find(context.TODO(), skipMarker(nil), opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting jobs: %v", err)
+	}
+	jobs := []mytypes.ScheduledJob{}
+	err = cursor.All(context.TODO(), &jobs)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// function UpdateJobSchedule to change the cron expression and timezone of a job, the next run is recomputed from now, returns an error
+func UpdateJobSchedule(client *mongo.Client, target string, name string, cron string, timezone string) error {
+	job := &mytypes.ScheduledJob{Cron: cron, Timezone: timezone}
+	now := time.Now().UTC()
+	next, err := jobNextRun(job, now)
+	if err != nil {
+		return fmt.Errorf("[-] Error updating job schedule: %v", err)
+	}
+	set := bson.M{"cron": cron, "timezone": timezone, "next_run": next, "updated_at": now}
+	before, err := updateOneAudited(context.TODO(), client, ScheduleDatabase, target, bson.M{"name": name}, set, nil)
+	if err != nil {
+		return fmt.Errorf("[-] Error updating job schedule: %v", err)
+	}
+	if before == nil {
+		return fmt.Errorf("[-] Error updating job schedule: job doesn't exist")
+	}
+	fmt.Println("[+] Updated job schedule successfully")
+
+	return nil
+}
+
+// function SetJobEnabled to enable or disable a job, enabling recomputes the next run from now so the runs missed while disabled are not replayed, returns an error
+func SetJobEnabled(client *mongo.Client, target string, name string, enabled bool) error {
+	now := time.Now().UTC()
+	set := bson.M{"enabled": enabled, "updated_at": now}
+	if enabled {
+		job, err := GetScheduledJob(client, target, name)
+		if err != nil {
+			return fmt.Errorf("[-] Error enabling job: %v", err)
+		}
+		next, err := jobNextRun(job, now)
+		if err != nil {
+			return fmt.Errorf("[-] Error enabling job: %v", err)
+		}
+		set["next_run"] = next
+	}
+	before, err := updateOneAudited(context.TODO(), client, ScheduleDatabase, target, bson.M{"name": name}, set, nil)
+	if err != nil {
+		return fmt.Errorf("[-] Error setting job state: %v", err)
+	}
+	if before == nil {
+		return fmt.Errorf("[-] Error setting job state: job doesn't exist")
+	}
+	fmt.Println("[+] Set job state successfully")
+
+	return nil
+}
+
+// function DeleteScheduledJob to delete the job with the given name of a target, returns an error
+func DeleteScheduledJob(client *mongo.Client, target string, name string) error {
+	filter := bson.M{"name": name}
+	before := bson.M{}
+	err := client.Database(ScheduleDatabase).Collection(target).FindOneAndDelete(context.TODO(), filter).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("[-] Error deleting job: job doesn't exist")
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error deleting job: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditDelete, ScheduleDatabase, target, filter, before, nil, 1, nil)
+	fmt.Println("[+] Deleted job successfully")
+
+	return nil
+}
+
+// function ClaimDueJob to claim the next due job of a target for the given worker, the claim is a lease taken with an atomic FindOneAndUpdate so only one worker runs a due job, next_run is only advanced by FinishJobRun so a run whose worker died is claimed again once its lease expires, the missed-run policy is applied while claiming, returns the claimed job (nil if nothing is due) and an error
+func ClaimDueJob(client *mongo.Client, target string, worker string, lease time.Duration) (*mytypes.ScheduledJob, error) {
+	coll := client.Database(ScheduleDatabase).Collection(target)
+	for {
+		now := time.Now().UTC()
+		due := bson.M{"enabled": true, "next_run": bson.M{"$lte": now}, "locked_until": bson.M{"$lte": now}}
+		candidate := &mytypes.ScheduledJob{}
+		err := coll.FindOne(context.TODO(), due, options.FindOne().SetSort(bson.D{{Key: "next_run", Value: 1}})).Decode(candidate)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error claiming job: %v", err)
+		}
+
+		// the candidate is only taken if nobody claimed or rescheduled it since we read it
+		filter := bson.M{"_id": candidate.ID, "enabled": true, "next_run": candidate.NextRun, "locked_until": bson.M{"$lte": now}}
+		grace := candidate.GraceSeconds
+		if grace <= 0 {
+			grace = defaultJobGraceSeconds
+		}
+		// a run interrupted by an expired lease isn't missed, it is run again
+		if candidate.MissedPolicy == mytypes.MissedSkip && candidate.RunningFor == nil && now.Sub(candidate.NextRun) > time.Duration(grace)*time.Second {
+			next, err := jobNextRun(candidate, now)
+			if err != nil {
+				return nil, fmt.Errorf("[-] Error claiming job: %v", err)
+			}
+			set := bson.M{"next_run": next, "last_status": mytypes.JobMissed, "updated_at": now}
+			_, err = updateOneAudited(context.TODO(), client, ScheduleDatabase, target, filter, set, nil)
+			if err != nil {
+				return nil, fmt.Errorf("[-] Error skipping missed job: %v", err)
+			}
+			fmt.Println("[+] Skipped missed run of job " + candidate.Name)
+			continue
+		}
+
+		scheduled := candidate.NextRun
+		update := bson.M{"$set": bson.M{
+			"locked_by":      worker,
+			"locked_until":   now.Add(lease),
+			"running_for":    scheduled,
+			"run_started_at": now,
+			"last_status":    mytypes.JobRunning,
+			"updated_at":     now,
+		}}
+		job := &mytypes.ScheduledJob{}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = coll.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(job)
+		if err == mongo.ErrNoDocuments {
+			// another worker won the race, look for the next due job
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error claiming job: %v", err)
+		}
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, ScheduleDatabase, target, filter, bson.M{"_id": job.ID, "locked_by": candidate.LockedBy, "last_status": candidate.LastStatus}, bson.M{"_id": job.ID, "running_for": scheduled, "locked_by": worker, "last_status": job.LastStatus}, 1, nil)
+		fmt.Println("[+] Claimed job " + job.Name + " successfully")
+
+		return job, nil
+	}
+}
+
+// function ClaimAnyDueJob to claim the next due job of any target in the schedule database, returns the claimed job (nil if nothing is due) and an error
+func ClaimAnyDueJob(client *mongo.Client, worker string, lease time.Duration) (*mytypes.ScheduledJob, error) {
+	targets, err := GetCollections(client, ScheduleDatabase)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error claiming job: %v", err)
+	}
+	for _, target := range targets {
+		if target == "exists" {
+			continue
+		}
+		job, err := ClaimDueJob(client, target, worker, lease)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			return job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// function ExtendJobLease to extend the lease of a job the worker is still running, returns an error if the worker lost the lease
+func ExtendJobLease(client *mongo.Client, target string, id primitive.ObjectID, worker string, lease time.Duration) error {
+	filter := bson.M{"_id": id, "locked_by": worker}
+	update := bson.M{"$set": bson.M{"locked_until": time.Now().UTC().Add(lease)}}
+	result, err := client.Database(ScheduleDatabase).Collection(target).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return fmt.Errorf("[-] Error extending job lease: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error extending job lease: lease is not held by %s", worker)
+	}
+
+	return nil
+}
+
+// function FinishJobRun to record the result of a run, advance the next run past it following the missed-run policy and release the lease, a next run changed while the job ran (UpdateJobSchedule, SetJobEnabled) is kept, status is JobSucceeded or JobFailed, returns an error if the worker lost the lease
+func FinishJobRun(client *mongo.Client, target string, id primitive.ObjectID, worker string, status string, errmsg string) error {
+	coll := client.Database(ScheduleDatabase).Collection(target)
+	job := &mytypes.ScheduledJob{}
+	err := coll.FindOne(context.TODO(), bson.M{"_id": id, "locked_by": worker}).Decode(job)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("[-] Error finishing job run: lease is not held by %s", worker)
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error finishing job run: %v", err)
+	}
+
+	now := time.Now().UTC()
+	scheduled, after := job.NextRun, now
+	if job.RunningFor != nil {
+		scheduled = *job.RunningFor
+	}
+	if job.RunStartedAt != nil {
+		after = *job.RunStartedAt
+	}
+	if job.MissedPolicy == mytypes.MissedRunAll {
+		after = scheduled
+	}
+	next, err := jobNextRun(job, after)
+	if err != nil {
+		return fmt.Errorf("[-] Error finishing job run: %v", err)
+	}
+	// a pipeline update so next_run is only advanced if it still is the run that was claimed, values are literals since a string starting with $ would be read as a field path
+	set := bson.M{
+		"last_run":     now,
+		"last_status":  bson.M{"$literal": status},
+		"last_error":   bson.M{"$literal": errmsg},
+		"locked_until": time.Time{},
+		"updated_at":   now,
+		"next_run":     bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$next_run", scheduled}}, next, "$next_run"}},
+		"run_count":    bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$run_count", 0}}, 1}},
+	}
+	if job.RunStartedAt != nil {
+		set["last_duration"] = now.Sub(*job.RunStartedAt).Seconds()
+	}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: set}},
+		{{Key: "$unset", Value: bson.A{"locked_by", "running_for", "run_started_at"}}},
+	}
+	filter := bson.M{"_id": id, "locked_by": worker}
+	result, err := coll.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, ScheduleDatabase, target, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error finishing job run: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error finishing job run: lease is not held by %s", worker)
+	}
+	advanced := job.NextRun
+	if advanced.Equal(scheduled) {
+		advanced = next
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, ScheduleDatabase, target, filter, bson.M{"_id": id, "locked_by": worker, "last_status": job.LastStatus, "next_run": job.NextRun}, bson.M{"_id": id, "last_status": status, "last_error": errmsg, "next_run": advanced}, 1, nil)
+	fmt.Println("[+] Finished job run successfully")
+
+	return nil
+}
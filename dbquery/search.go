@@ -0,0 +1,359 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Search                   ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// default and maximum number of results of a search page
+var (
+	SearchPageSize    = 50
+	SearchMaxPageSize = 1000
+)
+
+// searchPattern is a compiled host or path pattern, prefix and suffix are the literal text every match starts and ends with, used to narrow the database queries on indexed fields
+type searchPattern struct {
+	re     *regexp.Regexp
+	expr   string
+	fold   bool
+	prefix string
+	suffix string
+}
+
+// function globExpr to translate a glob (* any text, ? one character, [...] a class, [!...] a negated one) into an anchored regular expression
+func globExpr(glob string) string {
+	expr := "^"
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr += ".*"
+		case '?':
+			expr += "."
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr += regexp.QuoteMeta(glob[i:])
+				i = len(glob)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr += "[" + strings.ReplaceAll(class, `\`, `\\`) + "]"
+			i += end + 1
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	return expr + "$"
+}
+
+// function regexPrefix to get the literal text an anchored regular expression starts with, empty if it isn't anchored or starts with anything else
+func regexPrefix(expr string) string {
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	parsed = parsed.Simplify()
+	if parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 || parsed.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	if literal := parsed.Sub[1]; literal.Op == syntax.OpLiteral && literal.Flags&syntax.FoldCase == 0 {
+		return string(literal.Rune)
+	}
+	return ""
+}
+
+// function compilePattern to compile a search pattern for the given match mode (guessed from the pattern when empty), hosts are matched case insensitively, returns the pattern and an error
+func compilePattern(pattern string, mode string, host bool) (*searchPattern, error) {
+	if mode == "" {
+		switch {
+		case len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/"):
+			mode, pattern = mytypes.MatchRegex, pattern[1:len(pattern)-1]
+		case strings.ContainsAny(pattern, "*?["):
+			mode = mytypes.MatchGlob
+		default:
+			mode = mytypes.MatchContains
+		}
+	}
+	if host && mode != mytypes.MatchRegex {
+		pattern = strings.ToLower(pattern)
+	}
+
+	compiled := &searchPattern{fold: host && mode == mytypes.MatchRegex}
+	literal := regexp.QuoteMeta(pattern)
+	switch mode {
+	case mytypes.MatchExact:
+		compiled.expr, compiled.prefix, compiled.suffix = "^"+literal+"$", pattern, pattern
+	case mytypes.MatchPrefix:
+		compiled.expr, compiled.prefix = "^"+literal, pattern
+	case mytypes.MatchSuffix:
+		compiled.expr, compiled.suffix = literal+"$", pattern
+	case mytypes.MatchContains:
+		compiled.expr = literal
+	case mytypes.MatchGlob:
+		compiled.expr = globExpr(pattern)
+		if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+			compiled.prefix = pattern[:i]
+			compiled.suffix = pattern[strings.LastIndexAny(pattern, "*?]")+1:]
+		} else {
+			compiled.prefix, compiled.suffix = pattern, pattern
+		}
+	case mytypes.MatchRegex:
+		compiled.expr = pattern
+		if !compiled.fold {
+			compiled.prefix = regexPrefix(pattern)
+		}
+	default:
+		return nil, fmt.Errorf("invalid match mode %q", mode)
+	}
+
+	expr := compiled.expr
+	if compiled.fold {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	compiled.re = re
+
+	return compiled, nil
+}
+
+// function mongoRegex to get the pattern as a mongodb regex, the patterns built from literals, globs and anchors read the same in go and in pcre
+func (p *searchPattern) mongoRegex() primitive.Regex {
+	regex := primitive.Regex{Pattern: p.expr}
+	if p.fold {
+		regex.Options = "i"
+	}
+	return regex
+}
+
+// function enumHostFilter to narrow the domain documents to the ones that can hold a matching host, with the index on subdomains.subdomain for a literal prefix and the index on domain for a literal suffix (the domain of a host either ends with the suffix or is a tail of it)
+func enumHostFilter(p *searchPattern) bson.M {
+	conds := bson.A{}
+	if p.prefix != "" {
+		prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(p.prefix)}
+		conds = append(conds, bson.M{"$or": bson.A{bson.M{"domain": prefix}, bson.M{"subdomains.subdomain": prefix}}})
+	}
+	if p.suffix != "" {
+		tails := bson.A{p.suffix}
+		for tail := p.suffix; strings.Contains(tail, "."); {
+			tail = tail[strings.IndexByte(tail, '.')+1:]
+			tails = append(tails, tail)
+		}
+		suffix := primitive.Regex{Pattern: regexp.QuoteMeta(p.suffix) + "$"}
+		conds = append(conds, bson.M{"$or": bson.A{bson.M{"domain": bson.M{"$in": tails}}, bson.M{"domain": suffix}}})
+	}
+	filter := bson.M{"domain": bson.M{"$exists": true}}
+	if len(conds) > 0 {
+		filter["$and"] = conds
+	}
+	return filter
+}
+
+// searchCollector keeps the results of one page, the results before the page are only counted
+type searchCollector struct {
+	skip    int
+	want    int
+	results []mytypes.SearchResult
+}
+
+// function add to keep a result if it is on the page, returns false once the page and one more result (for HasMore) are there
+func (c *searchCollector) add(result mytypes.SearchResult) bool {
+	if c.skip > 0 {
+		c.skip--
+		return true
+	}
+	c.results = append(c.results, result)
+	return len(c.results) < c.want
+}
+
+// function searchEnum to collect the assets of the enum doc tree of a target matching the host and path patterns, returns false once the page is full and an error
+func searchEnum(client *mongo.Client, target string, assettype string, host *searchPattern, path *searchPattern, collector *searchCollector) (bool, error) {
+	filter := bson.M{"domain": bson.M{"$exists": true}}
+	if host != nil {
+		filter = enumHostFilter(host)
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "domain", Value: 1}})
+	cursor, err := client.Database(EnumDatabase).Collection(target).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(context.TODO())
+
+	// one domain document at a time, a big target isn't loaded whole
+	for cursor.Next(context.TODO()) {
+		doc := bson.M{}
+		err = cursor.Decode(&doc)
+		if err != nil {
+			return false, err
+		}
+		for _, asset := range enumAssets([]bson.M{doc}) {
+			name := asset.Subdomain
+			if name == "" {
+				name = asset.Domain
+			}
+			if assettype != "" && asset.Type != assettype {
+				continue
+			}
+			if host != nil && !host.re.MatchString(name) {
+				continue
+			}
+			if path != nil && (asset.Path == "" || !path.re.MatchString(asset.Path)) {
+				continue
+			}
+			result := mytypes.SearchResult{Target: target, Type: asset.Type, Domain: asset.Domain, Host: name, Path: asset.Path, Parameter: asset.Parameter}
+			if !collector.add(result) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, cursor.Err()
+}
+
+// function searchProbes to collect the web probes of the targets matching the host and path patterns and the text, the whole filter runs in the database so the page is a skip and a limit, returns an error
+func searchProbes(client *mongo.Client, targets []string, host *searchPattern, path *searchPattern, text string, collector *searchCollector) error {
+	filter := bson.M{}
+	if len(targets) > 0 {
+		filter["target"] = bson.M{"$in": targets}
+	}
+	if host != nil {
+		filter["host"] = host.mongoRegex()
+	}
+	if path != nil {
+		filter["path"] = path.mongoRegex()
+	}
+	opts := options.Find()
+	if text != "" {
+		// $text fails without the text index, a fresh database may not have it yet
+		err := ensureIndexes(client, WebDatabase, WebProbes)
+		if err != nil {
+			return err
+		}
+		filter["$text"] = bson.M{"$search": text}
+		opts.SetProjection(bson.M{"score": bson.M{"$meta": "textScore"}})
+		opts.SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "url", Value: 1}})
+	} else {
+		opts.SetSort(bson.D{{Key: "target", Value: 1}, {Key: "host", Value: 1}, {Key: "url", Value: 1}})
+	}
+	opts.SetSkip(int64(collector.skip)).SetLimit(int64(collector.want - len(collector.results)))
+	collector.skip = 0
+
+	cursor, err := client.Database(WebDatabase).Collection(WebProbes).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return err
+	}
+	probes := []struct {
+		mytypes.WebProbe `bson:",inline"`
+		Score            float64 `bson:"score"`
+	}{}
+	err = cursor.All(context.TODO(), &probes)
+	if err != nil {
+		return err
+	}
+	for _, probe := range probes {
+		collector.add(mytypes.SearchResult{
+			Target:     probe.Target,
+			Type:       mytypes.AssetProbe,
+			Host:       probe.Host,
+			Path:       probe.Path,
+			URL:        probe.URL,
+			StatusCode: probe.StatusCode,
+			Title:      probe.Title,
+			Score:      probe.Score,
+		})
+	}
+
+	return nil
+}
+
+// function Search to find the assets of the enum doc tree and the web probes matching the query, the enum assets come first (by target and domain) and then the probes (by relevance for a text search), returns a page of results and an error
+func Search(client *mongo.Client, query mytypes.SearchQuery) (*mytypes.SearchPage, error) {
+	var host, path *searchPattern
+	var err error
+	if query.Host != "" {
+		host, err = compilePattern(query.Host, query.HostMatch, true)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error searching: %v", err)
+		}
+	}
+	if query.Path != "" {
+		path, err = compilePattern(query.Path, query.PathMatch, false)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error searching: %v", err)
+		}
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = SearchPageSize
+	}
+	if query.PageSize > SearchMaxPageSize {
+		query.PageSize = SearchMaxPageSize
+	}
+	switch query.Type {
+	case "", mytypes.AssetDomain, mytypes.AssetSubdomain, mytypes.AssetDirectory, mytypes.AssetFile, mytypes.AssetParameter, mytypes.AssetProbe:
+	default:
+		return nil, fmt.Errorf("[-] Error searching: invalid type %q", query.Type)
+	}
+	if query.Text != "" && query.Type != "" && query.Type != mytypes.AssetProbe {
+		return nil, fmt.Errorf("[-] Error searching: text search only finds probes")
+	}
+
+	collector := &searchCollector{skip: (query.Page - 1) * query.PageSize, want: query.PageSize + 1}
+	more := true
+	if query.Text == "" && query.Type != mytypes.AssetProbe {
+		targets := query.Targets
+		if len(targets) == 0 {
+			targets, err = ListTargets(client, EnumDatabase)
+			if err != nil {
+				return nil, fmt.Errorf("[-] Error searching: %v", err)
+			}
+		}
+		for _, target := range targets {
+			more, err = searchEnum(client, target, query.Type, host, path, collector)
+			if err != nil {
+				return nil, fmt.Errorf("[-] Error searching: %v", err)
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	if more && (query.Type == "" || query.Type == mytypes.AssetProbe) {
+		err = searchProbes(client, query.Targets, host, path, query.Text, collector)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error searching: %v", err)
+		}
+	}
+
+	page := &mytypes.SearchPage{Results: collector.results, Page: query.Page, PageSize: query.PageSize}
+	if len(page.Results) > query.PageSize {
+		page.Results, page.HasMore = page.Results[:query.PageSize], true
+	}
+
+	return page, nil
+}
@@ -0,0 +1,720 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Tags                     ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// fields holding the tags and labels of a node of the enum doc tree, they are not part of the node's own fields for the watch hashes
+var enumTagFields = []string{"tags", "labels"}
+
+// function checkTags to validate tags and label keys, label keys become field names so they can't be empty, contain dots or start with '$', returns an error
+func checkTags(tags []string, labelkeys []string) error {
+	for _, tag := range tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("empty tag")
+		}
+	}
+	for _, key := range labelkeys {
+		if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+	}
+	return nil
+}
+
+// function URLAsset to get the asset of the enum doc tree a url of a target is stored at, a path ending in '/' is a directory and otherwise a file, returns the asset and an error
+func URLAsset(client *mongo.Client, target string, rawurl string) (mytypes.TargetAsset, error) {
+	if !strings.Contains(rawurl, "://") {
+		rawurl = "https://" + rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Hostname() == "" {
+		return mytypes.TargetAsset{}, fmt.Errorf("[-] Error finding url asset: invalid url %q", rawurl)
+	}
+	domains, err := GetTargetDomains(client, target)
+	if err != nil {
+		return mytypes.TargetAsset{}, fmt.Errorf("[-] Error finding url asset: %v", err)
+	}
+	asset, _, err := urlAsset(domains, u)
+	if err != nil {
+		return mytypes.TargetAsset{}, fmt.Errorf("[-] Error finding url asset: %v", err)
+	}
+
+	return asset, nil
+}
+
+// function tagAuditDocument to describe the tags and labels of a node for an audit record
+func tagAuditDocument(id interface{}, asset mytypes.TargetAsset, node bson.M) bson.M {
+	tags, labels := nodeTags(node)
+	return bson.M{"_id": id, "asset": asset.Type + " " + assetKey(asset), "tags": tags, "labels": labels}
+}
+
+// function findAssetNode to find the node of an asset in a domain document, returns nil if the domain document doesn't hold it
+func findAssetNode(doc bson.M, asset mytypes.TargetAsset) (bson.M, bool) {
+	for _, node := range enumAssets([]bson.M{doc}) {
+		if node.TargetAsset == asset {
+			return node.node, true
+		}
+	}
+	return nil, false
+}
+
+// function updateTagged to apply an update built for the path prefix of an asset (a target's marker document for AssetTarget), the asset has to exist, the tags and labels of the node before and after are audited, returns an error
+func updateTagged(client *mongo.Client, target string, asset mytypes.TargetAsset, build func(prefix string) bson.M) error {
+	exists, err := CheckCollection(client, EnumDatabase, target)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("target %s doesn't exist", target)
+	}
+	coll := client.Database(EnumDatabase).Collection(target)
+	if asset.Type == mytypes.AssetTarget {
+		// the tags of a target are on the marker document of its enum collection
+		filter := bson.M{"exists": true}
+		var before bson.M
+		current := bson.M{}
+		if coll.FindOne(context.TODO(), filter).Decode(&current) == nil {
+			before = tagAuditDocument(current["_id"], asset, current)
+		}
+		after := bson.M{}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+		err = coll.FindOneAndUpdate(context.TODO(), filter, build(""), opts).Decode(&after)
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, filter, nil, nil, 0, err)
+			return err
+		}
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, filter, before, tagAuditDocument(after["_id"], asset, after), 1, nil)
+		return nil
+	}
+
+	asset = canonicalAsset(asset)
+	prefix, filters, err := assetUpdatePath(asset)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"domain": asset.Domain}
+	current := bson.M{}
+	err = coll.FindOne(context.TODO(), filter).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("domain %s is not in target %s", asset.Domain, target)
+	}
+	if err != nil {
+		return err
+	}
+	node, found := findAssetNode(current, asset)
+	if !found {
+		return fmt.Errorf("%s %s is not in target %s", asset.Type, assetKey(asset), target)
+	}
+	// plain string nodes on the path become documents so the array filters can match them
+	doc, err := assetDocument(asset)
+	if err != nil {
+		return err
+	}
+	_, err = mergeDocument(coll, stampProvenance(doc))
+	if err != nil {
+		return err
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if len(filters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: filters})
+	}
+	updated := bson.M{}
+	err = coll.FindOneAndUpdate(context.TODO(), filter, build(prefix), opts).Decode(&updated)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, filter, nil, nil, 0, err)
+		return err
+	}
+	after, _ := findAssetNode(updated, asset)
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, filter, tagAuditDocument(current["_id"], asset, node), tagAuditDocument(updated["_id"], asset, after), 1, nil)
+	return nil
+}
+
+// function assetKey to write an asset the way the watch keys do (sub.test.com/dir1/file.txt, sub.test.com/dir1?id)
+func assetKey(asset mytypes.TargetAsset) string {
+	key := asset.Domain
+	if asset.Subdomain != "" {
+		key = asset.Subdomain
+	}
+	if asset.Path != "" && asset.Path != "/" {
+		key += asset.Path
+	}
+	if asset.Parameter != "" {
+		key += "?" + asset.Parameter
+	}
+	return key
+}
+
+// function tagUpdate to get the builder of the update adding tags and setting labels on the node at a path prefix
+func tagUpdate(tags []string, labels map[string]string) func(prefix string) bson.M {
+	return func(prefix string) bson.M {
+		update := bson.M{}
+		if len(tags) > 0 {
+			update["$addToSet"] = bson.M{prefix + "tags": bson.M{"$each": tags}}
+		}
+		if len(labels) > 0 {
+			set := bson.M{}
+			for key, value := range labels {
+				set[prefix+"labels."+key] = value
+			}
+			update["$set"] = set
+		}
+		return update
+	}
+}
+
+// function untagUpdate to get the builder of the update removing tags and labels (by key) from the node at a path prefix
+func untagUpdate(tags []string, labelkeys []string) func(prefix string) bson.M {
+	return func(prefix string) bson.M {
+		update := bson.M{}
+		if len(tags) > 0 {
+			update["$pull"] = bson.M{prefix + "tags": bson.M{"$in": tags}}
+		}
+		if len(labelkeys) > 0 {
+			unset := bson.M{}
+			for _, key := range labelkeys {
+				unset[prefix+"labels."+key] = ""
+			}
+			update["$unset"] = unset
+		}
+		return update
+	}
+}
+
+// function TagAsset to add tags and set labels on a target (type AssetTarget) or an asset of its enum doc tree, existing tags are kept and labels with the same key are overwritten, returns an error
+func TagAsset(client *mongo.Client, target string, asset mytypes.TargetAsset, tags []string, labels map[string]string) error {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	err := checkTags(tags, keys)
+	if err == nil && len(tags) == 0 && len(labels) == 0 {
+		err = fmt.Errorf("no tags or labels given")
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error tagging asset: %v", err)
+	}
+
+	err = updateTagged(client, target, asset, tagUpdate(tags, labels))
+	if err != nil {
+		return fmt.Errorf("[-] Error tagging asset: %v", err)
+	}
+
+	return nil
+}
+
+// function UntagAsset to remove tags and labels (by key) from a target (type AssetTarget) or an asset of its enum doc tree, returns an error
+func UntagAsset(client *mongo.Client, target string, asset mytypes.TargetAsset, tags []string, labelkeys []string) error {
+	err := checkTags(tags, labelkeys)
+	if err == nil && len(tags) == 0 && len(labelkeys) == 0 {
+		err = fmt.Errorf("no tags or labels given")
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error untagging asset: %v", err)
+	}
+
+	err = updateTagged(client, target, asset, untagUpdate(tags, labelkeys))
+	if err != nil {
+		return fmt.Errorf("[-] Error untagging asset: %v", err)
+	}
+
+	return nil
+}
+
+// function nodeTags to read the tags and labels of a node of the enum doc tree
+func nodeTags(node bson.M) ([]string, map[string]string) {
+	tags := []string{}
+	labels := map[string]string{}
+	if node == nil {
+		return tags, labels
+	}
+	for _, value := range toA(node["tags"]) {
+		if tag, ok := value.(string); ok {
+			tags = append(tags, tag)
+		}
+	}
+	if doc, ok := toM(node["labels"]); ok {
+		for key, value := range doc {
+			if s, ok := value.(string); ok {
+				labels[key] = s
+			}
+		}
+	}
+	return tags, labels
+}
+
+// function tagMatches to check a tagged asset against the query
+func tagMatches(query mytypes.TagQuery, asset mytypes.TaggedAsset) bool {
+	if query.Type != "" && asset.Type != query.Type {
+		return false
+	}
+	if query.Host != "" {
+		host := asset.Subdomain
+		if host == "" {
+			host = asset.Domain
+		}
+		want := normalizeHost(query.Host)
+		if host == "" || (host != want && !strings.HasSuffix(host, "."+want)) {
+			return false
+		}
+	}
+	if query.PathPrefix != "" {
+		prefix := pathSegments(query.PathPrefix)
+		path := pathSegments(asset.Path)
+		if len(path) < len(prefix) || strings.Join(path[:len(prefix)], "/") != strings.Join(prefix, "/") {
+			return false
+		}
+	}
+	for _, tag := range query.Tags {
+		if !myutils.ContainsString(asset.Tags, tag) {
+			return false
+		}
+	}
+	for key, value := range query.Labels {
+		current, ok := asset.Labels[key]
+		if !ok || (value != "" && current != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// depth of the directory levels the tag filter names one by one, documents with deeper directories are always read
+const tagFilterDepth = 4
+
+// function tagConds to get the conditions of the query's tags and labels on the node under the given path ("" for the document itself)
+func tagConds(query mytypes.TagQuery, path string) bson.M {
+	conds := bson.M{}
+	if len(query.Tags) > 0 {
+		conds[path+"tags"] = bson.M{"$all": query.Tags}
+	}
+	for key, value := range query.Labels {
+		if value == "" {
+			conds[path+"labels."+key] = bson.M{"$exists": true}
+		} else {
+			conds[path+"labels."+key] = value
+		}
+	}
+	return conds
+}
+
+// function hostCond to get the condition matching a host and its subdomains, the way tagMatches does
+func hostCond(host string) bson.M {
+	return bson.M{"$regex": "^(.+\\.)?" + regexp.QuoteMeta(normalizeHost(host)) + "$"}
+}
+
+// function tagFilter to narrow the documents of an enum collection on the server, the tag and label conditions are pushed down as an $or over the levels the query can match (tags, subdomains.tags, ... with the tag indexes on the fixed levels), deeper levels are matched field by field so the filter may read a few documents too many, the nodes are checked again one by one
+func tagFilter(query mytypes.TagQuery) bson.M {
+	conds := tagConds(query, "")
+	if len(conds) == 0 && query.Host == "" && query.PathPrefix == "" && query.Type == "" {
+		return bson.M{}
+	}
+	levels := bson.A{}
+	wants := func(typ string) bool {
+		return query.Type == "" || query.Type == typ
+	}
+	// clause with the conditions under path, or the existence of the path if there are none
+	clause := func(path string, withhost bool) bson.M {
+		c := tagConds(query, path+".")
+		if len(c) == 0 {
+			c[path+".0"] = bson.M{"$exists": true}
+		}
+		if withhost && query.Host != "" {
+			c["subdomains.subdomain"] = hostCond(query.Host)
+		}
+		return c
+	}
+
+	// a target, a domain and a subdomain have no path
+	if query.PathPrefix == "" {
+		if wants(mytypes.AssetTarget) && query.Host == "" {
+			c := tagConds(query, "")
+			c["exists"] = true
+			levels = append(levels, c)
+		}
+		if wants(mytypes.AssetDomain) {
+			c := tagConds(query, "")
+			c["domain"] = bson.M{"$exists": true}
+			if query.Host != "" {
+				c["domain"] = hostCond(query.Host)
+			}
+			levels = append(levels, c)
+		}
+		if wants(mytypes.AssetSubdomain) {
+			sub := tagConds(query, "")
+			if query.Host != "" {
+				sub["subdomain"] = hostCond(query.Host)
+			}
+			if len(sub) == 0 {
+				levels = append(levels, bson.M{"subdomains.0": bson.M{"$exists": true}})
+			} else {
+				levels = append(levels, bson.M{"subdomains": bson.M{"$elemMatch": sub}})
+			}
+		}
+	}
+	if wants(mytypes.AssetDirectory) || wants(mytypes.AssetFile) || wants(mytypes.AssetParameter) {
+		base := "subdomains.directories"
+		for depth := 1; depth <= tagFilterDepth; depth++ {
+			if wants(mytypes.AssetDirectory) {
+				levels = append(levels, clause(base, true))
+			}
+			if wants(mytypes.AssetFile) {
+				levels = append(levels, clause(base+".files", true))
+			}
+			if wants(mytypes.AssetParameter) {
+				levels = append(levels, clause(base+".parameters", true))
+			}
+			base += ".subdirectories"
+		}
+		deeper := bson.M{base + ".0": bson.M{"$exists": true}}
+		if query.Host != "" {
+			deeper["subdomains.subdomain"] = hostCond(query.Host)
+		}
+		levels = append(levels, deeper)
+	}
+
+	if len(levels) == 0 {
+		// nothing can match, e.g. a target with a host
+		return bson.M{"_id": bson.M{"$in": bson.A{}}}
+	}
+	if len(levels) == 1 {
+		return levels[0].(bson.M)
+	}
+	return bson.M{"$or": levels}
+}
+
+// taggedNode is a tagged asset with its node in the enum doc tree (nil for a plain string node)
+type taggedNode struct {
+	mytypes.TaggedAsset
+	node bson.M
+}
+
+// function findTaggedNodes to get the target's marker and the nodes of its enum doc tree matching the query, up to limit (0 for all)
+func findTaggedNodes(client *mongo.Client, target string, query mytypes.TagQuery, limit int) ([]taggedNode, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "domain", Value: 1}})
+	cursor, err := client.Database(EnumDatabase).Collection(target).Find(context.TODO(), tagFilter(query), opts)
+	if err != nil {
+		return nil, err
+	}
+	docs := []bson.M{}
+	err = cursor.All(context.TODO(), &docs)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []enumAsset{}
+	for _, doc := range docs {
+		if doc["exists"] == true {
+			nodes = append(nodes, enumAsset{mytypes.TargetAsset{Type: mytypes.AssetTarget}, doc})
+		}
+	}
+	nodes = append(nodes, enumAssets(docs)...)
+	matches := []taggedNode{}
+	for _, node := range nodes {
+		tags, labels := nodeTags(node.node)
+		asset := mytypes.TaggedAsset{Target: target, TargetAsset: node.TargetAsset, Tags: tags, Labels: labels}
+		if !tagMatches(query, asset) {
+			continue
+		}
+		matches = append(matches, taggedNode{asset, node.node})
+		if limit > 0 && len(matches) >= limit {
+			break
+		}
+	}
+
+	return matches, nil
+}
+
+// function FindTaggedAssets to get the targets and the assets of their enum doc trees matching the query, across all the targets unless the query names some, returns the assets in the order of the targets and of their trees and an error
+func FindTaggedAssets(client *mongo.Client, query mytypes.TagQuery) ([]mytypes.TaggedAsset, error) {
+	targets := query.Targets
+	if len(targets) == 0 {
+		var err error
+		targets, err = ListTargets(client, EnumDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding tagged assets: %v", err)
+		}
+	}
+
+	assets := []mytypes.TaggedAsset{}
+	for _, target := range targets {
+		limit := 0
+		if query.Limit > 0 {
+			limit = query.Limit - len(assets)
+		}
+		nodes, err := findTaggedNodes(client, target, query, limit)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error finding tagged assets: %v", err)
+		}
+		for _, node := range nodes {
+			assets = append(assets, node.TaggedAsset)
+		}
+		if query.Limit > 0 && len(assets) >= query.Limit {
+			break
+		}
+	}
+
+	return assets, nil
+}
+
+// function tagLevel to get the level of the enum doc tree an asset is at, its type and the directories above it the way assetUpdatePath walks them (the root directory "/" when there are none)
+func tagLevel(asset mytypes.TargetAsset) (string, []string) {
+	segments := pathSegments(asset.Path)
+	dirs := []string{}
+	switch asset.Type {
+	case mytypes.AssetDirectory:
+		dirs = segments
+	case mytypes.AssetFile:
+		if len(segments) > 0 {
+			dirs = segments[:len(segments)-1]
+		}
+		if len(dirs) == 0 {
+			dirs = []string{"/"}
+		}
+	case mytypes.AssetParameter:
+		dirs = segments
+		if len(dirs) == 0 {
+			dirs = []string{"/"}
+		}
+	}
+	return asset.Type, dirs
+}
+
+// function arrayFilter to combine the conditions on an array filter identifier, an identifier without conditions matches every document node
+func arrayFilter(id string, parts []bson.M) bson.M {
+	switch len(parts) {
+	case 0:
+		return bson.M{id: bson.M{"$type": "object"}}
+	case 1:
+		return parts[0]
+	}
+	and := bson.A{}
+	for _, part := range parts {
+		and = append(and, part)
+	}
+	return bson.M{"$and": and}
+}
+
+// function tagLevelPath to build the update path prefix and the array filters selecting, at one level of the enum doc tree, every node matching the query, so one UpdateMany applies a tag update to the whole level with the conditions checked by the server, root tells whether the level is under the root directory "/" and depth is the number of directories above the node, returns the prefix and the filters
+func tagLevelPath(query mytypes.TagQuery, typ string, depth int, root bool) (string, []interface{}) {
+	prefix := "subdomains.$[s]."
+	sub := []bson.M{}
+	if query.Host != "" {
+		sub = append(sub, bson.M{"s.subdomain": hostCond(query.Host)})
+	}
+	if typ == mytypes.AssetSubdomain {
+		sub = append(sub, tagConds(query, "s."))
+		return prefix, []interface{}{arrayFilter("s", nonEmpty(sub))}
+	}
+	// every node on the way down has to hold the next array, an array update fails on a path that doesn't exist
+	sub = append(sub, bson.M{"s.directories": bson.M{"$type": "array"}})
+	filters := []interface{}{arrayFilter("s", sub)}
+
+	want := pathSegments(query.PathPrefix)
+	segment := 0
+	for i := 0; i < depth; i++ {
+		id := "d" + strconv.Itoa(i)
+		children := "subdirectories"
+		if i == 0 {
+			children = "directories"
+		}
+		prefix += children + ".$[" + id + "]."
+		parts := []bson.M{}
+		if root {
+			parts = append(parts, nodeFilter(id, enumChildNames[children], "/"))
+		} else {
+			if segment < len(want) {
+				parts = append(parts, nodeFilter(id, enumChildNames[children], want[segment]))
+			}
+			segment++
+		}
+		switch {
+		case i < depth-1:
+			parts = append(parts, bson.M{id + ".subdirectories": bson.M{"$type": "array"}})
+		case typ == mytypes.AssetDirectory:
+			parts = append(parts, tagConds(query, id+"."))
+		case typ == mytypes.AssetFile:
+			parts = append(parts, bson.M{id + ".files": bson.M{"$type": "array"}})
+		case typ == mytypes.AssetParameter:
+			parts = append(parts, bson.M{id + ".parameters": bson.M{"$type": "array"}})
+		}
+		filters = append(filters, arrayFilter(id, nonEmpty(parts)))
+	}
+
+	switch typ {
+	case mytypes.AssetFile:
+		prefix += "files.$[f]."
+		parts := []bson.M{tagConds(query, "f.")}
+		// the prefix may end with the name of the file
+		if segment < len(want) {
+			parts = append(parts, nodeFilter("f", enumChildNames["files"], want[segment]))
+		}
+		filters = append(filters, arrayFilter("f", nonEmpty(parts)))
+	case mytypes.AssetParameter:
+		prefix += "parameters.$[p]."
+		filters = append(filters, arrayFilter("p", nonEmpty([]bson.M{tagConds(query, "p.")})))
+	}
+	return prefix, filters
+}
+
+// function nonEmpty to drop the empty condition documents
+func nonEmpty(parts []bson.M) []bson.M {
+	kept := []bson.M{}
+	for _, part := range parts {
+		if len(part) > 0 {
+			kept = append(kept, part)
+		}
+	}
+	return kept
+}
+
+// function bulkTag to apply a tag update to every target and asset matching the query, the matching nodes are read once to find the levels of the trees they are at, then each level gets one array-filtered UpdateMany that checks the query again on the server, plain string nodes are turned into documents first when convert is set, a query without targets or filters is refused so a forgotten flag doesn't tag the whole inventory, returns the number of matched assets and an error
+func bulkTag(client *mongo.Client, query mytypes.TagQuery, build func(prefix string) bson.M, before bson.M, after bson.M, convert bool) (int, error) {
+	if len(query.Targets) == 0 && query.Type == "" && query.Host == "" && query.PathPrefix == "" && len(query.Tags) == 0 && len(query.Labels) == 0 {
+		return 0, fmt.Errorf("a target or a filter is required")
+	}
+	targets := query.Targets
+	if len(targets) == 0 {
+		var err error
+		targets, err = ListTargets(client, EnumDatabase)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	total := 0
+	for _, target := range targets {
+		exists, err := CheckCollection(client, EnumDatabase, target)
+		if err != nil {
+			return total, err
+		}
+		if !exists {
+			return total, fmt.Errorf("target %s doesn't exist", target)
+		}
+		coll := client.Database(EnumDatabase).Collection(target)
+		nodes, err := findTaggedNodes(client, target, query, 0)
+		if err != nil {
+			return total, err
+		}
+
+		// one update per level, in the order the levels were first met
+		type level struct {
+			typ   string
+			depth int
+			root  bool
+		}
+		levels := []level{}
+		seen := map[level]bool{}
+		for _, node := range nodes {
+			asset := node.TargetAsset
+			if convert && node.node == nil && asset.Type != mytypes.AssetDomain {
+				// the array filters only match documents
+				doc, err := assetDocument(asset)
+				if err != nil {
+					return total, err
+				}
+				_, err = mergeDocument(coll, stampProvenance(doc))
+				if err != nil {
+					return total, err
+				}
+			}
+			typ, dirs := tagLevel(asset)
+			l := level{typ, len(dirs), len(dirs) == 1 && dirs[0] == "/"}
+			if !seen[l] {
+				seen[l] = true
+				levels = append(levels, l)
+			}
+		}
+
+		for _, l := range levels {
+			levelquery := query
+			levelquery.Type = l.typ
+			filter := tagFilter(levelquery)
+			opts := options.Update()
+			prefix := ""
+			var filters []interface{}
+			switch l.typ {
+			case mytypes.AssetTarget:
+				filter = tagConds(query, "")
+				filter["exists"] = true
+			case mytypes.AssetDomain:
+			default:
+				prefix, filters = tagLevelPath(query, l.typ, l.depth, l.root)
+				opts.SetArrayFilters(options.ArrayFilters{Filters: filters})
+				filter = bson.M{"$and": bson.A{filter, bson.M{"subdomains": bson.M{"$type": "array"}}}}
+			}
+			result, err := coll.UpdateMany(context.TODO(), filter, build(prefix), opts)
+			if err != nil {
+				auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, filter, nil, nil, 0, err)
+				return total, err
+			}
+			auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, bson.M{"filter": filter, "path": prefix, "array_filters": filters}, before, after, result.ModifiedCount, nil)
+		}
+		total += len(nodes)
+	}
+
+	return total, nil
+}
+
+// function TagAssets to add tags and set labels on every target and asset matching the query, one array-filtered update per level of each target's tree (the query's Limit doesn't apply), returns the number of tagged assets and an error
+func TagAssets(client *mongo.Client, query mytypes.TagQuery, tags []string, labels map[string]string) (int, error) {
+	keys := []string{}
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	err := checkTags(tags, keys)
+	if err == nil && len(tags) == 0 && len(labels) == 0 {
+		err = fmt.Errorf("no tags or labels given")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error tagging assets: %v", err)
+	}
+
+	count, err := bulkTag(client, query, tagUpdate(tags, labels), nil, bson.M{"tags": tags, "labels": labels}, true)
+	if err != nil {
+		return count, fmt.Errorf("[-] Error tagging assets: %v", err)
+	}
+	fmt.Printf("[+] Tagged %d assets successfully\n", count)
+
+	return count, nil
+}
+
+// function UntagAssets to remove tags and labels (by key) from every target and asset matching the query, one array-filtered update per level of each target's tree (the query's Limit doesn't apply), returns the number of untagged assets and an error
+func UntagAssets(client *mongo.Client, query mytypes.TagQuery, tags []string, labelkeys []string) (int, error) {
+	err := checkTags(tags, labelkeys)
+	if err == nil && len(tags) == 0 && len(labelkeys) == 0 {
+		err = fmt.Errorf("no tags or labels given")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error untagging assets: %v", err)
+	}
+
+	count, err := bulkTag(client, query, untagUpdate(tags, labelkeys), bson.M{"tags": tags, "labels": labelkeys}, nil, false)
+	if err != nil {
+		return count, fmt.Errorf("[-] Error untagging assets: %v", err)
+	}
+	fmt.Printf("[+] Untagged %d assets successfully\n", count)
+
+	return count, nil
+}
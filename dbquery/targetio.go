@@ -0,0 +1,671 @@
+package dbquery
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Target import/export     ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// fields holding the name of the nodes of each child array of the enum doc tree
+var enumChildNames = map[string][]string{
+	"subdomains":     {"subdomain"},
+	"directories":    {"directory", "subdirectory"},
+	"subdirectories": {"subdirectory", "directory"},
+	"files":          {"file", "name"},
+	"parameters":     {"parameter", "name"},
+}
+
+// columns of the csv format
+var targetCSVHeader = []string{"type", "domain", "subdomain", "path", "parameter"}
+
+// number of times an import retries a document that was modified while it was being merged
+const importRetries = 5
+
+// function toD to convert a decoded bson value to an ordered document, returns false if the value is not a document
+func toD(v interface{}) (bson.D, bool) {
+	doc, ok := v.(bson.D)
+	return doc, ok
+}
+
+// function dGet to get the value of a field of an ordered document
+func dGet(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// function dSet to set a field of an ordered document, keeping its position if it exists, returns the document
+func dSet(doc bson.D, key string, value interface{}) bson.D {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+// function nodeNameD to get the name of a node in the enum doc tree decoded as ordered documents, see enumNodeName
+func nodeNameD(node interface{}, fields []string) (string, bson.D) {
+	if name, ok := node.(string); ok {
+		return name, nil
+	}
+	doc, ok := toD(node)
+	if !ok {
+		return "", nil
+	}
+	for _, field := range fields {
+		if value, ok := dGet(doc, field); ok {
+			if name, ok := value.(string); ok {
+				return name, doc
+			}
+		}
+	}
+	return "", doc
+}
+
+// function mergeNode to merge an incoming node of the enum doc tree into an existing one, child arrays are merged by node name and the other fields are only added when missing so existing values are never overwritten, returns the merged node and whether it changed
+func mergeNode(existing bson.D, incoming bson.D) (bson.D, bool) {
+	changed := false
+	for _, e := range incoming {
+		if e.Key == "_id" && len(existing) > 0 {
+			continue
+		}
+		current, ok := dGet(existing, e.Key)
+		if fields, child := enumChildNames[e.Key]; child {
+			merged, childchanged := mergeChildren(toA(current), toA(e.Value), fields)
+			if childchanged || !ok {
+				existing = dSet(existing, e.Key, merged)
+				changed = true
+			}
+			continue
+		}
+		if !ok {
+			existing = append(existing, e)
+			changed = true
+		}
+	}
+	return existing, changed
+}
+
+// function mergeChildren to merge the incoming nodes of a child array into the existing ones by name, a plain string node is replaced by the incoming document of the same name, returns the merged array and whether it changed
+func mergeChildren(existing []interface{}, incoming []interface{}, fields []string) (bson.A, bool) {
+	merged := bson.A{}
+	merged = append(merged, existing...)
+	index := map[string]int{}
+	for i, node := range merged {
+		if name, _ := nodeNameD(node, fields); name != "" {
+			index[name] = i
+		}
+	}
+
+	changed := false
+	for _, node := range incoming {
+		name, doc := nodeNameD(node, fields)
+		if name == "" {
+			continue
+		}
+		i, ok := index[name]
+		if !ok {
+			merged = append(merged, node)
+			index[name] = len(merged) - 1
+			changed = true
+			continue
+		}
+		if doc == nil {
+			continue
+		}
+		_, current := nodeNameD(merged[i], fields)
+		if current == nil {
+			merged[i] = doc
+			changed = true
+			continue
+		}
+		if node, nodechanged := mergeNode(current, doc); nodechanged {
+			merged[i] = node
+			changed = true
+		}
+	}
+	return merged, changed
+}
+
+// function mergeDocument to merge an incoming document into the enum collection of a target, domain documents are matched by domain and the others by _id, the replacement only applies if the document didn't change since it was read, returns inserted, merged or unchanged and an error
+func mergeDocument(coll *mongo.Collection, incoming bson.D) (string, error) {
+	var filter bson.D
+	if domain, ok := dGet(incoming, "domain"); ok {
+		filter = bson.D{{Key: "domain", Value: domain}}
+	} else if id, ok := dGet(incoming, "_id"); ok {
+		filter = bson.D{{Key: "_id", Value: id}}
+	}
+
+	for attempt := 0; attempt < importRetries; attempt++ {
+		current := bson.D{}
+		err := mongo.ErrNoDocuments
+		if filter != nil {
+			err = coll.FindOne(context.TODO(), filter).Decode(&current)
+		}
+		if err == mongo.ErrNoDocuments {
+			_, err = coll.InsertOne(context.TODO(), incoming)
+			if mongo.IsDuplicateKeyError(err) && filter != nil && filter[0].Key == "domain" && coll.FindOne(context.TODO(), filter).Err() == nil {
+				// a concurrent writer inserted the domain, merge into its document
+				continue
+			}
+			if mongo.IsDuplicateKeyError(err) {
+				// the _id is taken by another document, let the server pick a new one
+				withoutid := bson.D{}
+				for _, e := range incoming {
+					if e.Key != "_id" {
+						withoutid = append(withoutid, e)
+					}
+				}
+				_, err = coll.InsertOne(context.TODO(), withoutid)
+			}
+			if err != nil {
+				return "", err
+			}
+			return "inserted", nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		merged, changed := mergeNode(current, incoming)
+		if !changed {
+			return "unchanged", nil
+		}
+		id, _ := dGet(current, "_id")
+		guard := bson.D{
+			{Key: "_id", Value: id},
+			{Key: "$expr", Value: bson.D{{Key: "$eq", Value: bson.A{"$$ROOT", bson.D{{Key: "$literal", Value: current}}}}}},
+		}
+		result, err := coll.ReplaceOne(context.TODO(), guard, merged)
+		if err != nil {
+			return "", err
+		}
+		if result.MatchedCount == 1 {
+			return "merged", nil
+		}
+	}
+
+	return "", fmt.Errorf("document kept changing during the import, try again")
+}
+
+// function directoryNode to build the chain of directory nodes of a path, the innermost one gets the given extra field (files or parameters) if it has a key
+func directoryNode(dirs []string, extra bson.E) bson.D {
+	var node bson.D
+	for i := len(dirs) - 1; i >= 0; i-- {
+		key := "subdirectory"
+		if i == 0 {
+			key = "directory"
+		}
+		current := bson.D{{Key: key, Value: dirs[i]}}
+		if node != nil {
+			current = append(current, bson.E{Key: "subdirectories", Value: bson.A{node}})
+		} else if extra.Key != "" {
+			current = append(current, extra)
+		}
+		node = current
+	}
+	return node
+}
+
+// function pathSegments to split a path into its non-empty segments
+func pathSegments(path string) []string {
+	segments := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// function assetDocument to build the domain document of the enum doc tree holding a single asset, files and parameters outside of any directory go to the root directory "/", returns the document and an error
+func assetDocument(asset mytypes.TargetAsset) (bson.D, error) {
+	if asset.Domain == "" {
+		return nil, fmt.Errorf("domain is required")
+	}
+	doc := bson.D{{Key: "domain", Value: asset.Domain}}
+	if asset.Type == mytypes.AssetDomain {
+		return doc, nil
+	}
+	if asset.Subdomain == "" {
+		return nil, fmt.Errorf("subdomain is required for a %s", asset.Type)
+	}
+	sub := bson.D{{Key: "subdomain", Value: asset.Subdomain}}
+	dirs := pathSegments(asset.Path)
+
+	switch asset.Type {
+	case mytypes.AssetSubdomain:
+	case mytypes.AssetDirectory:
+		if len(dirs) == 0 {
+			return nil, fmt.Errorf("path is required for a directory")
+		}
+		sub = append(sub, bson.E{Key: "directories", Value: bson.A{directoryNode(dirs, bson.E{})}})
+	case mytypes.AssetFile:
+		if len(dirs) == 0 {
+			return nil, fmt.Errorf("path is required for a file")
+		}
+		file := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		if len(dirs) == 0 {
+			dirs = []string{"/"}
+		}
+		files := bson.E{Key: "files", Value: bson.A{bson.D{{Key: "file", Value: file}}}}
+		sub = append(sub, bson.E{Key: "directories", Value: bson.A{directoryNode(dirs, files)}})
+	case mytypes.AssetParameter:
+		if asset.Parameter == "" {
+			return nil, fmt.Errorf("parameter is required")
+		}
+		if len(dirs) == 0 {
+			dirs = []string{"/"}
+		}
+		params := bson.E{Key: "parameters", Value: bson.A{bson.D{{Key: "parameter", Value: asset.Parameter}}}}
+		sub = append(sub, bson.E{Key: "directories", Value: bson.A{directoryNode(dirs, params)}})
+	default:
+		return nil, fmt.Errorf("unknown asset type %q", asset.Type)
+	}
+
+	return append(doc, bson.E{Key: "subdomains", Value: bson.A{sub}}), nil
+}
+
+// enumAsset is a row of the flat view of the enum doc tree with its node (nil for plain string nodes)
+type enumAsset struct {
+	mytypes.TargetAsset
+	node bson.M
+}
+
+// function walkDirectoryAssets to flatten a directory node (and its subdirectories) of the enum doc tree, the root directory "/" has no row of its own
+func walkDirectoryAssets(assets []enumAsset, domain string, sub string, parent string, node interface{}) []enumAsset {
+	name, doc := enumNodeName(node, "directory", "subdirectory")
+	if name == "" {
+		return assets
+	}
+	path := parent
+	if trimmed := strings.Trim(name, "/"); trimmed != "" {
+		path = parent + "/" + trimmed
+		assets = append(assets, enumAsset{mytypes.TargetAsset{Type: mytypes.AssetDirectory, Domain: domain, Subdomain: sub, Path: path}, doc})
+	}
+	if doc == nil {
+		return assets
+	}
+	for _, file := range toA(doc["files"]) {
+		if fname, fdoc := enumNodeName(file, "file", "name"); fname != "" {
+			assets = append(assets, enumAsset{mytypes.TargetAsset{Type: mytypes.AssetFile, Domain: domain, Subdomain: sub, Path: path + "/" + strings.TrimLeft(fname, "/")}, fdoc})
+		}
+	}
+	for _, param := range toA(doc["parameters"]) {
+		if pname, pdoc := enumNodeName(param, "parameter", "name"); pname != "" {
+			dir := path
+			if dir == "" {
+				dir = "/"
+			}
+			assets = append(assets, enumAsset{mytypes.TargetAsset{Type: mytypes.AssetParameter, Domain: domain, Subdomain: sub, Path: dir, Parameter: pname}, pdoc})
+		}
+	}
+	for _, subdir := range toA(doc["subdirectories"]) {
+		assets = walkDirectoryAssets(assets, domain, sub, path, subdir)
+	}
+	return assets
+}
+
+// function enumAssets to flatten the domain documents of the enum doc tree into one row per domain, subdomain, directory, file and parameter, with the node of each
+func enumAssets(docs []bson.M) []enumAsset {
+	assets := []enumAsset{}
+	for _, doc := range docs {
+		domain, _ := doc["domain"].(string)
+		if domain == "" {
+			continue
+		}
+		assets = append(assets, enumAsset{mytypes.TargetAsset{Type: mytypes.AssetDomain, Domain: domain}, doc})
+		for _, node := range toA(doc["subdomains"]) {
+			sub, subdoc := enumNodeName(node, "subdomain")
+			if sub == "" {
+				continue
+			}
+			assets = append(assets, enumAsset{mytypes.TargetAsset{Type: mytypes.AssetSubdomain, Domain: domain, Subdomain: sub}, subdoc})
+			if subdoc == nil {
+				continue
+			}
+			for _, dir := range toA(subdoc["directories"]) {
+				assets = walkDirectoryAssets(assets, domain, sub, "", dir)
+			}
+		}
+	}
+	return assets
+}
+
+// function TargetAssets to flatten the domain documents of the enum doc tree into one row per domain, subdomain, directory, file and parameter
+func TargetAssets(docs []bson.M) []mytypes.TargetAsset {
+	assets := []mytypes.TargetAsset{}
+	for _, asset := range enumAssets(docs) {
+		assets = append(assets, asset.TargetAsset)
+	}
+	return assets
+}
+
+// function ExportTarget to write the enum doc tree of a target in the given format: json (every document of the target as canonical extended json, lossless), csv (one row per asset), subdomains or urls (one per line, urls are https:// links to the directories and files), returns an error
+func ExportTarget(client *mongo.Client, target string, format string, w io.Writer) error {
+	opts := options.Find().SetSort(bson.D{{Key: "domain", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := client.Database(EnumDatabase).Collection(target).Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		return fmt.Errorf("[-] Error exporting target: %v", err)
+	}
+	defer cursor.Close(context.TODO())
+
+	if format == mytypes.TargetFormatJSON {
+		name, _ := json.Marshal(target)
+		_, err = fmt.Fprintf(w, "{\"target\": %s, \"database\": %q, \"documents\": [", name, EnumDatabase)
+		for count := 0; err == nil && cursor.Next(context.TODO()); count++ {
+			var line []byte
+			line, err = bson.MarshalExtJSON(cursor.Current, true, false)
+			if err != nil {
+				break
+			}
+			separator := "\n"
+			if count > 0 {
+				separator = ",\n"
+			}
+			_, err = w.Write(append([]byte(separator), line...))
+		}
+		if err == nil {
+			err = cursor.Err()
+		}
+		if err == nil {
+			_, err = io.WriteString(w, "\n]}\n")
+		}
+		if err != nil {
+			return fmt.Errorf("[-] Error exporting target: %v", err)
+		}
+		return nil
+	}
+
+	docs := []bson.M{}
+	err = cursor.All(context.TODO(), &docs)
+	if err != nil {
+		return fmt.Errorf("[-] Error exporting target: %v", err)
+	}
+	assets := TargetAssets(docs)
+
+	switch format {
+	case mytypes.TargetFormatCSV:
+		writer := csv.NewWriter(w)
+		err = writer.Write(targetCSVHeader)
+		for _, asset := range assets {
+			if err != nil {
+				break
+			}
+			err = writer.Write([]string{asset.Type, asset.Domain, asset.Subdomain, asset.Path, asset.Parameter})
+		}
+		writer.Flush()
+		if err == nil {
+			err = writer.Error()
+		}
+	case mytypes.TargetFormatSubdomains, mytypes.TargetFormatURLs:
+		lines := []string{}
+		seen := map[string]bool{}
+		for _, asset := range assets {
+			line := ""
+			switch {
+			case format == mytypes.TargetFormatSubdomains && asset.Type == mytypes.AssetSubdomain:
+				line = asset.Subdomain
+			case format == mytypes.TargetFormatURLs && asset.Type == mytypes.AssetDirectory:
+				line = "https://" + asset.Subdomain + asset.Path + "/"
+			case format == mytypes.TargetFormatURLs && asset.Type == mytypes.AssetFile:
+				line = "https://" + asset.Subdomain + asset.Path
+			}
+			if line != "" && !seen[line] {
+				seen[line] = true
+				lines = append(lines, line)
+			}
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			_, err = fmt.Fprintln(w, line)
+			if err != nil {
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("[-] Error exporting target: unknown format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error exporting target: %v", err)
+	}
+
+	return nil
+}
+
+// function readTargetCSV to read the assets of the csv format, the header row gives the column order
+func readTargetCSV(r io.Reader, result *mytypes.ImportResult) ([]mytypes.TargetAsset, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"type", "domain"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("csv header has no %s column", name)
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	assets := []mytypes.TargetAsset{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		assets = append(assets, mytypes.TargetAsset{
+			Type:      field(record, "type"),
+			Domain:    strings.ToLower(field(record, "domain")),
+			Subdomain: strings.ToLower(field(record, "subdomain")),
+			Path:      field(record, "path"),
+			Parameter: field(record, "parameter"),
+		})
+	}
+	return assets, nil
+}
+
+// function urlAsset to find the node of the enum doc tree a url is stored at, the host has to belong to one of the domains, a path ending in '/' is a directory and otherwise its last segment is a file, the paths of a domain's own host aren't part of the tree, returns the asset, the directory holding the url's parameters and an error
+func urlAsset(domains []string, u *url.URL) (mytypes.TargetAsset, string, error) {
+	host := strings.ToLower(u.Hostname())
+	domain := MatchDomain(domains, host)
+	if domain == "" {
+		return mytypes.TargetAsset{}, "", fmt.Errorf("%s doesn't belong to a domain of the target", host)
+	}
+	if host == domain {
+		return mytypes.TargetAsset{Type: mytypes.AssetDomain, Domain: domain}, "", nil
+	}
+	asset := mytypes.TargetAsset{Type: mytypes.AssetSubdomain, Domain: domain, Subdomain: host}
+	dir := u.Path
+	segments := pathSegments(u.Path)
+	switch {
+	case len(segments) == 0:
+	case strings.HasSuffix(u.Path, "/"):
+		asset.Type, asset.Path = mytypes.AssetDirectory, "/"+strings.Join(segments, "/")
+	default:
+		asset.Type, asset.Path = mytypes.AssetFile, "/"+strings.Join(segments, "/")
+		dir = "/" + strings.Join(segments[:len(segments)-1], "/")
+	}
+	return asset, dir, nil
+}
+
+// function readTargetList to read the assets of the subdomains and urls formats, the domain of each host is the most specific domain of the target it belongs to
+func readTargetList(r io.Reader, format string, domains []string, result *mytypes.ImportResult) ([]mytypes.TargetAsset, error) {
+	assets := []mytypes.TargetAsset{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		host := strings.ToLower(strings.TrimSuffix(text, "."))
+		var u *url.URL
+		if format == mytypes.TargetFormatURLs {
+			if !strings.Contains(text, "://") {
+				text = "https://" + text
+			}
+			parsed, err := url.Parse(text)
+			if err != nil || parsed.Hostname() == "" {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("line %d: invalid url", line))
+				continue
+			}
+			u = parsed
+			host = strings.ToLower(parsed.Hostname())
+		}
+		if u == nil {
+			domain := MatchDomain(domains, host)
+			if domain == "" {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("line %d: %s doesn't belong to a domain of the target", line, host))
+				continue
+			}
+			asset := mytypes.TargetAsset{Type: mytypes.AssetDomain, Domain: domain}
+			if host != domain {
+				asset.Type, asset.Subdomain = mytypes.AssetSubdomain, host
+			}
+			assets = append(assets, asset)
+			continue
+		}
+		asset, dir, err := urlAsset(domains, u)
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		assets = append(assets, asset)
+		if asset.Type == mytypes.AssetDomain {
+			continue
+		}
+		domain := asset.Domain
+		keys := []string{}
+		for key := range u.Query() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			assets = append(assets, mytypes.TargetAsset{Type: mytypes.AssetParameter, Domain: domain, Subdomain: host, Path: dir, Parameter: key})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return assets, nil
+}
+
+// function ImportTarget to merge assets in the given format (see ExportTarget) into the enum doc tree of a target, assets that already exist are kept as they are and new ones are added, so importing an export is lossless for json and never removes anything, returns the counts of inserted, merged and unchanged domain documents and an error
+func ImportTarget(client *mongo.Client, target string, format string, r io.Reader) (*mytypes.ImportResult, error) {
+	result := &mytypes.ImportResult{Skipped: []string{}}
+	docs := []bson.D{}
+
+	switch format {
+	case mytypes.TargetFormatJSON:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error importing target: %v", err)
+		}
+		export := struct {
+			Documents []bson.D `bson:"documents"`
+		}{}
+		err = bson.UnmarshalExtJSON(data, true, &export)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error importing target: %v", err)
+		}
+		docs = export.Documents
+	case mytypes.TargetFormatCSV, mytypes.TargetFormatSubdomains, mytypes.TargetFormatURLs:
+		var assets []mytypes.TargetAsset
+		var err error
+		if format == mytypes.TargetFormatCSV {
+			assets, err = readTargetCSV(r, result)
+		} else {
+			domains, derr := GetTargetDomains(client, target)
+			if derr != nil {
+				return nil, fmt.Errorf("[-] Error importing target: %v", derr)
+			}
+			assets, err = readTargetList(r, format, domains, result)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error importing target: %v", err)
+		}
+
+		// the assets of a domain are merged together first so each domain document is written once
+		bydomain := map[string]bson.D{}
+		order := []string{}
+		for i, asset := range assets {
+			doc, err := assetDocument(asset)
+			if err != nil {
+				result.Skipped = append(result.Skipped, fmt.Sprintf("asset %d: %v", i+1, err))
+				continue
+			}
+			if _, ok := bydomain[asset.Domain]; !ok {
+				order = append(order, asset.Domain)
+			}
+			bydomain[asset.Domain], _ = mergeNode(bydomain[asset.Domain], doc)
+		}
+		for _, domain := range order {
+			docs = append(docs, bydomain[domain])
+		}
+	default:
+		return nil, fmt.Errorf("[-] Error importing target: unknown format %q", format)
+	}
+
+	err := ensureEnumIndexes(client, EnumDatabase, target)
+	if err != nil {
+		return result, fmt.Errorf("[-] Error importing target: %v", err)
+	}
+	coll := client.Database(EnumDatabase).Collection(target)
+	for _, doc := range docs {
+		status, err := mergeDocument(coll, stampProvenance(doc))
+		if err != nil {
+			return result, fmt.Errorf("[-] Error importing target: %v", err)
+		}
+		switch status {
+		case "inserted":
+			result.Inserted++
+		case "merged":
+			result.Merged++
+		default:
+			result.Unchanged++
+		}
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, EnumDatabase, target, nil, nil, nil, int64(result.Inserted+result.Merged), nil)
+	fmt.Printf("[+] Imported target successfully: %d inserted, %d merged, %d unchanged, %d skipped\n", result.Inserted, result.Merged, result.Unchanged, len(result.Skipped))
+
+	return result, nil
+}
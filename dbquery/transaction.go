@@ -0,0 +1,113 @@
+package dbquery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Transactions             ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// how many times a transaction is run again after a transient error, and the wait before the first retry, doubled every time
+var (
+	TransactionRetries = 5
+	transactionBackoff = 50 * time.Millisecond
+)
+
+// whether WithTransaction may run fn without a transaction on a standalone server, off by default since callers rely on the writes being atomic
+var AllowNonTransactional = false
+
+// whether the server of a client supports transactions, by client
+var transactionSupport sync.Map
+
+// function hasErrorLabel to check whether a server error carries the given label (TransientTransactionError, UnknownTransactionCommitResult)
+func hasErrorLabel(err error, label string) bool {
+	var labeled interface{ HasErrorLabel(string) bool }
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+// function supportsTransactions to check whether the server is a replica set member or a mongos, standalone servers can't run transactions, the answer is kept per client
+func supportsTransactions(ctx context.Context, client *mongo.Client) bool {
+	if supported, ok := transactionSupport.Load(client); ok {
+		return supported.(bool)
+	}
+	hello := bson.M{}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		// don't cache, the server may just be unreachable right now
+		return false
+	}
+	_, replicaset := hello["setName"]
+	supported := replicaset || hello["msg"] == "isdbgrid"
+	transactionSupport.Store(client, supported)
+	return supported
+}
+
+// function WithTransaction to run fn inside a session transaction, every operation in fn has to use tx as its context to be part of it, fn is run again (with a backoff) when the transaction fails with a transient error such as a write conflict and the commit is retried when its result is unknown, so fn must not have side effects outside the database, on a standalone server it fails unless AllowNonTransactional is set and then fn runs in a session without a transaction, returns the error of fn or of the commit
+func WithTransaction(ctx context.Context, client *mongo.Client, fn func(tx mongo.SessionContext) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return fmt.Errorf("[-] Error starting session: %v", err)
+	}
+	defer session.EndSession(ctx)
+
+	if !supportsTransactions(ctx, client) {
+		if !AllowNonTransactional {
+			return fmt.Errorf("[-] Error starting transaction: the server doesn't support transactions (not a replica set or mongos, or unreachable), set allow_non_transactional in the config to run without them")
+		}
+		return fn(mongo.NewSessionContext(ctx, session))
+	}
+
+	opts := options.Transaction().
+		SetReadConcern(readconcern.Snapshot()).
+		SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	backoff := transactionBackoff
+	for attempt := 0; ; attempt++ {
+		err = session.StartTransaction(opts)
+		if err != nil {
+			return fmt.Errorf("[-] Error starting transaction: %v", err)
+		}
+		tx := mongo.NewSessionContext(ctx, session)
+		err = fn(tx)
+		if err == nil {
+			err = commitTransaction(tx, session)
+		}
+		if err == nil {
+			return nil
+		}
+		session.AbortTransaction(ctx)
+		if !hasErrorLabel(err, "TransientTransactionError") || attempt >= TransactionRetries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// function commitTransaction to commit the transaction of the session, the commit is retried as long as its result is unknown, returns an error
+func commitTransaction(ctx context.Context, session mongo.Session) error {
+	for attempt := 0; ; attempt++ {
+		err := session.CommitTransaction(ctx)
+		if err == nil || !hasErrorLabel(err, "UnknownTransactionCommitResult") || attempt >= TransactionRetries {
+			return err
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Updates                  ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// function IDFilter to build the filter matching a document id, a 24 character hex string matches both the ObjectID and a string _id, other values (ObjectIDs, strings, numbers) match the _id as they are, returns the filter and an error
+func IDFilter(id interface{}) (bson.M, error) {
+	switch v := id.(type) {
+	case nil:
+		return nil, fmt.Errorf("id is required")
+	case primitive.ObjectID:
+		return bson.M{"_id": v}, nil
+	case string:
+		if v == "" {
+			return nil, fmt.Errorf("id is required")
+		}
+		if objectid, err := primitive.ObjectIDFromHex(v); err == nil {
+			// the id could have been stored either way
+			return bson.M{"_id": bson.M{"$in": bson.A{objectid, v}}}, nil
+		}
+		return bson.M{"_id": v}, nil
+	}
+	return bson.M{"_id": id}, nil
+}
+
+// function toDocument to convert a json string (extended json is accepted), a bson document or a struct to an ordered document
+func toDocument(value interface{}) (bson.D, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, fmt.Errorf("document is required")
+	case string:
+		doc := bson.D{}
+		err := bson.UnmarshalExtJSON([]byte(v), false, &doc)
+		return doc, err
+	case bson.D:
+		return v, nil
+	}
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.D{}
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// function toUpdate to check an update, a document made of update operators ($set, $unset, $inc, $push, $addToSet, ...) and an aggregation pipeline (json array or mongo.Pipeline) are used as they are and a document of plain fields is $set, returns the update and an error
+func toUpdate(update interface{}) (interface{}, error) {
+	switch v := update.(type) {
+	case mongo.Pipeline, bson.A, []bson.D:
+		return v, nil
+	case string:
+		if strings.HasPrefix(strings.TrimSpace(v), "[") {
+			wrapper := struct {
+				Pipeline []bson.D `bson:"pipeline"`
+			}{}
+			err := bson.UnmarshalExtJSON([]byte(`{"pipeline": `+v+`}`), false, &wrapper)
+			if err != nil {
+				return nil, err
+			}
+			return wrapper.Pipeline, nil
+		}
+	}
+	doc, err := toDocument(update)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("update is empty")
+	}
+	operators := 0
+	for _, e := range doc {
+		if strings.HasPrefix(e.Key, "$") {
+			operators++
+		}
+	}
+	switch operators {
+	case len(doc):
+		return doc, nil
+	case 0:
+		return bson.D{{Key: "$set", Value: doc}}, nil
+	}
+	return nil, fmt.Errorf("update mixes operators and plain fields")
+}
+
+// function updateResult to convert the result of the driver
+func updateResult(result *mongo.UpdateResult) *mytypes.UpdateResult {
+	return &mytypes.UpdateResult{
+		Matched:    result.MatchedCount,
+		Modified:   result.ModifiedCount,
+		Upserted:   result.UpsertedCount,
+		UpsertedID: result.UpsertedID,
+	}
+}
+
+// function auditedWrite to run a single document write, when auditing is enabled the document is read before and after the write so the audit record carries the diff
+func auditedWrite(client *mongo.Client, database string, collection string, operation string, filter interface{}, write func() (*mongo.UpdateResult, error)) (*mytypes.UpdateResult, error) {
+	coll := client.Database(database).Collection(collection)
+	var before bson.M
+	if AuditEnabled {
+		coll.FindOne(context.TODO(), filter).Decode(&before)
+	}
+	res, err := write()
+	if err != nil {
+		auditOperation(context.TODO(), client, operation, database, collection, filter, nil, nil, 0, err)
+		return nil, err
+	}
+	result := updateResult(res)
+	if AuditEnabled {
+		id := result.UpsertedID
+		if before != nil {
+			id = before["_id"]
+		}
+		var after bson.M
+		if id != nil {
+			coll.FindOne(context.TODO(), bson.M{"_id": id}).Decode(&after)
+		}
+		auditOperation(context.TODO(), client, operation, database, collection, filter, before, after, result.Modified+result.Upserted, nil)
+	}
+	return result, nil
+}
+
+// function UpdateOne to update the first document matching the filter, see toUpdate for the accepted updates, with upsert a document is inserted if none matches, returns the matched/modified/upserted counts and an error
+func UpdateOne(client *mongo.Client, database string, collection string, filter interface{}, update interface{}, upsert bool) (*mytypes.UpdateResult, error) {
+	u, err := toUpdate(update)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating document: %v", err)
+	}
+	result, err := auditedWrite(client, database, collection, mytypes.AuditUpdate, filter, func() (*mongo.UpdateResult, error) {
+		return client.Database(database).Collection(collection).UpdateOne(context.TODO(), filter, u, options.Update().SetUpsert(upsert))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating document: %v", err)
+	}
+
+	return result, nil
+}
+
+// function UpdateMany to update every document matching the filter, see toUpdate for the accepted updates, with upsert a document is inserted if none matches, returns the matched/modified/upserted counts and an error
+func UpdateMany(client *mongo.Client, database string, collection string, filter interface{}, update interface{}, upsert bool) (*mytypes.UpdateResult, error) {
+	u, err := toUpdate(update)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating documents: %v", err)
+	}
+	res, err := client.Database(database).Collection(collection).UpdateMany(context.TODO(), filter, u, options.Update().SetUpsert(upsert))
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, database, collection, filter, nil, nil, 0, err)
+		return nil, fmt.Errorf("[-] Error updating documents: %v", err)
+	}
+	result := updateResult(res)
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, database, collection, filter, nil, nil, result.Modified+result.Upserted, nil)
+
+	return result, nil
+}
+
+// function ReplaceOne to replace the first document matching the filter with the given document (json string, bson document or struct, without update operators), its _id is kept, with upsert the document is inserted if none matches, returns the matched/modified/upserted counts and an error
+func ReplaceOne(client *mongo.Client, database string, collection string, filter interface{}, replacement interface{}, upsert bool) (*mytypes.UpdateResult, error) {
+	doc, err := toDocument(replacement)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error replacing document: %v", err)
+	}
+	for _, e := range doc {
+		if strings.HasPrefix(e.Key, "$") {
+			return nil, fmt.Errorf("[-] Error replacing document: a replacement can't contain the operator %s", e.Key)
+		}
+	}
+	result, err := auditedWrite(client, database, collection, mytypes.AuditUpdate, filter, func() (*mongo.UpdateResult, error) {
+		return client.Database(database).Collection(collection).ReplaceOne(context.TODO(), filter, doc, options.Replace().SetUpsert(upsert))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error replacing document: %v", err)
+	}
+
+	return result, nil
+}
+
+// function FindOneAndUpdate to atomically update the first document matching the filter and get it as it was before the update, or after it with returnafter, see toUpdate for the accepted updates, when an upsert inserts a document there is nothing before it so the inserted document is returned either way, returns the document (nil if none matches without upsert) and an error
+func FindOneAndUpdate(client *mongo.Client, database string, collection string, filter interface{}, update interface{}, upsert bool, returnafter bool) (bson.M, error) {
+	u, err := toUpdate(update)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating document: %v", err)
+	}
+	coll := client.Database(database).Collection(collection)
+	var before bson.M
+	if AuditEnabled && returnafter {
+		coll.FindOne(context.TODO(), filter).Decode(&before)
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(upsert).SetReturnDocument(options.Before)
+	if returnafter {
+		opts.SetReturnDocument(options.After)
+	}
+	var document bson.M
+	err = coll.FindOneAndUpdate(context.TODO(), filter, u, opts).Decode(&document)
+	if err == mongo.ErrNoDocuments && upsert && !returnafter {
+		// the upsert inserted a document, read it back since the driver only returns the (missing) one before
+		err = coll.FindOne(context.TODO(), filter).Decode(&document)
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, database, collection, filter, nil, document, 1, nil)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error reading upserted document: %v", err)
+		}
+		return document, nil
+	}
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, database, collection, filter, nil, nil, 0, err)
+		return nil, fmt.Errorf("[-] Error updating document: %v", err)
+	}
+
+	if AuditEnabled {
+		operation := mytypes.AuditUpdate
+		if returnafter && before == nil && upsert {
+			operation = mytypes.AuditInsert
+		}
+		after := document
+		if !returnafter {
+			before = document
+			after = nil
+			coll.FindOne(context.TODO(), bson.M{"_id": document["_id"]}).Decode(&after)
+		}
+		auditOperation(context.TODO(), client, operation, database, collection, filter, before, after, 1, nil)
+	}
+
+	return document, nil
+}
@@ -0,0 +1,276 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Panel users              ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the safe-panel database and its collections
+var (
+	PanelDatabase = "safe-panel"
+	PanelUsers    = "users"
+	PanelRoles    = "roles"
+	PanelTokens   = "tokens"
+)
+
+// projection that keeps the password hash out of the audit records
+var userAuditProjection = bson.M{"passwd_hash": 0}
+
+// hash verified against when the user doesn't exist, so a login takes as long for unknown users as for known ones
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// function CreateUser to create a panel user with an argon2id password hash, duplicate usernames and emails are rejected by the unique indexes, the creation is audited under the actor of ctx, returns the id of the user and an error
+func CreateUser(ctx context.Context, client *mongo.Client, username string, email string, password string) (string, error) {
+	if username == "" || email == "" || password == "" {
+		return "", fmt.Errorf("[-] Error creating user: username, email and password are required")
+	}
+	// the duplicates are only rejected once the declared unique indexes exist
+	err := ensureIndexes(client, PanelDatabase, PanelUsers)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating user: %v", err)
+	}
+	hash, err := myutils.HashPassword(password)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating user: %v", err)
+	}
+	now := time.Now().UTC()
+	user := mytypes.PanelUser{
+		Username:          username,
+		Email:             email,
+		PasswdHash:        hash,
+		Roles:             []string{},
+		PasswordChangedAt: &now,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	result, err := client.Database(PanelDatabase).Collection(PanelUsers).InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return "", fmt.Errorf("[-] Error creating user: username or email already exists")
+	}
+	if err != nil {
+		return "", fmt.Errorf("[-] Error creating user: %v", err)
+	}
+	auditOperation(ctx, client, mytypes.AuditInsert, PanelDatabase, PanelUsers, nil, nil, bson.M{"_id": result.InsertedID, "username": username, "email": email, "roles": user.Roles}, 1, nil)
+	fmt.Println("[+] Created user successfully")
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// function GetUser to get the panel user with the given username, returns a pointer to the user and an error
+func GetUser(client *mongo.Client, username string) (*mytypes.PanelUser, error) {
+	user := &mytypes.PanelUser{}
+	err := client.Database(PanelDatabase).Collection(PanelUsers).FindOne(context.TODO(), bson.M{"username": username}).Decode(user)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting user: %v", err)
+	}
+
+	return user, nil
+}
+
+// function AuthenticateUser to check the password of a panel user in constant time, disabled users never authenticate, on success a hash made with old parameters or the legacy sha256 scheme is replaced by a fresh argon2id hash, returns the user (nil if the credentials are wrong) and an error
+func AuthenticateUser(client *mongo.Client, username string, password string) (*mytypes.PanelUser, error) {
+	coll := client.Database(PanelDatabase).Collection(PanelUsers)
+	user := &mytypes.PanelUser{}
+	err := coll.FindOne(context.TODO(), bson.M{"username": username}).Decode(user)
+	if err == mongo.ErrNoDocuments {
+		dummyHashOnce.Do(func() { dummyHash, _ = myutils.HashPassword("healerdb-dummy-password") })
+		myutils.VerifyPassword(password, dummyHash)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error authenticating user: %v", err)
+	}
+
+	ok, err := myutils.VerifyPassword(password, user.PasswdHash)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error authenticating user: %v", err)
+	}
+	if !ok || user.Disabled {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	// the old hash is part of the filter so a concurrent password change is never overwritten by the rehash
+	filter := bson.M{"_id": user.ID, "passwd_hash": user.PasswdHash}
+	set := bson.M{"last_login": now}
+	if myutils.NeedsRehash(user.PasswdHash) {
+		hash, err := myutils.HashPassword(password)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error rehashing password: %v", err)
+		}
+		set["passwd_hash"] = hash
+		set["updated_at"] = now
+		user.PasswdHash = hash
+	}
+	_, err = coll.UpdateOne(context.TODO(), filter, bson.M{"$set": set})
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error updating user after login: %v", err)
+	}
+	user.LastLogin = &now
+
+	return user, nil
+}
+
+// function SetUserPassword to replace the password of a panel user, the change (not the hash) is audited under the actor of ctx, returns an error
+func SetUserPassword(ctx context.Context, client *mongo.Client, username string, password string) error {
+	if password == "" {
+		return fmt.Errorf("[-] Error setting password: password is empty")
+	}
+	hash, err := myutils.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("[-] Error setting password: %v", err)
+	}
+	now := time.Now().UTC()
+	set := bson.M{"password_changed_at": now, "updated_at": now}
+	update := bson.M{"$set": bson.M{"passwd_hash": hash, "password_changed_at": now, "updated_at": now}}
+	before, err := updateOneAudited(ctx, client, PanelDatabase, PanelUsers, bson.M{"username": username}, set, update)
+	if err != nil {
+		return fmt.Errorf("[-] Error setting password: %v", err)
+	}
+	if before == nil {
+		return fmt.Errorf("[-] Error setting password: user doesn't exist")
+	}
+	fmt.Println("[+] Set password successfully")
+
+	return nil
+}
+
+// function MigrateLegacyPasswordHashes to wrap every unsalted sha256 password hash with argon2id so the stored hashes are safe before the users log in again (the login then replaces them with a plain argon2id hash), returns the number of migrated users and an error
+func MigrateLegacyPasswordHashes(client *mongo.Client) (int, error) {
+	coll := client.Database(PanelDatabase).Collection(PanelUsers)
+	filter := bson.M{"passwd_hash": bson.M{"$regex": "^[0-9a-fA-F]{64}$"}}
+	cursor, err := coll.Find(context.TODO(), filter)
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error migrating password hashes: %v", err)
+	}
+	users := []mytypes.PanelUser{}
+	err = cursor.All(context.TODO(), &users)
+	if err != nil {
+		return 0, fmt.Errorf("[-] Error migrating password hashes: %v", err)
+	}
+
+	migrated := 0
+	for _, user := range users {
+		wrapped, err := myutils.WrapLegacyPasswordHash(user.PasswdHash)
+		if err != nil {
+			return migrated, fmt.Errorf("[-] Error migrating password hash of %s: %v", user.Username, err)
+		}
+		result, err := coll.UpdateOne(context.TODO(),
+			bson.M{"_id": user.ID, "passwd_hash": user.PasswdHash},
+			bson.M{"$set": bson.M{"passwd_hash": wrapped, "updated_at": time.Now().UTC()}},
+		)
+		if err != nil {
+			return migrated, fmt.Errorf("[-] Error migrating password hash of %s: %v", user.Username, err)
+		}
+		migrated += int(result.ModifiedCount)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, PanelDatabase, PanelUsers, filter, nil, nil, int64(migrated), nil)
+	fmt.Printf("[+] Migrated password hashes successfully: %d users\n", migrated)
+
+	return migrated, nil
+}
+
+// function GetUsers to get all the panel users, returns a slice of users and an error
+func GetUsers(client *mongo.Client) ([]mytypes.PanelUser, error) {
+	cursor, err := client.Database(PanelDatabase).Collection(PanelUsers).Find(context.TODO(), skipMarker(nil))
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting users: %v", err)
+	}
+	users := []mytypes.PanelUser{}
+	err = cursor.All(context.TODO(), &users)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding users: %v", err)
+	}
+
+	return users, nil
+}
+
+// function updateUser to apply a $set to the panel user with the given username and audit the changed fields under the actor of ctx, fails if the user doesn't exist
+func updateUser(ctx context.Context, client *mongo.Client, username string, set bson.M) error {
+	set["updated_at"] = time.Now().UTC()
+	before, err := updateOneAudited(ctx, client, PanelDatabase, PanelUsers, bson.M{"username": username}, set, nil)
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return fmt.Errorf("user doesn't exist")
+	}
+
+	return nil
+}
+
+// function SetUserDisabled to disable or re-enable a panel user, a disabled user can't log in and its api tokens are rejected, the change is audited under the actor of ctx, returns an error
+func SetUserDisabled(ctx context.Context, client *mongo.Client, username string, disabled bool) error {
+	err := updateUser(ctx, client, username, bson.M{"disabled": disabled})
+	if err != nil {
+		return fmt.Errorf("[-] Error setting user state: %v", err)
+	}
+	fmt.Println("[+] Set user state successfully")
+
+	return nil
+}
+
+// function SetUserRoles to replace the roles of a panel user, every role must exist, the change is audited under the actor of ctx, returns an error
+func SetUserRoles(ctx context.Context, client *mongo.Client, username string, roles []string) error {
+	if roles == nil {
+		roles = []string{}
+	}
+	count, err := client.Database(PanelDatabase).Collection(PanelRoles).CountDocuments(ctx, bson.M{"name": bson.M{"$in": roles}})
+	if err != nil {
+		return fmt.Errorf("[-] Error setting user roles: %v", err)
+	}
+	if int(count) != len(roles) {
+		return fmt.Errorf("[-] Error setting user roles: unknown role in %v", roles)
+	}
+	err = updateUser(ctx, client, username, bson.M{"roles": roles})
+	if err != nil {
+		return fmt.Errorf("[-] Error setting user roles: %v", err)
+	}
+	fmt.Println("[+] Set user roles successfully")
+
+	return nil
+}
+
+// function DeleteUser to delete a panel user together with its api tokens, both deletions are audited under the actor of ctx, returns an error
+func DeleteUser(ctx context.Context, client *mongo.Client, username string) error {
+	filter := bson.M{"username": username}
+	before := bson.M{}
+	err := client.Database(PanelDatabase).Collection(PanelUsers).FindOneAndDelete(ctx, filter, options.FindOneAndDelete().SetProjection(userAuditProjection)).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("[-] Error deleting user: user doesn't exist")
+	}
+	if err != nil {
+		return fmt.Errorf("[-] Error deleting user: %v", err)
+	}
+	auditOperation(ctx, client, mytypes.AuditDelete, PanelDatabase, PanelUsers, filter, before, nil, 1, nil)
+	result, err := client.Database(PanelDatabase).Collection(PanelTokens).DeleteMany(ctx, filter)
+	if err != nil {
+		auditOperation(ctx, client, mytypes.AuditDelete, PanelDatabase, PanelTokens, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error deleting user tokens: %v", err)
+	}
+	auditOperation(ctx, client, mytypes.AuditDelete, PanelDatabase, PanelTokens, filter, nil, nil, result.DeletedCount, nil)
+	fmt.Println("[+] Deleted user successfully")
+
+	return nil
+}
@@ -0,0 +1,554 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+	"healerdb/myutils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Watch                    ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// names of the databases used by the watch functions, both are target based so the collection is the target name
+var (
+	EnumDatabase  = "enum"
+	WatchDatabase = "watch"
+)
+
+// how many assets go in one chunk of a snapshot, well below the 16MB document limit even with long keys
+var snapshotChunkSize = 5000
+
+// names of the child arrays in the enum doc tree, they are not part of an asset's own hash
+var enumTreeChildren = []string{"subdomains", "directories", "subdirectories", "files", "parameters"}
+
+// function toM to convert a decoded bson value to a bson.M, returns the map and whether the value was a document
+func toM(v interface{}) (bson.M, bool) {
+	switch doc := v.(type) {
+	case bson.M:
+		return doc, true
+	case primitive.D:
+		return doc.Map(), true
+	case map[string]interface{}:
+		return bson.M(doc), true
+	}
+	return nil, false
+}
+
+// function toA to convert a decoded bson value to a slice, returns nil if the value is not an array
+func toA(v interface{}) []interface{} {
+	switch arr := v.(type) {
+	case primitive.A:
+		return arr
+	case []interface{}:
+		return arr
+	}
+	return nil
+}
+
+// function enumNodeName to get the name of a node in the enum doc tree, a node is either a plain string or a document with the name in the given field, returns the name and the node as a document (nil for plain strings)
+func enumNodeName(node interface{}, fields ...string) (string, bson.M) {
+	if name, ok := node.(string); ok {
+		return name, nil
+	}
+	doc, ok := toM(node)
+	if !ok {
+		return "", nil
+	}
+	for _, field := range fields {
+		if name, ok := doc[field].(string); ok {
+			return name, doc
+		}
+	}
+	return "", doc
+}
+
+// function enumNodeHash to hash the own fields of a node in the enum doc tree (everything but its child arrays, its provenance and its tags, seeing or tagging an asset doesn't change it), returns the hash as string
+func enumNodeHash(name string, doc bson.M) string {
+	keys := []string{}
+	for k := range doc {
+		if k == "_id" || myutils.ContainsString(enumTreeChildren, k) || myutils.ContainsString(enumProvenanceFields, k) || myutils.ContainsString(enumTagFields, k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := []string{name}
+	for _, k := range keys {
+		value := doc[k]
+		if records, ok := toM(value); k == "dns" && ok {
+			// resolving again without any change isn't a change
+			resolution := bson.M{}
+			for field, v := range records {
+				if field != "resolved_at" {
+					resolution[field] = v
+				}
+			}
+			value = resolution
+		}
+		parts = append(parts, fmt.Sprintf("%s=%v", k, value))
+	}
+	return myutils.HashString(strings.Join(parts, "\n"))
+}
+
+// function collectDirectoryAssets to walk a directory node (and its subdirectories) of the enum doc tree, appends the directories, files and parameters to the given assets and returns them
+func collectDirectoryAssets(assets []mytypes.WatchAsset, parent string, node interface{}) []mytypes.WatchAsset {
+	name, doc := enumNodeName(node, "directory", "subdirectory")
+	if name == "" {
+		return assets
+	}
+	path := parent + "/" + strings.Trim(name, "/")
+	assets = append(assets, mytypes.WatchAsset{Type: mytypes.AssetDirectory, Key: path, Hash: enumNodeHash(name, doc)})
+	if doc == nil {
+		return assets
+	}
+	for _, file := range toA(doc["files"]) {
+		fname, fdoc := enumNodeName(file, "file", "name")
+		if fname != "" {
+			assets = append(assets, mytypes.WatchAsset{Type: mytypes.AssetFile, Key: path + "/" + strings.TrimLeft(fname, "/"), Hash: enumNodeHash(fname, fdoc)})
+		}
+	}
+	for _, param := range toA(doc["parameters"]) {
+		pname, pdoc := enumNodeName(param, "parameter", "name")
+		if pname != "" {
+			assets = append(assets, mytypes.WatchAsset{Type: mytypes.AssetParameter, Key: path + "?" + pname, Hash: enumNodeHash(pname, pdoc)})
+		}
+	}
+	for _, subdir := range toA(doc["subdirectories"]) {
+		assets = collectDirectoryAssets(assets, path, subdir)
+	}
+	return assets
+}
+
+// function CollectEnumAssets to flatten the domain documents of the enum doc tree into a slice of assets (domains, subdomains, directories, files and parameters)
+func CollectEnumAssets(docs []bson.M) []mytypes.WatchAsset {
+	assets := []mytypes.WatchAsset{}
+	for _, doc := range docs {
+		domain, _ := doc["domain"].(string)
+		if domain == "" {
+			continue
+		}
+		assets = append(assets, mytypes.WatchAsset{Type: mytypes.AssetDomain, Key: domain, Hash: enumNodeHash(domain, doc)})
+		for _, sub := range toA(doc["subdomains"]) {
+			subname, subdoc := enumNodeName(sub, "subdomain")
+			if subname == "" {
+				continue
+			}
+			assets = append(assets, mytypes.WatchAsset{Type: mytypes.AssetSubdomain, Key: subname, Hash: enumNodeHash(subname, subdoc)})
+			if subdoc == nil {
+				continue
+			}
+			for _, dir := range toA(subdoc["directories"]) {
+				assets = collectDirectoryAssets(assets, subname, dir)
+			}
+		}
+	}
+	return assets
+}
+
+// function DiffSnapshots to compute the added, removed and changed assets between an old and a new snapshot, old may be nil for the first run
+func DiffSnapshots(old *mytypes.WatchSnapshot, new *mytypes.WatchSnapshot) mytypes.WatchDiff {
+	diff := mytypes.WatchDiff{
+		Added:   []mytypes.WatchAsset{},
+		Removed: []mytypes.WatchAsset{},
+		Changed: []mytypes.WatchAsset{},
+	}
+	oldassets := map[string]mytypes.WatchAsset{}
+	if old != nil {
+		for _, asset := range old.Assets {
+			oldassets[asset.Type+"\x00"+asset.Key] = asset
+		}
+	}
+	seen := map[string]bool{}
+	for _, asset := range new.Assets {
+		key := asset.Type + "\x00" + asset.Key
+		seen[key] = true
+		oldasset, ok := oldassets[key]
+		if !ok {
+			diff.Added = append(diff.Added, asset)
+		} else if oldasset.Hash != asset.Hash {
+			diff.Changed = append(diff.Changed, asset)
+		}
+	}
+	if old != nil {
+		for _, asset := range old.Assets {
+			if !seen[asset.Type+"\x00"+asset.Key] {
+				diff.Removed = append(diff.Removed, asset)
+			}
+		}
+	}
+	return diff
+}
+
+// function collectSnapshot to build a snapshot of the asset inventory of a target from the enum database, the snapshot gets its id but isn't stored, returns a pointer to the snapshot and an error
+func collectSnapshot(client *mongo.Client, target string, runid string) (*mytypes.WatchSnapshot, error) {
+	cursor, err := client.Database(EnumDatabase).Collection(target).Find(context.TODO(), bson.M{"domain": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	docs := []bson.M{}
+	err = cursor.All(context.TODO(), &docs)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &mytypes.WatchSnapshot{
+		ID:      primitive.NewObjectID(),
+		Kind:    mytypes.WatchKindSnapshot,
+		Target:  target,
+		RunID:   runid,
+		TakenAt: time.Now().UTC(),
+		Counts:  map[string]int{},
+		Assets:  CollectEnumAssets(docs),
+	}
+	for _, asset := range snapshot.Assets {
+		snapshot.Counts[asset.Type]++
+	}
+	return snapshot, nil
+}
+
+// function insertChunks to insert the chunk documents of a snapshot or an event one at a time (a batch of them could exceed the message size limit), the ones already inserted are removed again with the cleanup filter when one fails, the assets are left out of the audit record, returns an error
+func insertChunks(client *mongo.Client, target string, chunks []interface{}, cleanup bson.M) error {
+	coll := client.Database(WatchDatabase).Collection(target)
+	for i, chunk := range chunks {
+		_, err := coll.InsertOne(context.TODO(), chunk)
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditInsert, WatchDatabase, target, nil, nil, nil, int64(i), err)
+			removeWatchDocuments(client, target, cleanup)
+			return err
+		}
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, WatchDatabase, target, nil, nil, nil, int64(len(chunks)), nil)
+	return nil
+}
+
+// function removeWatchDocuments to remove the documents of a partly stored snapshot or event again
+func removeWatchDocuments(client *mongo.Client, target string, filter bson.M) {
+	result, err := client.Database(WatchDatabase).Collection(target).DeleteMany(context.TODO(), filter)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditDelete, WatchDatabase, target, filter, nil, nil, 0, err)
+		return
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditDelete, WatchDatabase, target, filter, nil, nil, result.DeletedCount, nil)
+}
+
+// function snapshotChunks to split the assets of a snapshot into its chunk documents
+func snapshotChunks(snapshot *mytypes.WatchSnapshot) []interface{} {
+	chunks := []interface{}{}
+	for start := 0; start < len(snapshot.Assets); start += snapshotChunkSize {
+		end := start + snapshotChunkSize
+		if end > len(snapshot.Assets) {
+			end = len(snapshot.Assets)
+		}
+		chunks = append(chunks, mytypes.WatchSnapshotChunk{Kind: mytypes.WatchKindSnapshotChunk, SnapshotID: snapshot.ID, Seq: len(chunks), Assets: snapshot.Assets[start:end]})
+	}
+	return chunks
+}
+
+// function eventChunks to split the diff of an event into its chunk documents, each holding at most snapshotChunkSize assets of the added, removed and changed ones in that order
+func eventChunks(event *mytypes.WatchEvent) []interface{} {
+	chunks := []interface{}{}
+	current := mytypes.WatchDiff{Added: []mytypes.WatchAsset{}, Removed: []mytypes.WatchAsset{}, Changed: []mytypes.WatchAsset{}}
+	size := 0
+	flush := func() {
+		chunks = append(chunks, mytypes.WatchEventChunk{Kind: mytypes.WatchKindEventChunk, EventID: event.ID, Seq: len(chunks), WatchDiff: current})
+		current = mytypes.WatchDiff{Added: []mytypes.WatchAsset{}, Removed: []mytypes.WatchAsset{}, Changed: []mytypes.WatchAsset{}}
+		size = 0
+	}
+	for list, assets := range [][]mytypes.WatchAsset{event.Added, event.Removed, event.Changed} {
+		for _, asset := range assets {
+			if size == snapshotChunkSize {
+				flush()
+			}
+			switch list {
+			case 0:
+				current.Added = append(current.Added, asset)
+			case 1:
+				current.Removed = append(current.Removed, asset)
+			default:
+				current.Changed = append(current.Changed, asset)
+			}
+			size++
+		}
+	}
+	if size > 0 {
+		flush()
+	}
+	return chunks
+}
+
+// function storeSnapshotChunks to store the assets of a snapshot as its chunk documents, returns the number of chunks and an error
+func storeSnapshotChunks(client *mongo.Client, target string, snapshot *mytypes.WatchSnapshot) (int, error) {
+	err := ensureIndexes(client, WatchDatabase, target)
+	if err != nil {
+		return 0, err
+	}
+	chunks := snapshotChunks(snapshot)
+	err = insertChunks(client, target, chunks, bson.M{"kind": mytypes.WatchKindSnapshotChunk, "snapshot_id": snapshot.ID})
+	return len(chunks), err
+}
+
+// function storeSnapshotHeader to insert the snapshot document once its chunks are stored, its chunks are removed if that fails, returns an error
+func storeSnapshotHeader(client *mongo.Client, target string, snapshot *mytypes.WatchSnapshot, chunks int) error {
+	header := *snapshot
+	header.Chunks = chunks
+	header.Assets = nil
+	_, err := client.Database(WatchDatabase).Collection(target).InsertOne(context.TODO(), header)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, WatchDatabase, target, nil, nil, nil, 0, err)
+		removeWatchDocuments(client, target, bson.M{"kind": mytypes.WatchKindSnapshotChunk, "snapshot_id": snapshot.ID})
+		return err
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, WatchDatabase, target, nil, nil, auditDocument(header), 1, nil)
+	snapshot.Chunks = chunks
+	return nil
+}
+
+// function TakeSnapshot to record a snapshot of the asset inventory of a target from the enum database into the watch database, the assets are stored in chunks next to the snapshot document so a large target doesn't hit the document size limit, the snapshot document is inserted last so a partly stored snapshot is never read, returns a pointer to the snapshot and an error
+func TakeSnapshot(client *mongo.Client, target string, runid string) (*mytypes.WatchSnapshot, error) {
+	snapshot, err := collectSnapshot(client, target, runid)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error taking snapshot: %v", err)
+	}
+	chunks, err := storeSnapshotChunks(client, target, snapshot)
+	if err == nil {
+		err = storeSnapshotHeader(client, target, snapshot, chunks)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error storing snapshot: %v", err)
+	}
+	fmt.Println("[+] Took snapshot successfully")
+
+	return snapshot, nil
+}
+
+// function loadSnapshotAssets to read the assets of a snapshot back from its chunks, snapshots stored before chunking keep theirs inline, returns an error
+func loadSnapshotAssets(client *mongo.Client, target string, snapshot *mytypes.WatchSnapshot) error {
+	if snapshot.Chunks == 0 {
+		if snapshot.Assets == nil {
+			snapshot.Assets = []mytypes.WatchAsset{}
+		}
+		return nil
+	}
+	chunks := []mytypes.WatchSnapshotChunk{}
+	err := findChunks(client, target, bson.M{"kind": mytypes.WatchKindSnapshotChunk, "snapshot_id": snapshot.ID}, &chunks)
+	if err != nil {
+		return err
+	}
+	if len(chunks) != snapshot.Chunks {
+		return fmt.Errorf("snapshot %s has %d of its %d chunks", snapshot.ID.Hex(), len(chunks), snapshot.Chunks)
+	}
+	snapshot.Assets = []mytypes.WatchAsset{}
+	for _, chunk := range chunks {
+		snapshot.Assets = append(snapshot.Assets, chunk.Assets...)
+	}
+	return nil
+}
+
+// function loadEventDiff to read the diff of an event back from its chunks, events stored before chunking keep theirs inline, returns an error
+func loadEventDiff(client *mongo.Client, target string, event *mytypes.WatchEvent) error {
+	if event.Chunks > 0 {
+		chunks := []mytypes.WatchEventChunk{}
+		err := findChunks(client, target, bson.M{"kind": mytypes.WatchKindEventChunk, "event_id": event.ID}, &chunks)
+		if err != nil {
+			return err
+		}
+		if len(chunks) != event.Chunks {
+			return fmt.Errorf("event %s has %d of its %d chunks", event.ID.Hex(), len(chunks), event.Chunks)
+		}
+		event.WatchDiff = mytypes.WatchDiff{}
+		for _, chunk := range chunks {
+			event.Added = append(event.Added, chunk.Added...)
+			event.Removed = append(event.Removed, chunk.Removed...)
+			event.Changed = append(event.Changed, chunk.Changed...)
+		}
+	}
+	if event.Added == nil {
+		event.Added = []mytypes.WatchAsset{}
+	}
+	if event.Removed == nil {
+		event.Removed = []mytypes.WatchAsset{}
+	}
+	if event.Changed == nil {
+		event.Changed = []mytypes.WatchAsset{}
+	}
+	return nil
+}
+
+// function findChunks to read the chunks matching the filter in order into the given slice
+func findChunks(client *mongo.Client, target string, filter bson.M, chunks interface{}) error {
+	opts := options.Find().SetSort(bson.D{{Key: "seq", Value: 1}})
+	cursor, err := client.Database(WatchDatabase).Collection(target).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return err
+	}
+	return cursor.All(context.TODO(), chunks)
+}
+
+// function GetLatestSnapshots to get the latest n snapshots of a target with their assets, newest first, returns a slice of snapshots and an error
+func GetLatestSnapshots(client *mongo.Client, target string, n int64) ([]mytypes.WatchSnapshot, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "taken_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(n)
+	cursor, err := client.Database(WatchDatabase).Collection(target).Find(context.TODO(), bson.M{"kind": mytypes.WatchKindSnapshot}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting snapshots: %v", err)
+	}
+	snapshots := []mytypes.WatchSnapshot{}
+	err = cursor.All(context.TODO(), &snapshots)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding snapshots: %v", err)
+	}
+	for i := range snapshots {
+		err = loadSnapshotAssets(client, target, &snapshots[i])
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error getting snapshot assets: %v", err)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// function WatchTarget to take a new snapshot of a target, diff it against the previous one and store the diff as a watch event, the diff is chunked like the snapshot and the event is stored before the snapshot document so a failed event insert leaves the previous snapshot as the base of the next run, returns the event (nil if nothing changed) and an error
+func WatchTarget(client *mongo.Client, target string, runid string) (*mytypes.WatchEvent, error) {
+	previous, err := GetLatestSnapshots(client, target, 1)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error watching target: %v", err)
+	}
+	snapshot, err := collectSnapshot(client, target, runid)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error watching target: %v", err)
+	}
+	chunks, err := storeSnapshotChunks(client, target, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error storing snapshot: %v", err)
+	}
+
+	var old *mytypes.WatchSnapshot
+	if len(previous) > 0 {
+		old = &previous[0]
+	}
+	diff := DiffSnapshots(old, snapshot)
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0 {
+		err = storeSnapshotHeader(client, target, snapshot, chunks)
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error storing snapshot: %v", err)
+		}
+		fmt.Println("[+] No changes found for target")
+		return nil, nil
+	}
+
+	event := &mytypes.WatchEvent{
+		ID:         primitive.NewObjectID(),
+		Kind:       mytypes.WatchKindEvent,
+		Target:     target,
+		SnapshotID: snapshot.ID,
+		CreatedAt:  snapshot.TakenAt,
+		Counts:     map[string]int{"added": len(diff.Added), "removed": len(diff.Removed), "changed": len(diff.Changed)},
+		WatchDiff:  diff,
+		ConsumedBy: []string{},
+	}
+	if old != nil {
+		event.PreviousSnapshotID = old.ID
+	}
+	coll := client.Database(WatchDatabase).Collection(target)
+	dropSnapshotChunks := bson.M{"kind": mytypes.WatchKindSnapshotChunk, "snapshot_id": snapshot.ID}
+	dropEventChunks := bson.M{"kind": mytypes.WatchKindEventChunk, "event_id": event.ID}
+	diffchunks := eventChunks(event)
+	err = insertChunks(client, target, diffchunks, dropEventChunks)
+	if err == nil {
+		header := *event
+		header.Chunks = len(diffchunks)
+		header.WatchDiff = mytypes.WatchDiff{}
+		_, err = coll.InsertOne(context.TODO(), header)
+		if err != nil {
+			auditOperation(context.TODO(), client, mytypes.AuditInsert, WatchDatabase, target, nil, nil, nil, 0, err)
+			removeWatchDocuments(client, target, dropEventChunks)
+		} else {
+			auditOperation(context.TODO(), client, mytypes.AuditInsert, WatchDatabase, target, nil, nil, auditDocument(header), 1, nil)
+		}
+	}
+	if err != nil {
+		removeWatchDocuments(client, target, dropSnapshotChunks)
+		return nil, fmt.Errorf("[-] Error storing watch event: %v", err)
+	}
+	event.Chunks = len(diffchunks)
+	err = storeSnapshotHeader(client, target, snapshot, chunks)
+	if err != nil {
+		// without its snapshot the event would be reported again by the next run
+		removeWatchDocuments(client, target, bson.M{"_id": event.ID})
+		removeWatchDocuments(client, target, dropEventChunks)
+		return nil, fmt.Errorf("[-] Error storing snapshot: %v", err)
+	}
+	fmt.Println("[+] Stored watch event successfully")
+
+	return event, nil
+}
+
+// function GetWatchEvents to get the watch events of a target created after the given time, oldest first, returns a slice of events and an error
+func GetWatchEvents(client *mongo.Client, target string, since time.Time) ([]mytypes.WatchEvent, error) {
+	filter := bson.M{"kind": mytypes.WatchKindEvent, "created_at": bson.M{"$gt": since}}
+	return findWatchEvents(client, target, filter)
+}
+
+// function GetPendingWatchEvents to get the watch events of a target that the given consumer didn't acknowledge yet, oldest first, returns a slice of events and an error
+func GetPendingWatchEvents(client *mongo.Client, target string, consumer string) ([]mytypes.WatchEvent, error) {
+	filter := bson.M{"kind": mytypes.WatchKindEvent, "consumed_by": bson.M{"$ne": consumer}}
+	return findWatchEvents(client, target, filter)
+}
+
+// function findWatchEvents to find the watch events of a target matching the given filter, oldest first
+func findWatchEvents(client *mongo.Client, target string, filter bson.M) ([]mytypes.WatchEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := client.Database(WatchDatabase).Collection(target).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting watch events: %v", err)
+	}
+	events := []mytypes.WatchEvent{}
+	err = cursor.All(context.TODO(), &events)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding watch events: %v", err)
+	}
+	for i := range events {
+		err = loadEventDiff(client, target, &events[i])
+		if err != nil {
+			return nil, fmt.Errorf("[-] Error getting watch event diff: %v", err)
+		}
+	}
+
+	return events, nil
+}
+
+// function AckWatchEvent to mark a watch event as consumed by the given consumer, returns an error
+func AckWatchEvent(client *mongo.Client, target string, id string, consumer string) error {
+	objectid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("[-] Error converting id to object id: %v", err)
+	}
+
+	filter := bson.M{"_id": objectid, "kind": mytypes.WatchKindEvent}
+	update := bson.M{"$addToSet": bson.M{"consumed_by": consumer}}
+	result, err := client.Database(WatchDatabase).Collection(target).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, WatchDatabase, target, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error acknowledging watch event: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error acknowledging watch event: event doesn't exist")
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, WatchDatabase, target, filter, nil, bson.M{"_id": objectid, "consumed_by": consumer}, result.ModifiedCount, nil)
+
+	return nil
+}
@@ -0,0 +1,270 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Web                      ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the web database (not target based) and its collection
+var (
+	WebDatabase = "web"
+	WebProbes   = "probes"
+)
+
+// function init to register the migration that backfills the header text of the stored probes
+func init() {
+	err := RegisterMigration(Migration{
+		Version:     2,
+		Name:        "web_probe_header_text",
+		Description: "give every probe the header_text field (its headers as name: value lines) of the title and headers text index",
+		Databases:   []string{WebDatabase},
+		Up:          backfillHeaderText,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// function probeHeaderText to write the headers of a probe as sorted "name: value" lines for the text index
+func probeHeaderText(headers map[string][]string) string {
+	lines := []string{}
+	for name, values := range headers {
+		for _, value := range values {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// function backfillHeaderText to set the header_text of the probes saved before it existed, returns the number of probes changed and an error
+func backfillHeaderText(ctx context.Context, client *mongo.Client, target string) (int64, error) {
+	coll := client.Database(WebDatabase).Collection(WebProbes)
+	cursor, err := coll.Find(ctx, bson.M{"header_text": bson.M{"$exists": false}}, options.Find().SetProjection(bson.M{"headers": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var changed int64
+	for cursor.Next(ctx) {
+		probe := mytypes.WebProbe{}
+		err = cursor.Decode(&probe)
+		if err != nil {
+			return changed, err
+		}
+		// a probe saved meanwhile already has it
+		result, err := coll.UpdateOne(ctx, bson.M{"_id": probe.ID, "header_text": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"header_text": probeHeaderText(probe.Headers)}})
+		if err != nil {
+			return changed, err
+		}
+		changed += result.ModifiedCount
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, WebDatabase, WebProbes, bson.M{"header_text": bson.M{"$exists": false}}, nil, nil, changed, cursor.Err())
+
+	return changed, cursor.Err()
+}
+
+// function SaveProbe to store the http probe result of a url, the url's scheme, host, port and path are filled in and the probe is linked to the enum tree node of its host, a url probed before is overwritten but keeps its first-seen time, returns an error
+func SaveProbe(client *mongo.Client, probe mytypes.WebProbe) error {
+	u, err := url.Parse(probe.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("[-] Error saving probe: invalid url %q", probe.URL)
+	}
+	probe.Scheme = strings.ToLower(u.Scheme)
+	probe.Host = strings.ToLower(u.Hostname())
+	probe.Path = u.EscapedPath()
+	if probe.Path == "" {
+		probe.Path = "/"
+	}
+	probe.Port = 0
+	if u.Port() != "" {
+		probe.Port, _ = strconv.Atoi(u.Port())
+	} else if probe.Scheme == "https" {
+		probe.Port = 443
+	} else {
+		probe.Port = 80
+	}
+
+	// header names are lowercased so they can be queried as headers.<name>, dots aren't allowed in field names
+	headers := map[string][]string{}
+	for name, values := range probe.Headers {
+		key := strings.ReplaceAll(strings.ToLower(name), ".", "_")
+		headers[key] = append(headers[key], values...)
+	}
+	probe.Headers = headers
+	probe.HeaderText = probeHeaderText(headers)
+	if probe.Technologies == nil {
+		probe.Technologies = []mytypes.Technology{}
+	}
+	if probe.RedirectChain == nil {
+		probe.RedirectChain = []mytypes.Redirect{}
+	}
+	if probe.ProbedAt.IsZero() {
+		probe.ProbedAt = time.Now().UTC()
+	}
+
+	if probe.Target != "" {
+		domains, err := GetTargetDomains(client, probe.Target)
+		if err != nil {
+			return fmt.Errorf("[-] Error saving probe: %v", err)
+		}
+		if domain := MatchDomain(domains, probe.Host); domain != "" {
+			probe.Enum = &mytypes.EnumRef{
+				Database:   EnumDatabase,
+				Collection: probe.Target,
+				Domain:     domain,
+				Subdomain:  probe.Host,
+			}
+			if dir := path.Dir(probe.Path); dir != "/" && dir != "." {
+				probe.Enum.Directory = strings.TrimPrefix(dir, "/")
+			}
+		}
+	}
+
+	raw, err := bson.Marshal(probe)
+	if err != nil {
+		return fmt.Errorf("[-] Error converting probe to bson: %v", err)
+	}
+	set := bson.M{}
+	err = bson.Unmarshal(raw, &set)
+	if err != nil {
+		return fmt.Errorf("[-] Error converting probe to bson: %v", err)
+	}
+	delete(set, "_id")
+	delete(set, "first_seen")
+	// the upsert relies on the unique url index to never store a url twice
+	err = ensureIndexes(client, WebDatabase, WebProbes)
+	if err != nil {
+		return fmt.Errorf("[-] Error saving probe: %v", err)
+	}
+	update := bson.M{"$set": set, "$setOnInsert": bson.M{"first_seen": probe.ProbedAt}}
+	filter := bson.M{"url": probe.URL}
+	result, err := client.Database(WebDatabase).Collection(WebProbes).UpdateOne(context.TODO(), filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, WebDatabase, WebProbes, filter, nil, nil, 0, err)
+		return fmt.Errorf("[-] Error saving probe: %v", err)
+	}
+	if result.UpsertedID != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditInsert, WebDatabase, WebProbes, nil, nil, bson.M{"_id": result.UpsertedID, "url": probe.URL, "target": probe.Target, "status_code": probe.StatusCode}, 1, nil)
+	} else {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, WebDatabase, WebProbes, filter, nil, bson.M{"status_code": probe.StatusCode, "probed_at": probe.ProbedAt}, result.ModifiedCount, nil)
+	}
+	fmt.Println("[+] Saved probe successfully")
+
+	return nil
+}
+
+// function findProbes to find the probes matching the given filter
+func findProbes(client *mongo.Client, filter bson.M) ([]mytypes.WebProbe, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "target", Value: 1}, {Key: "host", Value: 1}, {Key: "url", Value: 1}})
+	cursor, err := client.Database(WebDatabase).Collection(WebProbes).Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting probes: %v", err)
+	}
+	probes := []mytypes.WebProbe{}
+	err = cursor.All(context.TODO(), &probes)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding probes: %v", err)
+	}
+
+	return probes, nil
+}
+
+// function GetProbe to get the probe of the given url, returns a pointer to the probe and an error
+func GetProbe(client *mongo.Client, rawurl string) (*mytypes.WebProbe, error) {
+	probe := &mytypes.WebProbe{}
+	err := client.Database(WebDatabase).Collection(WebProbes).FindOne(context.TODO(), bson.M{"url": rawurl}).Decode(probe)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting probe: %v", err)
+	}
+
+	return probe, nil
+}
+
+// function GetProbesForTarget to get all the probes of a target, returns a slice of probes and an error
+func GetProbesForTarget(client *mongo.Client, target string) ([]mytypes.WebProbe, error) {
+	return findProbes(client, bson.M{"target": target})
+}
+
+// function GetProbesForHost to get the probes of a host (subdomain) of a target, returns a slice of probes and an error
+func GetProbesForHost(client *mongo.Client, target string, host string) ([]mytypes.WebProbe, error) {
+	return findProbes(client, bson.M{"target": target, "host": strings.ToLower(host)})
+}
+
+// function FindByTechnology to find the probes running a technology across targets, the name is matched exactly, an empty version matches every version and a version ending in '*' matches as a prefix (e.g. 1.18*), targets limits the search (nil for all targets), returns a slice of probes and an error
+func FindByTechnology(client *mongo.Client, name string, version string, targets []string) ([]mytypes.WebProbe, error) {
+	match := bson.M{"name": name}
+	if strings.HasSuffix(version, "*") {
+		match["version"] = bson.M{"$regex": "^" + regexp.QuoteMeta(strings.TrimSuffix(version, "*"))}
+	} else if version != "" {
+		match["version"] = version
+	}
+	filter := bson.M{"technologies": bson.M{"$elemMatch": match}}
+	if targets != nil {
+		filter["target"] = bson.M{"$in": targets}
+	}
+
+	return findProbes(client, filter)
+}
+
+// function FindByFavicon to find the probes with the given favicon hash across targets, returns a slice of probes and an error
+func FindByFavicon(client *mongo.Client, faviconhash string) ([]mytypes.WebProbe, error) {
+	return findProbes(client, bson.M{"favicon_hash": faviconhash})
+}
+
+// function GetTechnologyInventory to count the hosts running each technology and version (of a target, or of all targets if empty), returns a slice of documents {name, version, hosts, targets} and an error
+func GetTechnologyInventory(client *mongo.Client, target string) ([]bson.M, error) {
+	pipeline := mongo.Pipeline{}
+	if target != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"target": target}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$unwind", Value: "$technologies"}},
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":     bson.M{"name": "$technologies.name", "version": "$technologies.version"},
+			"hosts":   bson.M{"$addToSet": "$host"},
+			"targets": bson.M{"$addToSet": "$target"},
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":     0,
+			"name":    "$_id.name",
+			"version": "$_id.version",
+			"hosts":   1,
+			"targets": 1,
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "version", Value: 1}}}},
+	)
+	cursor, err := client.Database(WebDatabase).Collection(WebProbes).Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting technology inventory: %v", err)
+	}
+	inventory := []bson.M{}
+	err = cursor.All(context.TODO(), &inventory)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding technology inventory: %v", err)
+	}
+
+	return inventory, nil
+}
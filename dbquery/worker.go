@@ -0,0 +1,349 @@
+package dbquery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"healerdb/mytypes"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Worker                   ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// name of the worker database (not target based) and its collections
+var (
+	WorkerDatabase = "worker"
+	WorkerJobs     = "jobs"
+	WorkerWorkers  = "workers"
+)
+
+// defaults used when a job doesn't set its own retry policy
+const (
+	defaultQueueMaxAttempts    = 3
+	defaultQueueBackoffSeconds = 10
+	maxQueueBackoff            = time.Hour
+)
+
+// function EnsureQueueIndexes to create the declared indexes the work queue relies on, returns an error
+func EnsureQueueIndexes(client *mongo.Client) error {
+	err := ensureIndexes(client, WorkerDatabase, WorkerJobs)
+	if err == nil {
+		err = ensureIndexes(client, WorkerDatabase, WorkerWorkers)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("[+] Created queue indexes successfully")
+
+	return nil
+}
+
+// function EnqueueJob to put a job into the work queue, the job becomes visible at VisibleAt (now if zero), returns the id of the job and an error
+func EnqueueJob(client *mongo.Client, job mytypes.QueueJob) (string, error) {
+	if job.Queue == "" || job.Type == "" {
+		return "", fmt.Errorf("[-] Error enqueueing job: queue and type are required")
+	}
+	now := time.Now().UTC()
+	job.ID = primitive.NilObjectID
+	job.Status = mytypes.QueueJobQueued
+	job.Attempts = 0
+	job.LockedBy = ""
+	job.LeaseUntil = time.Time{}
+	job.CreatedAt = now
+	if job.VisibleAt.IsZero() {
+		job.VisibleAt = now
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = defaultQueueMaxAttempts
+	}
+	if job.BackoffSeconds <= 0 {
+		job.BackoffSeconds = defaultQueueBackoffSeconds
+	}
+	if job.RequiredCapabilities == nil {
+		job.RequiredCapabilities = []string{}
+	}
+
+	result, err := client.Database(WorkerDatabase).Collection(WorkerJobs).InsertOne(context.TODO(), job)
+	if err != nil {
+		return "", fmt.Errorf("[-] Error enqueueing job: %v", err)
+	}
+	after := auditDocument(job)
+	after["_id"] = result.InsertedID
+	auditOperation(context.TODO(), client, mytypes.AuditInsert, WorkerDatabase, WorkerJobs, nil, nil, after, 1, nil)
+	fmt.Println("[+] Enqueued job successfully")
+
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// function ClaimJob to atomically claim the next visible job of the given queues that the worker has the capabilities for, the job stays invisible to other workers for the visibility timeout unless the worker sends heartbeats, returns the claimed job (nil if the queues are empty) and an error
+func ClaimJob(client *mongo.Client, workerid string, queues []string, capabilities []string, visibility time.Duration) (*mytypes.QueueJob, error) {
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	now := time.Now().UTC()
+	filter := bson.M{
+		"queue": bson.M{"$in": queues},
+		"$or": bson.A{
+			bson.M{"status": mytypes.QueueJobQueued, "visible_at": bson.M{"$lte": now}},
+			// a running job whose lease expired was abandoned by a dead worker
+			bson.M{"status": mytypes.QueueJobRunning, "lease_until": bson.M{"$lte": now}},
+		},
+		"$expr": bson.M{"$lt": bson.A{"$attempts", "$max_attempts"}},
+		// every required capability must be one of the worker's capabilities
+		"required_capabilities": bson.M{"$not": bson.M{"$elemMatch": bson.M{"$nin": capabilities}}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":      mytypes.QueueJobRunning,
+			"locked_by":   workerid,
+			"lease_until": now.Add(visibility),
+			"started_at":  now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "visible_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	job := &mytypes.QueueJob{}
+	err := client.Database(WorkerDatabase).Collection(WorkerJobs).FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error claiming job: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditUpdate, WorkerDatabase, WorkerJobs, bson.M{"_id": job.ID}, nil, bson.M{"_id": job.ID, "status": job.Status, "locked_by": job.LockedBy, "attempts": job.Attempts}, 1, nil)
+	fmt.Println("[+] Claimed job successfully")
+
+	return job, nil
+}
+
+// function HeartbeatJob to extend the lease of a running job held by the worker, returns an error if the worker lost the lease
+func HeartbeatJob(client *mongo.Client, id primitive.ObjectID, workerid string, visibility time.Duration) error {
+	filter := bson.M{"_id": id, "status": mytypes.QueueJobRunning, "locked_by": workerid}
+	update := bson.M{"$set": bson.M{"lease_until": time.Now().UTC().Add(visibility)}}
+	result, err := client.Database(WorkerDatabase).Collection(WorkerJobs).UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return fmt.Errorf("[-] Error sending job heartbeat: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error sending job heartbeat: lease is not held by %s", workerid)
+	}
+
+	return nil
+}
+
+// function CompleteJob to mark a running job held by the worker as done with the given result, returns an error if the worker lost the lease
+func CompleteJob(client *mongo.Client, id primitive.ObjectID, workerid string, result bson.M) error {
+	now := time.Now().UTC()
+	filter := bson.M{"_id": id, "status": mytypes.QueueJobRunning, "locked_by": workerid}
+	set := bson.M{"status": mytypes.QueueJobDone, "result": result, "finished_at": now, "lease_until": time.Time{}}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"locked_by": "", "last_error": ""},
+	}
+	before, err := updateOneAudited(context.TODO(), client, WorkerDatabase, WorkerJobs, filter, set, update)
+	if err != nil {
+		return fmt.Errorf("[-] Error completing job: %v", err)
+	}
+	if before == nil {
+		return fmt.Errorf("[-] Error completing job: lease is not held by %s", workerid)
+	}
+	fmt.Println("[+] Completed job successfully")
+
+	return nil
+}
+
+// function FailJob to record a failed attempt of a running job held by the worker, the job is retried after an exponential backoff or dead-lettered once it used all its attempts, returns whether the job was dead-lettered and an error
+func FailJob(client *mongo.Client, id primitive.ObjectID, workerid string, errmsg string) (bool, error) {
+	coll := client.Database(WorkerDatabase).Collection(WorkerJobs)
+	filter := bson.M{"_id": id, "status": mytypes.QueueJobRunning, "locked_by": workerid}
+	job := &mytypes.QueueJob{}
+	err := coll.FindOne(context.TODO(), filter).Decode(job)
+	if err == mongo.ErrNoDocuments {
+		return false, fmt.Errorf("[-] Error failing job: lease is not held by %s", workerid)
+	}
+	if err != nil {
+		return false, fmt.Errorf("[-] Error failing job: %v", err)
+	}
+
+	now := time.Now().UTC()
+	dead := job.Attempts >= job.MaxAttempts
+	set := bson.M{"last_error": errmsg, "lease_until": time.Time{}}
+	if dead {
+		set["status"] = mytypes.QueueJobDead
+		set["finished_at"] = now
+	} else {
+		backoff := time.Duration(job.BackoffSeconds) * time.Second << (job.Attempts - 1)
+		if backoff > maxQueueBackoff || backoff <= 0 {
+			backoff = maxQueueBackoff
+		}
+		set["status"] = mytypes.QueueJobQueued
+		set["visible_at"] = now.Add(backoff)
+	}
+	update := bson.M{"$set": set, "$unset": bson.M{"locked_by": ""}}
+	before, err := updateOneAudited(context.TODO(), client, WorkerDatabase, WorkerJobs, filter, set, update)
+	if err != nil {
+		return false, fmt.Errorf("[-] Error failing job: %v", err)
+	}
+	if before == nil {
+		return false, fmt.Errorf("[-] Error failing job: lease is not held by %s", workerid)
+	}
+	if dead {
+		fmt.Println("[+] Job moved to dead letter")
+	} else {
+		fmt.Println("[+] Job scheduled for retry")
+	}
+
+	return dead, nil
+}
+
+// function ReapExpiredJobs to dead-letter the running jobs whose lease expired after their last allowed attempt (they can't be claimed anymore), returns the number of dead-lettered jobs and an error
+func ReapExpiredJobs(client *mongo.Client) (int64, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"status":      mytypes.QueueJobRunning,
+		"lease_until": bson.M{"$lte": now},
+		"$expr":       bson.M{"$gte": bson.A{"$attempts", "$max_attempts"}},
+	}
+	update := bson.M{
+		"$set":   bson.M{"status": mytypes.QueueJobDead, "last_error": "lease expired on last attempt", "finished_at": now},
+		"$unset": bson.M{"locked_by": ""},
+	}
+	result, err := client.Database(WorkerDatabase).Collection(WorkerJobs).UpdateMany(context.TODO(), filter, update)
+	if err != nil {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, WorkerDatabase, WorkerJobs, filter, nil, nil, 0, err)
+		return 0, fmt.Errorf("[-] Error reaping expired jobs: %v", err)
+	}
+	if result.ModifiedCount > 0 {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, WorkerDatabase, WorkerJobs, filter, nil, nil, result.ModifiedCount, nil)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// function GetDeadJobs to get the dead-lettered jobs of a queue (all queues if empty), returns a slice of jobs and an error
+func GetDeadJobs(client *mongo.Client, queue string) ([]mytypes.QueueJob, error) {
+	filter := bson.M{"status": mytypes.QueueJobDead}
+	if queue != "" {
+		filter["queue"] = queue
+	}
+	cursor, err := client.Database(WorkerDatabase).Collection(WorkerJobs).Find(context.TODO(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting dead jobs: %v", err)
+	}
+	jobs := []mytypes.QueueJob{}
+	err = cursor.All(context.TODO(), &jobs)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding dead jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// function RequeueDeadJob to put a dead-lettered job back into its queue with a fresh set of attempts, returns an error
+func RequeueDeadJob(client *mongo.Client, id primitive.ObjectID) error {
+	filter := bson.M{"_id": id, "status": mytypes.QueueJobDead}
+	set := bson.M{"status": mytypes.QueueJobQueued, "attempts": 0, "visible_at": time.Now().UTC()}
+	update := bson.M{
+		"$set":   set,
+		"$unset": bson.M{"finished_at": ""},
+	}
+	before, err := updateOneAudited(context.TODO(), client, WorkerDatabase, WorkerJobs, filter, set, update)
+	if err != nil {
+		return fmt.Errorf("[-] Error requeueing job: %v", err)
+	}
+	if before == nil {
+		return fmt.Errorf("[-] Error requeueing job: job is not dead-lettered")
+	}
+	fmt.Println("[+] Requeued job successfully")
+
+	return nil
+}
+
+// function RegisterWorker to register (or re-register) a worker with its queues and capabilities, returns an error
+func RegisterWorker(client *mongo.Client, worker mytypes.Worker) error {
+	if worker.WorkerID == "" {
+		return fmt.Errorf("[-] Error registering worker: worker id is required")
+	}
+	now := time.Now().UTC()
+	worker.StartedAt = now
+	worker.LastHeartbeat = now
+	opts := options.Replace().SetUpsert(true)
+	result, err := client.Database(WorkerDatabase).Collection(WorkerWorkers).ReplaceOne(context.TODO(), bson.M{"_id": worker.WorkerID}, worker, opts)
+	if err != nil {
+		return fmt.Errorf("[-] Error registering worker: %v", err)
+	}
+	operation := mytypes.AuditUpdate
+	if result.UpsertedID != nil {
+		operation = mytypes.AuditInsert
+	}
+	auditOperation(context.TODO(), client, operation, WorkerDatabase, WorkerWorkers, nil, nil, auditDocument(worker), 1, nil)
+	fmt.Println("[+] Registered worker successfully")
+
+	return nil
+}
+
+// function WorkerHeartbeat to mark a registered worker as alive, returns an error
+func WorkerHeartbeat(client *mongo.Client, workerid string) error {
+	update := bson.M{"$set": bson.M{"last_heartbeat": time.Now().UTC()}}
+	result, err := client.Database(WorkerDatabase).Collection(WorkerWorkers).UpdateOne(context.TODO(), bson.M{"_id": workerid}, update)
+	if err != nil {
+		return fmt.Errorf("[-] Error sending worker heartbeat: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("[-] Error sending worker heartbeat: worker is not registered")
+	}
+
+	return nil
+}
+
+// function GetLiveWorkers to get the workers that sent a heartbeat within maxage, returns a slice of workers and an error
+func GetLiveWorkers(client *mongo.Client, maxage time.Duration) ([]mytypes.Worker, error) {
+	filter := bson.M{"last_heartbeat": bson.M{"$gte": time.Now().UTC().Add(-maxage)}}
+	cursor, err := client.Database(WorkerDatabase).Collection(WorkerWorkers).Find(context.TODO(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error getting workers: %v", err)
+	}
+	workers := []mytypes.Worker{}
+	err = cursor.All(context.TODO(), &workers)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decoding workers: %v", err)
+	}
+
+	return workers, nil
+}
+
+// function DeregisterWorker to remove a worker, its running jobs are released so other workers can claim them right away, returns an error
+func DeregisterWorker(client *mongo.Client, workerid string) error {
+	now := time.Now().UTC()
+	jobfilter := bson.M{"status": mytypes.QueueJobRunning, "locked_by": workerid}
+	released, err := client.Database(WorkerDatabase).Collection(WorkerJobs).UpdateMany(context.TODO(), jobfilter, bson.M{"$set": bson.M{"lease_until": now}})
+	if err != nil {
+		return fmt.Errorf("[-] Error releasing worker jobs: %v", err)
+	}
+	if released.ModifiedCount > 0 {
+		auditOperation(context.TODO(), client, mytypes.AuditUpdate, WorkerDatabase, WorkerJobs, jobfilter, nil, nil, released.ModifiedCount, nil)
+	}
+	filter := bson.M{"_id": workerid}
+	result, err := client.Database(WorkerDatabase).Collection(WorkerWorkers).DeleteOne(context.TODO(), filter)
+	if err != nil {
+		return fmt.Errorf("[-] Error deregistering worker: %v", err)
+	}
+	auditOperation(context.TODO(), client, mytypes.AuditDelete, WorkerDatabase, WorkerWorkers, filter, nil, nil, result.DeletedCount, nil)
+	fmt.Println("[+] Deregistered worker successfully")
+
+	return nil
+}
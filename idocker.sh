@@ -20,15 +20,28 @@ if docker ps | grep healerdb > /dev/null 2>&1; then
     exit 1
 fi
 
-docker run -d --name healerdb -p 27017:27017 mongo > /dev/null 2>&1
+# Run mongo as a single node replica set, the multi-collection writes need transactions and a standalone server has none
+docker run -d --name healerdb -p 27017:27017 mongo --replSet rs0 --bind_ip_all > /dev/null 2>&1
 
 # Check if the container is running
-if docker ps | grep healerdb > /dev/null 2>&1; then
-    echo "Container healerdb is running"
-    exit 0
-else
+if ! docker ps | grep healerdb > /dev/null 2>&1; then
     echo "Container healerdb is not running"
     exit 1
 fi
 
+# Wait for mongo to accept connections, then initiate the replica set
+for i in $(seq 1 30); do
+    if docker exec healerdb mongosh --quiet --eval "db.adminCommand('ping')" > /dev/null 2>&1; then
+        break
+    fi
+    sleep 1
+done
+if ! docker exec healerdb mongosh --quiet --eval "try { rs.status() } catch (e) { rs.initiate({_id: 'rs0', members: [{_id: 0, host: 'localhost:27017'}]}) }" > /dev/null 2>&1; then
+    echo "Failed to initiate the replica set of container healerdb"
+    exit 1
+fi
+
+echo "Container healerdb is running"
+exit 0
+
 echo "Exiting..."
\ No newline at end of file
@@ -0,0 +1,61 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// operations recorded in the audit log
+const (
+	AuditInsert         = "insert"
+	AuditUpdate         = "update"
+	AuditDelete         = "delete"
+	AuditDropCollection = "drop_collection"
+	AuditDropDatabase   = "drop_database"
+	AuditPurge          = "purge"
+	AuditRestore        = "restore"
+	AuditGetSecret      = "get_secret"
+	AuditMigrate        = "migrate"
+	AuditCreateIndex    = "create_index"
+	AuditDropIndex      = "drop_index"
+)
+
+// AuditChange is one changed field of a document, Field is a dotted path and a missing Before or After means the field was added or removed
+type AuditChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditRecord is a document in the log database, records of target based databases go to the target's collection and the others to the global one
+type AuditRecord struct {
+	ID         primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Operation  string                 `bson:"operation" json:"operation"`
+	Actor      string                 `bson:"actor" json:"actor"`
+	Database   string                 `bson:"database" json:"database"`
+	Collection string                 `bson:"collection,omitempty" json:"collection,omitempty"`
+	Target     string                 `bson:"target,omitempty" json:"target,omitempty"`
+	DocumentID string                 `bson:"document_id,omitempty" json:"document_id,omitempty"`
+	Filter     string                 `bson:"filter,omitempty" json:"filter,omitempty"`
+	Changes    []AuditChange          `bson:"changes,omitempty" json:"changes,omitempty"`
+	Count      int64                  `bson:"count,omitempty" json:"count,omitempty"`
+	Details    map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	Error      string                 `bson:"error,omitempty" json:"error,omitempty"`
+	Timestamp  time.Time              `bson:"timestamp" json:"timestamp"`
+	ExpiresAt  *time.Time             `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+}
+
+// AuditQuery selects audit records, empty fields don't filter, an empty Target reads the global collection
+type AuditQuery struct {
+	Target     string    `json:"target,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Operation  string    `json:"operation,omitempty"`
+	Database   string    `json:"database,omitempty"`
+	Collection string    `json:"collection,omitempty"`
+	DocumentID string    `json:"document_id,omitempty"`
+	Since      time.Time `json:"since,omitempty"`
+	Until      time.Time `json:"until,omitempty"`
+	Limit      int64     `json:"limit,omitempty"`
+	Skip       int64     `json:"skip,omitempty"`
+}
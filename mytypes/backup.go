@@ -0,0 +1,73 @@
+package mytypes
+
+import "time"
+
+// document formats of a backup archive
+const (
+	BackupFormatExtJSON = "extjson"
+	BackupFormatBSON    = "bson"
+)
+
+// conflict policies of a restore, for documents whose _id already exists
+const (
+	RestoreSkip      = "skip"
+	RestoreOverwrite = "overwrite"
+	RestoreMerge     = "merge"
+)
+
+// BackupCollection describes one collection stored in a backup archive
+type BackupCollection struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Documents  int64  `json:"documents"`
+	Indexes    int    `json:"indexes"`
+}
+
+// BackupManifest is the manifest.json at the root of a backup archive
+type BackupManifest struct {
+	Version     int                `json:"version"`
+	Format      string             `json:"format"`
+	CreatedAt   time.Time          `json:"created_at"`
+	Collections []BackupCollection `json:"collections"`
+}
+
+// RestoreCollection counts what a restore did in one collection
+type RestoreCollection struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Inserted   int64  `json:"inserted"`
+	Updated    int64  `json:"updated"`
+	Skipped    int64  `json:"skipped"`
+	Indexes    int    `json:"indexes"`
+}
+
+// RestoreResult is the outcome of a restore, Errors lists the documents and indexes that couldn't be restored
+type RestoreResult struct {
+	Policy      string              `json:"policy"`
+	Collections []RestoreCollection `json:"collections"`
+	Errors      []string            `json:"errors"`
+}
+
+// DestructiveOptions controls a destructive operation, without DryRun the Confirm token of a dry run of the same operation is required
+type DestructiveOptions struct {
+	DryRun    bool   `json:"dry_run"`
+	Confirm   string `json:"confirm,omitempty"`
+	Backup    bool   `json:"backup"`
+	BackupDir string `json:"backup_dir,omitempty"`
+}
+
+// DestructiveItem is a database (empty Collection) or a collection that a destructive operation removes
+type DestructiveItem struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection,omitempty"`
+	Documents  int64  `json:"documents"`
+}
+
+// DestructivePlan is what a destructive operation removes (or would remove on a dry run), Token confirms exactly this set of items
+type DestructivePlan struct {
+	Operation string            `json:"operation"`
+	Items     []DestructiveItem `json:"items"`
+	Token     string            `json:"token"`
+	DryRun    bool              `json:"dry_run"`
+	Backup    string            `json:"backup,omitempty"`
+}
@@ -0,0 +1,34 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Certificate is a TLS certificate observed on the hosts of a target, stored in the per-target collection of the ca database
+type Certificate struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Target             string             `bson:"target" json:"target"`
+	FingerprintSHA256  string             `bson:"fingerprint_sha256" json:"fingerprint_sha256"`
+	FingerprintSHA1    string             `bson:"fingerprint_sha1" json:"fingerprint_sha1"`
+	Subject            string             `bson:"subject" json:"subject"`
+	CommonName         string             `bson:"common_name" json:"common_name"`
+	Issuer             string             `bson:"issuer" json:"issuer"`
+	IssuerCommonName   string             `bson:"issuer_common_name" json:"issuer_common_name"`
+	SerialNumber       string             `bson:"serial_number" json:"serial_number"`
+	DNSNames           []string           `bson:"dns_names" json:"dns_names"`
+	IPAddresses        []string           `bson:"ip_addresses" json:"ip_addresses"`
+	EmailAddresses     []string           `bson:"email_addresses,omitempty" json:"email_addresses,omitempty"`
+	NotBefore          time.Time          `bson:"not_before" json:"not_before"`
+	NotAfter           time.Time          `bson:"not_after" json:"not_after"`
+	KeyType            string             `bson:"key_type" json:"key_type"`
+	KeyBits            int                `bson:"key_bits" json:"key_bits"`
+	SignatureAlgorithm string             `bson:"signature_algorithm" json:"signature_algorithm"`
+	IsCA               bool               `bson:"is_ca" json:"is_ca"`
+	SelfSigned         bool               `bson:"self_signed" json:"self_signed"`
+	Hosts              []string           `bson:"hosts" json:"hosts"`
+	PEM                string             `bson:"pem" json:"pem"`
+	FirstSeen          time.Time          `bson:"first_seen" json:"first_seen"`
+	LastSeen           time.Time          `bson:"last_seen" json:"last_seen"`
+}
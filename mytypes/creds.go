@@ -0,0 +1,49 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// kinds of secrets kept in the creds database
+const (
+	SecretAPIKey = "api_key"
+	SecretToken  = "token"
+	SecretLogin  = "login"
+)
+
+// SecretVersion is one encrypted version of a secret, the value is encrypted with a random data key which is itself encrypted (wrapped) with the master key identified by KeyID
+type SecretVersion struct {
+	Version    int       `bson:"version" json:"version"`
+	Ciphertext []byte    `bson:"ciphertext" json:"-"`
+	Nonce      []byte    `bson:"nonce" json:"-"`
+	WrappedKey []byte    `bson:"wrapped_key" json:"-"`
+	KeyNonce   []byte    `bson:"key_nonce" json:"-"`
+	KeyID      string    `bson:"key_id" json:"key_id"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Secret is a document in the secrets collection of the creds database
+type Secret struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name           string             `bson:"name" json:"name"`
+	Kind           string             `bson:"kind" json:"kind"`
+	Target         string             `bson:"target,omitempty" json:"target,omitempty"`
+	Description    string             `bson:"description,omitempty" json:"description,omitempty"`
+	CurrentVersion int                `bson:"current_version" json:"current_version"`
+	Versions       []SecretVersion    `bson:"versions" json:"-"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SecretInfo is what list operations return about a secret, it never carries key material or ciphertext
+type SecretInfo struct {
+	Name           string    `bson:"name" json:"name"`
+	Kind           string    `bson:"kind" json:"kind"`
+	Target         string    `bson:"target,omitempty" json:"target,omitempty"`
+	Description    string    `bson:"description,omitempty" json:"description,omitempty"`
+	CurrentVersion int       `bson:"current_version" json:"current_version"`
+	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
+}
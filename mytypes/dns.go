@@ -0,0 +1,40 @@
+package mytypes
+
+import "time"
+
+// MXRecord is a mail exchanger of a subdomain
+type MXRecord struct {
+	Host       string `bson:"host" json:"host"`
+	Preference uint16 `bson:"preference" json:"preference"`
+}
+
+// DNSRecords is the resolution of a subdomain, Wildcard means the answer also comes back for random names of the parent zone and DanglingCNAME that the CNAME target doesn't resolve
+type DNSRecords struct {
+	A             []string   `bson:"a" json:"a"`
+	AAAA          []string   `bson:"aaaa" json:"aaaa"`
+	CNAME         []string   `bson:"cname" json:"cname"`
+	MX            []MXRecord `bson:"mx" json:"mx"`
+	TXT           []string   `bson:"txt" json:"txt"`
+	NS            []string   `bson:"ns" json:"ns"`
+	Wildcard      bool       `bson:"wildcard" json:"wildcard"`
+	DanglingCNAME bool       `bson:"dangling_cname" json:"dangling_cname"`
+	ResolvedAt    time.Time  `bson:"resolved_at" json:"resolved_at"`
+}
+
+// DNSQuery selects subdomains by their resolution across targets (all of them if Targets is empty), IP matches the A and AAAA records, CNAME any name of the CNAME chain
+type DNSQuery struct {
+	Targets         []string `json:"targets,omitempty"`
+	IP              string   `json:"ip,omitempty"`
+	CNAME           string   `json:"cname,omitempty"`
+	DanglingOnly    bool     `json:"dangling_only,omitempty"`
+	ExcludeWildcard bool     `json:"exclude_wildcard,omitempty"`
+	Limit           int      `json:"limit,omitempty"`
+}
+
+// DNSMatch is a subdomain of a target with its resolution
+type DNSMatch struct {
+	Target    string     `json:"target"`
+	Domain    string     `json:"domain"`
+	Subdomain string     `json:"subdomain"`
+	DNS       DNSRecords `json:"dns"`
+}
@@ -0,0 +1,38 @@
+package mytypes
+
+// IndexCollation is the collation of an index, only the locale and the strength are compared when syncing
+type IndexCollation struct {
+	Locale   string `yaml:"locale" json:"locale"`
+	Strength int    `yaml:"strength,omitempty" json:"strength,omitempty"`
+}
+
+// IndexSpec declares an index of a collection, Keys are "field", "field:1", "field:-1", "field:text", "field:hashed" or "field:2dsphere" in order, Collection "*" (or empty) means every target collection of a target based database, Partial is the partial filter as json and TTLSeconds turns it into a ttl index
+type IndexSpec struct {
+	Database   string          `yaml:"database,omitempty" json:"database"`
+	Collection string          `yaml:"collection" json:"collection"`
+	Name       string          `yaml:"name,omitempty" json:"name,omitempty"`
+	Keys       []string        `yaml:"keys" json:"keys"`
+	Unique     bool            `yaml:"unique,omitempty" json:"unique,omitempty"`
+	Sparse     bool            `yaml:"sparse,omitempty" json:"sparse,omitempty"`
+	TTLSeconds *int32          `yaml:"ttl_seconds,omitempty" json:"ttl_seconds,omitempty"`
+	Partial    string          `yaml:"partial,omitempty" json:"partial,omitempty"`
+	Collation  *IndexCollation `yaml:"collation,omitempty" json:"collation,omitempty"`
+}
+
+// kinds of index changes reported by a sync
+const (
+	IndexCreated    = "created"
+	IndexMismatched = "mismatched"
+	IndexExtra      = "extra"
+	IndexDropped    = "dropped"
+	IndexRecreated  = "recreated"
+)
+
+// IndexChange is one finding of an index sync
+type IndexChange struct {
+	Kind       string `json:"kind"`
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Detail     string `json:"detail,omitempty"`
+}
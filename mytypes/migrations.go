@@ -0,0 +1,42 @@
+package mytypes
+
+import "time"
+
+// directions of a migration step
+const (
+	MigrationUp   = "up"
+	MigrationDown = "down"
+)
+
+// MigrationRecord is an applied migration, per target migrations have one record per target
+type MigrationRecord struct {
+	ID         string    `bson:"_id" json:"id"`
+	Version    int       `bson:"version" json:"version"`
+	Name       string    `bson:"name" json:"name"`
+	Target     string    `bson:"target,omitempty" json:"target,omitempty"`
+	Checksum   string    `bson:"checksum" json:"checksum"`
+	Documents  int64     `bson:"documents" json:"documents"`
+	DurationMs int64     `bson:"duration_ms" json:"duration_ms"`
+	AppliedBy  string    `bson:"applied_by" json:"applied_by"`
+	AppliedAt  time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// MigrationStep is a migration run (or planned in a dry run) in one direction, for one target if it is per target
+type MigrationStep struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Target    string `json:"target,omitempty"`
+	Direction string `json:"direction"`
+	Documents int64  `json:"documents"`
+}
+
+// MigrationStatus is the state of a registered migration for one target, Changed means the migration was edited after it was applied and Unknown that an applied migration isn't registered anymore
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Target    string     `json:"target,omitempty"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Changed   bool       `json:"changed,omitempty"`
+	Unknown   bool       `json:"unknown,omitempty"`
+}
@@ -0,0 +1,32 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ModuleRelease is a previously registered version of a module and its schemas
+type ModuleRelease struct {
+	Version      string    `bson:"version" json:"version"`
+	InputSchema  string    `bson:"input_schema" json:"input_schema"`
+	OutputSchema string    `bson:"output_schema" json:"output_schema"`
+	RegisteredAt time.Time `bson:"registered_at" json:"registered_at"`
+}
+
+// Module is a Healer module in the modules collection of the modules_api database, the schemas are JSON Schema documents kept as text since their keywords ($schema, $ref, ...) can't be bson keys
+type Module struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	Version      string             `bson:"version" json:"version"`
+	Description  string             `bson:"description,omitempty" json:"description,omitempty"`
+	InputSchema  string             `bson:"input_schema" json:"input_schema"`
+	OutputSchema string             `bson:"output_schema" json:"output_schema"`
+	Reads        []string           `bson:"reads" json:"reads"`
+	Writes       []string           `bson:"writes" json:"writes"`
+	Config       bson.M             `bson:"config,omitempty" json:"config,omitempty"`
+	History      []ModuleRelease    `bson:"history" json:"history"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
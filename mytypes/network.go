@@ -0,0 +1,56 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// types of the network assets
+const (
+	NetworkIP   = "ip"
+	NetworkCIDR = "cidr"
+)
+
+// Service is an open port of an ip address and what answers on it
+type Service struct {
+	Port     int       `bson:"port" json:"port"`
+	Protocol string    `bson:"protocol" json:"protocol"`
+	Service  string    `bson:"service,omitempty" json:"service,omitempty"`
+	Banner   string    `bson:"banner,omitempty" json:"banner,omitempty"`
+	Product  string    `bson:"product,omitempty" json:"product,omitempty"`
+	Version  string    `bson:"version,omitempty" json:"version,omitempty"`
+	LastSeen time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+// NetworkAsset is an ip address or a cidr of a target, stored in the per-target collection of the network database, Start and End are the first and last addresses as 16 bytes hex so that ranges compare as strings, Subdomains are the subdomains resolving to the ip (not stored)
+type NetworkAsset struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Target       string             `bson:"target" json:"target"`
+	Type         string             `bson:"type" json:"type"`
+	Address      string             `bson:"address" json:"address"`
+	Version      int                `bson:"version" json:"version"`
+	Start        string             `bson:"start" json:"start"`
+	End          string             `bson:"end" json:"end"`
+	ASN          uint32             `bson:"asn,omitempty" json:"asn,omitempty"`
+	Organization string             `bson:"organization,omitempty" json:"organization,omitempty"`
+	Ports        []Service          `bson:"ports" json:"ports"`
+	FirstSeen    time.Time          `bson:"first_seen" json:"first_seen"`
+	LastSeen     time.Time          `bson:"last_seen" json:"last_seen"`
+	Subdomains   []string           `bson:"-" json:"subdomains,omitempty"`
+}
+
+// NetworkQuery selects network assets across targets (all of them if Targets is empty), CIDR keeps the assets inside the network, Contains the cidrs holding the ip, Port and Protocol the ips with that port open
+type NetworkQuery struct {
+	Targets        []string `json:"targets,omitempty"`
+	Type           string   `json:"type,omitempty"`
+	CIDR           string   `json:"cidr,omitempty"`
+	Contains       string   `json:"contains,omitempty"`
+	Port           int      `json:"port,omitempty"`
+	Protocol       string   `json:"protocol,omitempty"`
+	Service        string   `json:"service,omitempty"`
+	Product        string   `json:"product,omitempty"`
+	ASN            uint32   `json:"asn,omitempty"`
+	WithSubdomains bool     `json:"with_subdomains,omitempty"`
+	Limit          int      `json:"limit,omitempty"`
+}
@@ -0,0 +1,72 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// types of the notifications that producers can enqueue
+const (
+	NotifNewSubdomain = "new_subdomain"
+	NotifNewVuln      = "new_vuln"
+	NotifScanFailed   = "scan_failed"
+)
+
+// statuses of a notification and of its deliveries
+const (
+	NotifPending   = "pending"
+	NotifDelivered = "delivered"
+	NotifFailed    = "failed"
+	NotifSkipped   = "skipped"
+)
+
+// NotifChannel is a configured destination, Sink is the name of the registered sink (webhook, slack, smtp, file) and Config holds its settings (url, path, host, ...)
+type NotifChannel struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name           string             `bson:"name" json:"name"`
+	Sink           string             `bson:"sink" json:"sink"`
+	Config         map[string]string  `bson:"config" json:"config"`
+	MaxAttempts    int                `bson:"max_attempts" json:"max_attempts"`
+	BackoffSeconds int                `bson:"backoff_seconds" json:"backoff_seconds"`
+	Enabled        bool               `bson:"enabled" json:"enabled"`
+}
+
+// NotifRoute is a routing rule, a notification matching Types and Targets (empty means all) is delivered to Channels, identical notifications (same dedup key) are dropped inside DedupWindowSeconds
+type NotifRoute struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name               string             `bson:"name" json:"name"`
+	Types              []string           `bson:"types" json:"types"`
+	Targets            []string           `bson:"targets" json:"targets"`
+	Channels           []string           `bson:"channels" json:"channels"`
+	DedupWindowSeconds int                `bson:"dedup_window_seconds" json:"dedup_window_seconds"`
+	Enabled            bool               `bson:"enabled" json:"enabled"`
+}
+
+// NotifDelivery is the delivery state of a notification on one channel
+type NotifDelivery struct {
+	Channel     string     `bson:"channel" json:"channel"`
+	Status      string     `bson:"status" json:"status"`
+	Attempts    int        `bson:"attempts" json:"attempts"`
+	LastError   string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttempt time.Time  `bson:"next_attempt" json:"next_attempt"`
+	DeliveredAt *time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// Notification is a document in the outbox collection of the notifio database
+type Notification struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type        string             `bson:"type" json:"type"`
+	Target      string             `bson:"target" json:"target"`
+	Title       string             `bson:"title" json:"title"`
+	Body        string             `bson:"body" json:"body"`
+	Data        bson.M             `bson:"data,omitempty" json:"data,omitempty"`
+	DedupKey    string             `bson:"dedup_key" json:"dedup_key"`
+	Status      string             `bson:"status" json:"status"`
+	Deliveries  []NotifDelivery    `bson:"deliveries" json:"deliveries"`
+	NextAttempt time.Time          `bson:"next_attempt" json:"next_attempt"`
+	LockedBy    string             `bson:"locked_by,omitempty" json:"-"`
+	LockedUntil time.Time          `bson:"locked_until" json:"-"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+}
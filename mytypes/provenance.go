@@ -0,0 +1,29 @@
+package mytypes
+
+import "time"
+
+// AssetSource is what found an asset of the enum tree, the tool or module and the run, the sources of an asset are a set of these
+type AssetSource struct {
+	Source string `bson:"source" json:"source"`
+	Run    string `bson:"run,omitempty" json:"run,omitempty"`
+}
+
+// SeenAsset is an asset of the enum tree with its provenance, the times are nil for assets stored before they were tracked
+type SeenAsset struct {
+	TargetAsset
+	FirstSeen *time.Time    `json:"first_seen,omitempty"`
+	LastSeen  *time.Time    `json:"last_seen,omitempty"`
+	SeenCount int64         `json:"seen_count"`
+	Sources   []AssetSource `json:"sources"`
+}
+
+// SeenQuery selects assets of the enum tree by provenance, zero fields don't filter, Type is one of the Asset constants and Source matches the tool or module of any of the sources
+type SeenQuery struct {
+	Type            string    `json:"type,omitempty"`
+	FirstSeenAfter  time.Time `json:"first_seen_after,omitempty"`
+	FirstSeenBefore time.Time `json:"first_seen_before,omitempty"`
+	LastSeenAfter   time.Time `json:"last_seen_after,omitempty"`
+	LastSeenBefore  time.Time `json:"last_seen_before,omitempty"`
+	Source          string    `json:"source,omitempty"`
+	Limit           int       `json:"limit,omitempty"`
+}
@@ -0,0 +1,58 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReportVuln is the view of a vuln document which is passed to the report templates, the well-known fields are decoded into the struct and everything else is kept in Extra
+type ReportVuln struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Title       string             `bson:"title" json:"title"`
+	Severity    string             `bson:"severity" json:"severity"`
+	Asset       string             `bson:"asset" json:"asset"`
+	URL         string             `bson:"url" json:"url"`
+	Weakness    string             `bson:"weakness" json:"weakness"`
+	Description string             `bson:"description" json:"description"`
+	Steps       []string           `bson:"steps" json:"steps"`
+	Impact      string             `bson:"impact" json:"impact"`
+	Remediation string             `bson:"remediation" json:"remediation"`
+	References  []string           `bson:"references" json:"references"`
+	Extra       bson.M             `bson:",inline" json:"extra"`
+}
+
+// ReportVersion is one rendered version of a report, every render is kept so we can track what was submitted
+type ReportVersion struct {
+	Version     int        `bson:"version" json:"version"`
+	Template    string     `bson:"template" json:"template"`
+	Format      string     `bson:"format" json:"format"`
+	Content     string     `bson:"content" json:"content"`
+	ContentHash string     `bson:"content_hash" json:"content_hash"`
+	RenderedAt  time.Time  `bson:"rendered_at" json:"rendered_at"`
+	Submitted   bool       `bson:"submitted" json:"submitted"`
+	SubmittedAt *time.Time `bson:"submitted_at,omitempty" json:"submitted_at,omitempty"`
+	SubmittedTo string     `bson:"submitted_to,omitempty" json:"submitted_to,omitempty"`
+}
+
+// Report is a document in the per-target collection of the report database
+type Report struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	Target    string               `bson:"target" json:"target"`
+	Title     string               `bson:"title" json:"title"`
+	Summary   string               `bson:"summary" json:"summary"`
+	VulnIDs   []primitive.ObjectID `bson:"vuln_ids" json:"vuln_ids"`
+	Versions  []ReportVersion      `bson:"versions" json:"versions"`
+	CreatedAt time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time            `bson:"updated_at" json:"updated_at"`
+}
+
+// ReportData is what the report templates are executed with
+type ReportData struct {
+	Target     string
+	Title      string
+	Summary    string
+	Vulns      []ReportVuln
+	RenderedAt time.Time
+}
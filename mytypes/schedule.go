@@ -0,0 +1,52 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// policies for runs that were missed while no worker was claiming jobs
+const (
+	// MissedRunOnce runs a late job once and then continues from the current time
+	MissedRunOnce = "run_once"
+	// MissedRunAll runs a late job once for every missed slot until it caught up
+	MissedRunAll = "run_all"
+	// MissedSkip drops runs that are later than the grace period and waits for the next slot
+	MissedSkip = "skip"
+)
+
+// statuses of the last run of a scheduled job
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobMissed    = "missed"
+)
+
+// ScheduledJob is a recurring job of a target, stored in the per-target collection of the schedule database
+type ScheduledJob struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name         string             `bson:"name" json:"name"`
+	Target       string             `bson:"target" json:"target"`
+	Module       string             `bson:"module" json:"module"`
+	Args         bson.M             `bson:"args,omitempty" json:"args,omitempty"`
+	Cron         string             `bson:"cron" json:"cron"`
+	Timezone     string             `bson:"timezone" json:"timezone"`
+	Enabled      bool               `bson:"enabled" json:"enabled"`
+	MissedPolicy string             `bson:"missed_policy" json:"missed_policy"`
+	GraceSeconds int                `bson:"grace_seconds" json:"grace_seconds"`
+	NextRun      time.Time          `bson:"next_run" json:"next_run"`
+	LastRun      *time.Time         `bson:"last_run,omitempty" json:"last_run,omitempty"`
+	LastStatus   string             `bson:"last_status,omitempty" json:"last_status,omitempty"`
+	LastError    string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	LastDuration float64            `bson:"last_duration,omitempty" json:"last_duration,omitempty"`
+	RunCount     int64              `bson:"run_count" json:"run_count"`
+	LockedBy     string             `bson:"locked_by,omitempty" json:"locked_by,omitempty"`
+	LockedUntil  time.Time          `bson:"locked_until" json:"locked_until"`
+	RunningFor   *time.Time         `bson:"running_for,omitempty" json:"running_for,omitempty"`
+	RunStartedAt *time.Time         `bson:"run_started_at,omitempty" json:"run_started_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,49 @@
+package mytypes
+
+// how a search pattern matches a host or a path
+const (
+	MatchExact    = "exact"
+	MatchPrefix   = "prefix"
+	MatchSuffix   = "suffix"
+	MatchContains = "contains"
+	MatchGlob     = "glob"
+	MatchRegex    = "regex"
+)
+
+// the probe result type, next to the asset types of the enum doc tree
+const AssetProbe = "probe"
+
+// SearchQuery searches the assets of the enum doc tree and the web probes across targets (all of them if Targets is empty), Host and Path are patterns matched as HostMatch and PathMatch say (by default /.../ is a regex, a pattern with * ? or [ a glob and anything else is found anywhere), Text is a full-text search on the probe titles and headers so it only returns probes, Page starts at 1
+type SearchQuery struct {
+	Targets   []string `json:"targets,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Host      string   `json:"host,omitempty"`
+	HostMatch string   `json:"host_match,omitempty"`
+	Path      string   `json:"path,omitempty"`
+	PathMatch string   `json:"path_match,omitempty"`
+	Text      string   `json:"text,omitempty"`
+	Page      int      `json:"page,omitempty"`
+	PageSize  int      `json:"page_size,omitempty"`
+}
+
+// SearchResult is an asset of the enum doc tree or a web probe found by a search, Score is the text search relevance
+type SearchResult struct {
+	Target     string  `json:"target"`
+	Type       string  `json:"type"`
+	Domain     string  `json:"domain,omitempty"`
+	Host       string  `json:"host"`
+	Path       string  `json:"path,omitempty"`
+	Parameter  string  `json:"parameter,omitempty"`
+	URL        string  `json:"url,omitempty"`
+	StatusCode int     `json:"status_code,omitempty"`
+	Title      string  `json:"title,omitempty"`
+	Score      float64 `json:"score,omitempty"`
+}
+
+// SearchPage is a page of search results, HasMore tells whether the next page has any
+type SearchPage struct {
+	Results  []SearchResult `json:"results"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	HasMore  bool           `json:"has_more"`
+}
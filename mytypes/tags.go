@@ -0,0 +1,23 @@
+package mytypes
+
+// AssetTarget is the type of a whole target for tagging, the other types are the Asset constants of the enum tree
+const AssetTarget = "target"
+
+// TaggedAsset is a target or an asset of its enum tree with its tags and labels
+type TaggedAsset struct {
+	Target string `json:"target"`
+	TargetAsset
+	Tags   []string          `json:"tags"`
+	Labels map[string]string `json:"labels"`
+}
+
+// TagQuery selects targets and assets of the enum tree, zero fields don't filter, Targets are all the targets if empty, Host matches the domain or subdomain and its subdomains, PathPrefix matches paths by their leading segments, an asset must have all the Tags and Labels, a label with an empty value only needs the key
+type TagQuery struct {
+	Targets    []string          `json:"targets,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	PathPrefix string            `json:"path_prefix,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Limit      int               `json:"limit,omitempty"`
+}
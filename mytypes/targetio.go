@@ -0,0 +1,26 @@
+package mytypes
+
+// formats of the target import and export, json is the nested asset tree and the only lossless one, csv has one row per asset and the lists one subdomain or url per line
+const (
+	TargetFormatJSON       = "json"
+	TargetFormatCSV        = "csv"
+	TargetFormatSubdomains = "subdomains"
+	TargetFormatURLs       = "urls"
+)
+
+// TargetAsset is one row of the flat view of a target's enum tree, Type is one of the Asset constants, Path is the directory or file path starting with '/' and Parameter is set on parameter rows
+type TargetAsset struct {
+	Type      string `json:"type"`
+	Domain    string `json:"domain"`
+	Subdomain string `json:"subdomain,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// ImportResult counts what an import did to the enum documents of a target, Skipped lists the input lines or rows that couldn't be imported and why
+type ImportResult struct {
+	Inserted  int      `json:"inserted"`
+	Merged    int      `json:"merged"`
+	Unchanged int      `json:"unchanged"`
+	Skipped   []string `json:"skipped"`
+}
@@ -0,0 +1,9 @@
+package mytypes
+
+// UpdateResult reports what an update, replace or upsert did, UpsertedID is set when a document was inserted
+type UpdateResult struct {
+	Matched    int64       `json:"matched"`
+	Modified   int64       `json:"modified"`
+	Upserted   int64       `json:"upserted"`
+	UpsertedID interface{} `json:"upserted_id,omitempty"`
+}
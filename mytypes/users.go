@@ -0,0 +1,58 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PanelUser is a document in the users collection of the safe-panel database
+type PanelUser struct {
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username          string             `bson:"username" json:"username"`
+	Email             string             `bson:"email" json:"email"`
+	PasswdHash        string             `bson:"passwd_hash" json:"-"`
+	Roles             []string           `bson:"roles" json:"roles"`
+	Disabled          bool               `bson:"disabled" json:"disabled"`
+	PasswordChangedAt *time.Time         `bson:"password_changed_at,omitempty" json:"password_changed_at,omitempty"`
+	LastLogin         *time.Time         `bson:"last_login,omitempty" json:"last_login,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// permission actions, "*" grants every action
+const (
+	ActionRead   = "read"
+	ActionWrite  = "write"
+	ActionDelete = "delete"
+	ActionAdmin  = "admin"
+	ActionAll    = "*"
+)
+
+// Permission grants an action on a database ("*" for all) and optionally only on some targets of it (empty for all)
+type Permission struct {
+	Action   string   `bson:"action" json:"action"`
+	Database string   `bson:"database" json:"database"`
+	Targets  []string `bson:"targets,omitempty" json:"targets,omitempty"`
+}
+
+// Role is a document in the roles collection of the safe-panel database
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []Permission       `bson:"permissions" json:"permissions"`
+}
+
+// APIToken is a document in the tokens collection of the safe-panel database, only the hash of the token secret is stored
+type APIToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenID    string             `bson:"token_id" json:"token_id"`
+	Hash       string             `bson:"hash" json:"-"`
+	Username   string             `bson:"username" json:"username"`
+	Name       string             `bson:"name" json:"name"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	Revoked    bool               `bson:"revoked" json:"revoked"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,82 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// kinds of the documents stored in the per-target collections of the watch database
+const (
+	WatchKindSnapshot      = "snapshot"
+	WatchKindSnapshotChunk = "snapshot_chunk"
+	WatchKindEvent         = "event"
+	WatchKindEventChunk    = "event_chunk"
+)
+
+// types of the assets tracked by the watch subsystem
+const (
+	AssetDomain    = "domain"
+	AssetSubdomain = "subdomain"
+	AssetDirectory = "directory"
+	AssetFile      = "file"
+	AssetParameter = "parameter"
+)
+
+// WatchAsset is one asset of a target's inventory, Key is unique per type (e.g. sub.test.com/dir1/file.txt) and Hash changes when the asset's own fields change
+type WatchAsset struct {
+	Type string `bson:"type" json:"type"`
+	Key  string `bson:"key" json:"key"`
+	Hash string `bson:"hash" json:"hash"`
+}
+
+// WatchSnapshot is a point-in-time copy of a target's asset inventory, the assets are stored in Chunks WatchSnapshotChunk documents (snapshots taken before chunking have them inline)
+type WatchSnapshot struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind    string             `bson:"kind" json:"kind"`
+	Target  string             `bson:"target" json:"target"`
+	RunID   string             `bson:"run_id" json:"run_id"`
+	TakenAt time.Time          `bson:"taken_at" json:"taken_at"`
+	Counts  map[string]int     `bson:"counts" json:"counts"`
+	Chunks  int                `bson:"chunks,omitempty" json:"chunks,omitempty"`
+	Assets  []WatchAsset       `bson:"assets,omitempty" json:"assets"`
+}
+
+// WatchSnapshotChunk is a slice of the assets of a snapshot, Seq orders the chunks of a snapshot from 0
+type WatchSnapshotChunk struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind       string             `bson:"kind" json:"kind"`
+	SnapshotID primitive.ObjectID `bson:"snapshot_id" json:"snapshot_id"`
+	Seq        int                `bson:"seq" json:"seq"`
+	Assets     []WatchAsset       `bson:"assets" json:"assets"`
+}
+
+// WatchDiff is the difference between two snapshots
+type WatchDiff struct {
+	Added   []WatchAsset `bson:"added" json:"added"`
+	Removed []WatchAsset `bson:"removed" json:"removed"`
+	Changed []WatchAsset `bson:"changed" json:"changed"`
+}
+
+// WatchEvent is a stored diff between two consecutive snapshots of a target, ConsumedBy holds the names of the consumers (e.g. notifio, schedule) that already handled it, the diff is stored in Chunks WatchEventChunk documents (events stored before chunking have it inline)
+type WatchEvent struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind               string             `bson:"kind" json:"kind"`
+	Target             string             `bson:"target" json:"target"`
+	SnapshotID         primitive.ObjectID `bson:"snapshot_id" json:"snapshot_id"`
+	PreviousSnapshotID primitive.ObjectID `bson:"previous_snapshot_id" json:"previous_snapshot_id"`
+	CreatedAt          time.Time          `bson:"created_at" json:"created_at"`
+	Counts             map[string]int     `bson:"counts,omitempty" json:"counts,omitempty"`
+	Chunks             int                `bson:"chunks,omitempty" json:"chunks,omitempty"`
+	WatchDiff          `bson:",inline"`
+	ConsumedBy         []string `bson:"consumed_by" json:"consumed_by"`
+}
+
+// WatchEventChunk is a slice of the diff of an event, Seq orders the chunks of an event from 0
+type WatchEventChunk struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kind      string             `bson:"kind" json:"kind"`
+	EventID   primitive.ObjectID `bson:"event_id" json:"event_id"`
+	Seq       int                `bson:"seq" json:"seq"`
+	WatchDiff `bson:",inline"`
+}
@@ -0,0 +1,54 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Technology is a web technology detected on a url (e.g. nginx 1.18.0)
+type Technology struct {
+	Name       string   `bson:"name" json:"name"`
+	Version    string   `bson:"version,omitempty" json:"version,omitempty"`
+	Categories []string `bson:"categories,omitempty" json:"categories,omitempty"`
+}
+
+// Redirect is one hop of the redirect chain of a probe
+type Redirect struct {
+	URL    string `bson:"url" json:"url"`
+	Status int    `bson:"status" json:"status"`
+}
+
+// EnumRef points from a probe back to the node of the enum doc tree it belongs to
+type EnumRef struct {
+	Database   string `bson:"database" json:"database"`
+	Collection string `bson:"collection" json:"collection"`
+	Domain     string `bson:"domain" json:"domain"`
+	Subdomain  string `bson:"subdomain" json:"subdomain"`
+	Directory  string `bson:"directory,omitempty" json:"directory,omitempty"`
+}
+
+// WebProbe is the latest http probe result of a url, stored in the probes collection of the web database
+type WebProbe struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	URL           string              `bson:"url" json:"url"`
+	Target        string              `bson:"target" json:"target"`
+	Scheme        string              `bson:"scheme" json:"scheme"`
+	Host          string              `bson:"host" json:"host"`
+	Port          int                 `bson:"port" json:"port"`
+	Path          string              `bson:"path" json:"path"`
+	StatusCode    int                 `bson:"status_code" json:"status_code"`
+	Title         string              `bson:"title" json:"title"`
+	ContentLength int64               `bson:"content_length" json:"content_length"`
+	ContentType   string              `bson:"content_type,omitempty" json:"content_type,omitempty"`
+	Headers       map[string][]string `bson:"headers" json:"headers"`
+	HeaderText    string              `bson:"header_text" json:"-"`
+	Technologies  []Technology        `bson:"technologies" json:"technologies"`
+	FaviconHash   string              `bson:"favicon_hash,omitempty" json:"favicon_hash,omitempty"`
+	RedirectChain []Redirect          `bson:"redirect_chain" json:"redirect_chain"`
+	FinalURL      string              `bson:"final_url,omitempty" json:"final_url,omitempty"`
+	BodyHash      string              `bson:"body_hash" json:"body_hash"`
+	Enum          *EnumRef            `bson:"enum,omitempty" json:"enum,omitempty"`
+	FirstSeen     time.Time           `bson:"first_seen" json:"first_seen"`
+	ProbedAt      time.Time           `bson:"probed_at" json:"probed_at"`
+}
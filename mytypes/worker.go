@@ -0,0 +1,49 @@
+package mytypes
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// statuses of a job in the work queue
+const (
+	QueueJobQueued  = "queued"
+	QueueJobRunning = "running"
+	QueueJobDone    = "done"
+	QueueJobDead    = "dead"
+)
+
+// QueueJob is a document in the jobs collection of the worker database, a running job whose LeaseUntil passed becomes visible to other workers again
+type QueueJob struct {
+	ID                   primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Queue                string             `bson:"queue" json:"queue"`
+	Type                 string             `bson:"type" json:"type"`
+	Target               string             `bson:"target,omitempty" json:"target,omitempty"`
+	Payload              bson.M             `bson:"payload,omitempty" json:"payload,omitempty"`
+	Priority             int                `bson:"priority" json:"priority"`
+	RequiredCapabilities []string           `bson:"required_capabilities" json:"required_capabilities"`
+	Status               string             `bson:"status" json:"status"`
+	Attempts             int                `bson:"attempts" json:"attempts"`
+	MaxAttempts          int                `bson:"max_attempts" json:"max_attempts"`
+	BackoffSeconds       int                `bson:"backoff_seconds" json:"backoff_seconds"`
+	VisibleAt            time.Time          `bson:"visible_at" json:"visible_at"`
+	LockedBy             string             `bson:"locked_by,omitempty" json:"locked_by,omitempty"`
+	LeaseUntil           time.Time          `bson:"lease_until" json:"lease_until"`
+	LastError            string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	Result               bson.M             `bson:"result,omitempty" json:"result,omitempty"`
+	CreatedAt            time.Time          `bson:"created_at" json:"created_at"`
+	StartedAt            *time.Time         `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	FinishedAt           *time.Time         `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// Worker is a registered Healer worker in the workers collection of the worker database
+type Worker struct {
+	WorkerID      string    `bson:"_id" json:"worker_id"`
+	Hostname      string    `bson:"hostname" json:"hostname"`
+	Queues        []string  `bson:"queues" json:"queues"`
+	Capabilities  []string  `bson:"capabilities" json:"capabilities"`
+	StartedAt     time.Time `bson:"started_at" json:"started_at"`
+	LastHeartbeat time.Time `bson:"last_heartbeat" json:"last_heartbeat"`
+}
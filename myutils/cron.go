@@ -0,0 +1,168 @@
+package myutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Cron                     ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// CronSchedule is a parsed 5-field cron expression (minute hour day-of-month month day-of-week), every field is a bitmask of the allowed values
+type CronSchedule struct {
+	Expr   string
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// whether day-of-month or day-of-week was '*', standard cron matches either field when both are restricted
+	domStar bool
+	dowStar bool
+}
+
+// shortcuts accepted in place of a 5-field expression
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+var cronDayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// function parseCronValue to parse one value of a cron field, names (jan, mon, ...) are accepted where given
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// function parseCronField to parse a cron field (e.g. '*', '*/5', '1,15', '9-17', 'mon-fri') into a bitmask of allowed values between min and max
+func parseCronField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			if i := strings.Index(part, "-"); i >= 0 {
+				var err error
+				if lo, err = parseCronValue(part[:i], names); err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+				if hi, err = parseCronValue(part[i+1:], names); err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			} else {
+				v, err := parseCronValue(part, names)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+				lo = v
+				hi = v
+				if step > 1 {
+					hi = max
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range in %q (%d-%d)", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// function ParseCron to parse a standard 5-field cron expression or one of the @hourly/@daily/@weekly/@monthly/@yearly macros, returns a pointer to the schedule and an error
+func ParseCron(expr string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("[-] Error parsing cron expression %q: expected 5 fields", expr)
+	}
+
+	sched := &CronSchedule{Expr: expr, domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if sched.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("[-] Error parsing cron minute: %v", err)
+	}
+	if sched.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("[-] Error parsing cron hour: %v", err)
+	}
+	if sched.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("[-] Error parsing cron day of month: %v", err)
+	}
+	if sched.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("[-] Error parsing cron month: %v", err)
+	}
+	// 7 is accepted as sunday as well
+	if sched.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, fmt.Errorf("[-] Error parsing cron day of week: %v", err)
+	}
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+
+	return sched, nil
+}
+
+// function dayMatches to check the day-of-month and day-of-week fields, if both are restricted either of them may match
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// function Next to get the first time strictly after t matching the schedule (in t's location), returns the zero time if nothing matches in the next 5 years
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
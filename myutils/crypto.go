@@ -0,0 +1,102 @@
+package myutils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// environment variables the master key is read from
+const (
+	MasterKeyEnv     = "HEALERDB_MASTER_KEY"
+	MasterKeyFileEnv = "HEALERDB_MASTER_KEY_FILE"
+)
+
+// function RandomBytes to read n random bytes from crypto/rand, returns the bytes and an error
+func RandomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error reading random bytes: %v", err)
+	}
+	return b, nil
+}
+
+// function EncryptAESGCM to encrypt plaintext with a 32-byte key using AES-256-GCM, aad is authenticated but not encrypted, returns the ciphertext, the nonce and an error
+func EncryptAESGCM(key []byte, plaintext []byte, aad []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("[-] Error creating cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("[-] Error creating cipher: %v", err)
+	}
+	nonce, err := RandomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm.Seal(nil, nonce, plaintext, aad), nonce, nil
+}
+
+// function DecryptAESGCM to decrypt a ciphertext produced by EncryptAESGCM, returns the plaintext and an error
+func DecryptAESGCM(key []byte, ciphertext []byte, nonce []byte, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error creating cipher: %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error creating cipher: %v", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("[-] Error decrypting: invalid nonce size")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error decrypting: %v", err)
+	}
+	return plaintext, nil
+}
+
+// function ParseMasterKey to decode a 32-byte master key given as hex or base64, returns the key and an error
+func ParseMasterKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err := hex.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(encoded); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("[-] Error parsing master key: expected 32 bytes encoded as hex or base64")
+}
+
+// function LoadMasterKey to load the master key from the given file, or if path is empty from the HEALERDB_MASTER_KEY env var, or from the file named by HEALERDB_MASTER_KEY_FILE, returns the key and an error
+func LoadMasterKey(path string) ([]byte, error) {
+	if path == "" {
+		if encoded := os.Getenv(MasterKeyEnv); encoded != "" {
+			return ParseMasterKey(encoded)
+		}
+		path = os.Getenv(MasterKeyFileEnv)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("[-] Error loading master key: set %s or %s", MasterKeyEnv, MasterKeyFileEnv)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error loading master key: %v", err)
+	}
+	return ParseMasterKey(string(data))
+}
+
+// function KeyID to get a short non-secret identifier of a key, used to know which master key wrapped a data key
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("healerdb-key-id:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,343 @@
+package myutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		JSON Schema              ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// the subset of JSON Schema understood here: type, enum, const, properties, required, additionalProperties, items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum, allOf, anyOf, oneOf
+
+// function ParseJSONSchema to decode a JSON Schema document, returns the schema as a map and an error
+func ParseJSONSchema(schema string) (map[string]interface{}, error) {
+	parsed := map[string]interface{}{}
+	err := json.Unmarshal([]byte(schema), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error parsing json schema: %v", err)
+	}
+	return parsed, nil
+}
+
+// function ValidateJSON to validate a json document against a JSON Schema, returns the list of violations (empty if the document is valid) and an error if either input isn't valid json
+func ValidateJSON(schema string, document string) ([]string, error) {
+	parsed, err := ParseJSONSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal([]byte(document), &value)
+	if err != nil {
+		return nil, fmt.Errorf("[-] Error parsing json document: %v", err)
+	}
+	return ValidateValue(parsed, value), nil
+}
+
+// function ValidateValue to validate a decoded json value (as produced by encoding/json) against a parsed schema, returns the list of violations
+func ValidateValue(schema map[string]interface{}, value interface{}) []string {
+	return validateAt("$", schema, value)
+}
+
+// function jsonType to get the JSON Schema type name of a decoded json value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+// function SchemaTypes to get the types a schema allows, returns nil if the schema doesn't restrict the type
+func SchemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		types := []string{}
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// function typeAllowed to check a value type against the allowed types, integers are numbers too
+func typeAllowed(types []string, actual string) bool {
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// function schemaNumber to read a numeric keyword of a schema
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+// function validateAt to validate a value at the given json path, returns the violations
+func validateAt(path string, schema map[string]interface{}, value interface{}) []string {
+	errs := []string{}
+	actual := jsonType(value)
+
+	if types := SchemaTypes(schema); types != nil && !typeAllowed(types, actual) {
+		return append(errs, fmt.Sprintf("%s: expected type %s, got %s", path, strings.Join(types, "|"), actual))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: value is not one of the allowed values", path))
+		}
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		errs = append(errs, fmt.Sprintf("%s: value doesn't match const", path))
+	}
+
+	switch v := value.(type) {
+	case string:
+		if n, ok := schemaNumber(schema, "minLength"); ok && float64(len([]rune(v))) < n {
+			errs = append(errs, fmt.Sprintf("%s: shorter than %v", path, n))
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && float64(len([]rune(v))) > n {
+			errs = append(errs, fmt.Sprintf("%s: longer than %v", path, n))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid pattern in schema: %v", path, err))
+			} else if !re.MatchString(v) {
+				errs = append(errs, fmt.Sprintf("%s: doesn't match pattern %s", path, pattern))
+			}
+		}
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			errs = append(errs, fmt.Sprintf("%s: less than %v", path, n))
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			errs = append(errs, fmt.Sprintf("%s: greater than %v", path, n))
+		}
+	case []interface{}:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			errs = append(errs, fmt.Sprintf("%s: fewer than %v items", path, n))
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			errs = append(errs, fmt.Sprintf("%s: more than %v items", path, n))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateAt(fmt.Sprintf("%s[%d]", path, i), items, item)...)
+			}
+		}
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if name, ok := r.(string); ok {
+					if _, present := v[name]; !present {
+						errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+					}
+				}
+			}
+		}
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if propschema, ok := properties[k].(map[string]interface{}); ok {
+				errs = append(errs, validateAt(path+"."+k, propschema, v[k])...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s: property %q is not allowed", path, k))
+				}
+			case map[string]interface{}:
+				errs = append(errs, validateAt(path+"."+k, additional, v[k])...)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if subschema, ok := sub.(map[string]interface{}); ok {
+				errs = append(errs, validateAt(path, subschema, value)...)
+			}
+		}
+	}
+	if anyof, ok := schema["anyOf"].([]interface{}); ok && countMatching(path, anyof, value) == 0 {
+		errs = append(errs, fmt.Sprintf("%s: doesn't match any schema of anyOf", path))
+	}
+	if oneof, ok := schema["oneOf"].([]interface{}); ok && countMatching(path, oneof, value) != 1 {
+		errs = append(errs, fmt.Sprintf("%s: doesn't match exactly one schema of oneOf", path))
+	}
+
+	return errs
+}
+
+// function countMatching to count the subschemas the value is valid against
+func countMatching(path string, schemas []interface{}, value interface{}) int {
+	count := 0
+	for _, sub := range schemas {
+		if subschema, ok := sub.(map[string]interface{}); ok && len(validateAt(path, subschema, value)) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// function requiredSet to get the required property names of a schema
+func requiredSet(schema map[string]interface{}) map[string]bool {
+	set := map[string]bool{}
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				set[name] = true
+			}
+		}
+	}
+	return set
+}
+
+// function CompareSchemas to list the breaking changes between an old and a new version of a schema, for an input schema (input true) the new one must still accept everything the old one accepted, for an output schema every document valid under the new one must still be valid for readers of the old one, returns the breaking changes (empty if compatible)
+func CompareSchemas(old map[string]interface{}, new map[string]interface{}, input bool) []string {
+	return compareAt("$", old, new, input)
+}
+
+// function compareAt to compare two schemas at the given json path
+func compareAt(path string, old map[string]interface{}, new map[string]interface{}, input bool) []string {
+	issues := []string{}
+
+	// everything the inner schema allows must be allowed by the outer one, an input may only widen and an output may only narrow
+	inner, outer := new, old
+	if input {
+		inner, outer = old, new
+	}
+
+	innertypes, outertypes := SchemaTypes(inner), SchemaTypes(outer)
+	if outertypes != nil {
+		if innertypes == nil {
+			issues = append(issues, fmt.Sprintf("%s: type changed from any to %s", path, strings.Join(outertypes, "|")))
+		}
+		for _, t := range innertypes {
+			if !typeAllowed(outertypes, t) {
+				issues = append(issues, fmt.Sprintf("%s: type %s is not compatible", path, t))
+			}
+		}
+	}
+
+	innerenum, innerok := inner["enum"].([]interface{})
+	outerenum, outerok := outer["enum"].([]interface{})
+	if outerok {
+		if !innerok {
+			issues = append(issues, fmt.Sprintf("%s: enum restriction is not compatible", path))
+		}
+		for _, v := range innerenum {
+			found := false
+			for _, w := range outerenum {
+				if reflect.DeepEqual(v, w) {
+					found = true
+				}
+			}
+			if !found {
+				issues = append(issues, fmt.Sprintf("%s: enum value %v is not compatible", path, v))
+			}
+		}
+	}
+
+	oldreq, newreq := requiredSet(old), requiredSet(new)
+	oldprops, _ := old["properties"].(map[string]interface{})
+	newprops, _ := new["properties"].(map[string]interface{})
+	if input {
+		for name := range newreq {
+			if !oldreq[name] {
+				issues = append(issues, fmt.Sprintf("%s: property %q became required", path, name))
+			}
+		}
+		if additional, ok := new["additionalProperties"].(bool); ok && !additional {
+			for name := range oldprops {
+				if _, ok := newprops[name]; !ok {
+					issues = append(issues, fmt.Sprintf("%s: property %q is no longer accepted", path, name))
+				}
+			}
+		}
+	} else {
+		for name := range oldreq {
+			if !newreq[name] {
+				issues = append(issues, fmt.Sprintf("%s: property %q is no longer guaranteed", path, name))
+			}
+		}
+		// readers of an old schema without additional properties reject any property it didn't declare
+		if additional, ok := old["additionalProperties"].(bool); ok && !additional {
+			added := []string{}
+			for name := range newprops {
+				if _, ok := oldprops[name]; !ok {
+					added = append(added, name)
+				}
+			}
+			sort.Strings(added)
+			for _, name := range added {
+				issues = append(issues, fmt.Sprintf("%s: property %q is not accepted by readers of the old schema", path, name))
+			}
+			if newadditional, ok := new["additionalProperties"].(bool); !ok || newadditional {
+				issues = append(issues, fmt.Sprintf("%s: additional properties are not accepted by readers of the old schema", path))
+			}
+		}
+	}
+	names := []string{}
+	for name := range oldprops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		oldprop, ok1 := oldprops[name].(map[string]interface{})
+		newprop, ok2 := newprops[name].(map[string]interface{})
+		if ok1 && ok2 {
+			issues = append(issues, compareAt(path+"."+name, oldprop, newprop, input)...)
+		}
+	}
+
+	olditems, ok1 := old["items"].(map[string]interface{})
+	newitems, ok2 := new["items"].(map[string]interface{})
+	if ok1 && ok2 {
+		issues = append(issues, compareAt(path+"[]", olditems, newitems, input)...)
+	}
+
+	return issues
+}
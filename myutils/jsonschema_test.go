@@ -0,0 +1,136 @@
+package myutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustSchema(t *testing.T, schema string) map[string]interface{} {
+	t.Helper()
+	parsed, err := ParseJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestCompareSchemas(t *testing.T) {
+	tests := []struct {
+		name  string
+		old   string
+		new   string
+		input bool
+		want  []string // a substring of every expected issue, nil for a compatible change
+	}{
+		{
+			name: "output adds a property to an open schema",
+			old:  `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "string"}}}`,
+		},
+		{
+			name: "output adds a property to a closed schema",
+			old:  `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "string"}}, "additionalProperties": false}`,
+			want: []string{`property "b" is not accepted`},
+		},
+		{
+			name: "output opens a closed schema",
+			old:  `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+			want: []string{"additional properties are not accepted"},
+		},
+		{
+			name:  "input adds a property to a closed schema",
+			old:   `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`,
+			new:   `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "string"}}, "additionalProperties": false}`,
+			input: true,
+		},
+		{
+			name:  "input removes a property from a closed schema",
+			old:   `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "string"}}, "additionalProperties": false}`,
+			new:   `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`,
+			input: true,
+			want:  []string{`property "b" is no longer accepted`},
+		},
+		{
+			name: "output removes an optional property",
+			old:  `{"type": "object", "properties": {"a": {"type": "string"}, "b": {"type": "string"}}}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+		},
+		{
+			name: "output removes a required property",
+			old:  `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`,
+			new:  `{"type": "object", "properties": {}}`,
+			want: []string{`property "a" is no longer guaranteed`},
+		},
+		{
+			name:  "input makes a property required",
+			old:   `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+			new:   `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`,
+			input: true,
+			want:  []string{`property "a" became required`},
+		},
+		{
+			name: "output makes a property required",
+			old:  `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`,
+		},
+		{
+			name:  "input narrows a type",
+			old:   `{"type": "object", "properties": {"a": {"type": ["string", "integer"]}}}`,
+			new:   `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+			input: true,
+			want:  []string{"$.a: type integer is not compatible"},
+		},
+		{
+			name: "output narrows a type",
+			old:  `{"type": "object", "properties": {"a": {"type": ["string", "integer"]}}}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "string"}}}`,
+		},
+		{
+			name: "output widens a type",
+			old:  `{"type": "object", "properties": {"a": {"type": "integer"}}}`,
+			new:  `{"type": "object", "properties": {"a": {"type": "number"}}}`,
+			want: []string{"$.a: type number is not compatible"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues := CompareSchemas(mustSchema(t, tt.old), mustSchema(t, tt.new), tt.input)
+			if len(issues) != len(tt.want) {
+				t.Fatalf("issues = %q, want %d", issues, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(issues[i], want) {
+					t.Errorf("issue %d = %q, want it to contain %q", i, issues[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateValueAdditionalProperties(t *testing.T) {
+	tests := []struct {
+		name     string
+		schema   string
+		document string
+		want     int
+	}{
+		{"declared property", `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`, `{"a": "x"}`, 0},
+		{"undeclared property of a closed schema", `{"type": "object", "properties": {"a": {"type": "string"}}, "additionalProperties": false}`, `{"a": "x", "b": "y"}`, 1},
+		{"undeclared property of an open schema", `{"type": "object", "properties": {"a": {"type": "string"}}}`, `{"a": "x", "b": "y"}`, 0},
+		{"additional property schema", `{"type": "object", "additionalProperties": {"type": "integer"}}`, `{"a": 1, "b": "y"}`, 1},
+		{"missing required property", `{"type": "object", "properties": {"a": {"type": "string"}}, "required": ["a"]}`, `{}`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := ValidateJSON(tt.schema, tt.document)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(violations) != tt.want {
+				t.Errorf("violations = %q, want %d", violations, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package myutils
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+////////                                 ////////
+////////  		Passwords                ////////
+////////                                 ////////
+/////////////////////////////////////////////////
+/////////////////////////////////////////////////
+
+// PasswordParams are the argon2id parameters used for new password hashes
+type PasswordParams struct {
+	Memory  uint32 // in KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultPasswordParams are used by HashPassword, hashes made with other parameters are reported by NeedsRehash
+var DefaultPasswordParams = PasswordParams{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// prefixes of the supported hash formats, the wrapped format is argon2id over the legacy unsalted sha256 hex digest
+const (
+	argon2idPrefix        = "$argon2id$"
+	wrappedArgon2idPrefix = "$sha256-argon2id$"
+)
+
+// function HashPassword to hash a password with argon2id and DefaultPasswordParams, returns the hash in the PHC string format ($argon2id$v=19$m=...,t=...,p=...$salt$hash) and an error
+func HashPassword(password string) (string, error) {
+	return hashArgon2id(argon2idPrefix, password, DefaultPasswordParams)
+}
+
+// function hashArgon2id to hash a secret with argon2id and the given params, prefix selects the stored format
+func hashArgon2id(prefix string, secret string, params PasswordParams) (string, error) {
+	salt, err := RandomBytes(int(params.SaltLen))
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(secret), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		prefix,
+		argon2.Version,
+		params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// function parseArgon2id to split a PHC argon2id hash (without its prefix) into params, salt and key
+func parseArgon2id(rest string) (PasswordParams, []byte, []byte, error) {
+	params := PasswordParams{}
+	parts := strings.Split(rest, "$")
+	if len(parts) != 4 {
+		return params, nil, nil, fmt.Errorf("[-] Error parsing password hash: invalid format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("[-] Error parsing password hash: unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("[-] Error parsing password hash: invalid parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("[-] Error parsing password hash: invalid salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("[-] Error parsing password hash: invalid key")
+	}
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// function verifyArgon2id to check a secret against a PHC argon2id hash (without its prefix) in constant time
+func verifyArgon2id(secret string, rest string) (bool, error) {
+	params, salt, key, err := parseArgon2id(rest)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(secret), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// function IsLegacyPasswordHash to check whether a stored hash is an unsalted sha256 hex digest made by HashString
+func IsLegacyPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// function VerifyPassword to check a password against a stored hash in constant time, argon2id hashes, wrapped legacy hashes and plain legacy sha256 hashes are supported, returns whether the password matches and an error for unknown or broken hashes
+func VerifyPassword(password string, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		return verifyArgon2id(password, strings.TrimPrefix(hash, argon2idPrefix))
+	case strings.HasPrefix(hash, wrappedArgon2idPrefix):
+		return verifyArgon2id(HashString(password), strings.TrimPrefix(hash, wrappedArgon2idPrefix))
+	case IsLegacyPasswordHash(hash):
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(hash)), []byte(HashString(password))) == 1, nil
+	}
+	return false, fmt.Errorf("[-] Error verifying password: unknown hash format")
+}
+
+// function NeedsRehash to check whether a stored hash should be replaced by a fresh HashPassword hash, true for legacy and wrapped hashes and for argon2id hashes made with other parameters than DefaultPasswordParams
+func NeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+	params, _, _, err := parseArgon2id(strings.TrimPrefix(hash, argon2idPrefix))
+	if err != nil {
+		return true
+	}
+	return params != DefaultPasswordParams
+}
+
+// function WrapLegacyPasswordHash to protect an unsalted sha256 hash without knowing the password by hashing the digest itself with argon2id, VerifyPassword accepts the result and NeedsRehash reports it so it is replaced on the next login, returns the wrapped hash and an error
+func WrapLegacyPasswordHash(hash string) (string, error) {
+	if !IsLegacyPasswordHash(hash) {
+		return "", fmt.Errorf("[-] Error wrapping password hash: not a legacy sha256 hash")
+	}
+	return hashArgon2id(wrappedArgon2idPrefix, strings.ToLower(hash), DefaultPasswordParams)
+}